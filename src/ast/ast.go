@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 
 	"monkey/token"
@@ -10,6 +11,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // where the node starts in the source
 }
 
 type Statement interface {
@@ -24,6 +26,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Resolved   bool // set once the resolver has annotated the identifiers
 }
 
 func (p *Program) TokenLiteral() string {
@@ -34,6 +37,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 && p.Statements[0] != nil {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -45,18 +55,36 @@ func (p *Program) String() string {
 
 // let statement
 type LetStatement struct {
-	Token token.Token // token.LET
-	Name  *Identifier
-	Value Expression
+	Token    token.Token // token.LET
+	Name     *Identifier // nil if Pattern is set
+	Pattern  Pattern     // an array or hash pattern destructuring Value, or nil
+	Value    Expression
+	Exported bool // written as `export let`
+}
+
+// Bound returns the identifiers the let statement binds.
+func (ls *LetStatement) Bound() []*Identifier {
+	if ls.Pattern != nil {
+		return PatternBindings(ls.Pattern)
+	}
+	return []*Identifier{ls.Name}
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
+	if ls.Exported {
+		out.WriteString("export ")
+	}
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -66,13 +94,84 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// import statement: import "path" as name; or import { a, b as c } from "path";
+type ImportStatement struct {
+	Token token.Token // token.IMPORT
+	Path  *StringLiteral
+	Alias *Identifier   // binds the module, nil for selective imports
+	Names []*ImportName // the exports bound by a selective import
+}
+
+// ImportName is an export named by a selective import, bound to Alias or, if
+// there is none, to its own name.
+type ImportName struct {
+	Name  *Identifier
+	Alias *Identifier
+}
+
+// Bound returns the identifier the export is bound to.
+func (in *ImportName) Bound() *Identifier {
+	if in.Alias != nil {
+		return in.Alias
+	}
+	return in.Name
+}
+
+// Bound returns the identifiers the import statement binds.
+func (is *ImportStatement) Bound() []*Identifier {
+	if is.Alias != nil {
+		return []*Identifier{is.Alias}
+	}
+	idents := make([]*Identifier, len(is.Names))
+	for i, name := range is.Names {
+		idents[i] = name.Bound()
+	}
+	return idents
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Pos() token.Position  { return is.Token.Pos }
+func (is *ImportStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("import ")
+	if is.Names != nil {
+		names := []string{}
+		for _, name := range is.Names {
+			if name.Alias != nil {
+				names = append(names, name.Name.String()+" as "+name.Alias.String())
+			} else {
+				names = append(names, name.Name.String())
+			}
+		}
+		out.WriteString("{ " + strings.Join(names, ", ") + " } from ")
+	}
+	out.WriteString(strconv.Quote(is.Path.Value))
+	if is.Alias != nil {
+		out.WriteString(" as " + is.Alias.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type Identifier struct {
 	Token token.Token // token.IDENT
 	Value string
+
+	// Where the binding of the identifier lives, filled in by the resolver:
+	// Depth function scopes up, in Slot, or looked up by name when Slot is
+	// negative.
+	Resolved bool
+	Depth    int
+	Slot     int
 }
 
 func (i *Identifier) expressionNode()      {}
+func (i *Identifier) patternNode()         {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 // return statement
@@ -83,6 +182,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -95,6 +195,28 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement raises an error carrying Value, which try expressions can
+// catch.
+type ThrowStatement struct {
+	Token token.Token // token.THROW
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("throw ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
 // expression statement
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
@@ -103,6 +225,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -117,7 +240,9 @@ type IntegerLiteral struct {
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
+func (il *IntegerLiteral) patternNode()         {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 // Prefix Expression
@@ -129,6 +254,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -150,6 +276,12 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -169,7 +301,9 @@ type Boolean struct {
 }
 
 func (b *Boolean) expressionNode()      {}
+func (b *Boolean) patternNode()         {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // if expression
@@ -182,6 +316,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -198,14 +333,49 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// TryExpression evaluates Block and, if it fails, binds the error to Param
+// and evaluates Catch. Finally is evaluated in any case. Either Catch or
+// Finally may be nil, but not both.
+type TryExpression struct {
+	Token   token.Token // token.TRY
+	Block   *BlockStatement
+	Param   *Identifier
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString("catch(")
+		out.WriteString(te.Param.String())
+		out.WriteString(")")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString("finally")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
 // Block Statement
 type BlockStatement struct {
-	Token      token.Token // token.LPAREN
+	Token      token.Token // token.LBRACE
 	Statements []Statement
+	Rbrace     token.Token // the closing '}'
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -220,10 +390,25 @@ type FunctionLiteral struct {
 	Token  token.Token // token.FUNCTION
 	Params []*Identifier
 	Body   *BlockStatement
+	Locals []string // names of the slots of a call, filled in by the resolver
+
+	// Patterns destructure the arguments of the parameters with the same
+	// index, if they are not nil, and the parameters themselves are named
+	// by ParameterOf. Patterns is nil if no parameter is destructured.
+	Patterns []Pattern
+}
+
+// ParameterOf returns the identifier standing in the parameters of a
+// function for an argument destructured by pattern. Its name is the
+// pattern's source, which no other identifier can have.
+func ParameterOf(pattern Pattern) *Identifier {
+	name := pattern.String()
+	return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Pos: pattern.Pos()}, Value: name}
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -241,15 +426,49 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// Macro Literal
+type MacroLiteral struct {
+	Token  token.Token // token.MACRO
+	Params []*Identifier
+	Body   *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, param := range ml.Params {
+		params = append(params, param.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	out.WriteString(ml.Body.String())
+
+	return out.String()
+}
+
 // Function Calls
 type CallExpression struct {
 	Token     token.Token // token.LPAREN
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	Rparen    token.Token // the closing ')'
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -272,17 +491,21 @@ type StringLiteral struct {
 }
 
 func (sl *StringLiteral) expressionNode()      {}
+func (sl *StringLiteral) patternNode()         {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 // Array Literal
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	Rbracket token.Token // the closing ']'
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -307,6 +530,12 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -318,3 +547,231 @@ func (ie *IndexExpression) String() string {
 
 	return out.String()
 }
+
+// Selector Expression: left.name, which selects an export of a module or
+// the value of a hash under the string key name
+type SelectorExpression struct {
+	Token    token.Token // token.DOT
+	Left     Expression
+	Selector *Identifier
+}
+
+func (se *SelectorExpression) expressionNode()      {}
+func (se *SelectorExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SelectorExpression) Pos() token.Position {
+	if se.Left != nil {
+		return se.Left.Pos()
+	}
+	return se.Token.Pos
+}
+func (se *SelectorExpression) String() string {
+	return se.Left.String() + "." + se.Selector.String()
+}
+
+// Hash Literal
+type HashLiteral struct {
+	Token  token.Token // token.LBRACE
+	Pairs  []HashPair  // in source order
+	Rbrace token.Token // the closing '}'
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// MatchExpression evaluates the body of the first of Arms whose pattern
+// matches Value and whose guard, if it has one, is truthy.
+type MatchExpression struct {
+	Token  token.Token // token.MATCH
+	Value  Expression
+	Arms   []*MatchArm
+	Rbrace token.Token // the closing '}'
+}
+
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil if there is none
+	Body    Expression
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		s := arm.Pattern.String()
+		if arm.Guard != nil {
+			s += " if " + arm.Guard.String()
+		}
+		arms = append(arms, s+" => "+arm.Body.String())
+	}
+
+	out.WriteString("match(")
+	out.WriteString(me.Value.String())
+	out.WriteString("){")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Pattern is the shape of values, which a value either matches or not.
+// Identifiers match any value and bind it, except for _, which binds
+// nothing. Integer, string and boolean literals match equal values.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// ArrayPattern matches arrays with as many elements as Elements, each
+// matching its pattern, or more elements if there is a Rest, which binds an
+// array of those left over.
+type ArrayPattern struct {
+	Token    token.Token // token.LBRACKET
+	Elements []Pattern
+	Rest     *Identifier // written after "...", nil if there is none
+	Rbracket token.Token // the closing ']'
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) String() string {
+	elems := []string{}
+	for _, elem := range ap.Elements {
+		elems = append(elems, elem.String())
+	}
+	if ap.Rest != nil {
+		elems = append(elems, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// DefaultPattern, written "pattern = default", is an element of an array or
+// hash pattern that destructures Default, evaluated, if the element is
+// missing. Defaults are only allowed in let statements and parameters.
+type DefaultPattern struct {
+	Token   token.Token // token.ASSIGN
+	Pattern Pattern
+	Default Expression
+}
+
+func (dp *DefaultPattern) patternNode()         {}
+func (dp *DefaultPattern) TokenLiteral() string { return dp.Token.Literal }
+func (dp *DefaultPattern) Pos() token.Position  { return dp.Pattern.Pos() }
+func (dp *DefaultPattern) String() string {
+	return dp.Pattern.String() + " = " + dp.Default.String()
+}
+
+// HashPattern matches hashes that have all the keys of Pairs, with values
+// matching their patterns. Other keys are allowed, and bound as a hash by
+// Rest if there is one.
+type HashPattern struct {
+	Token  token.Token // token.LBRACE
+	Pairs  []HashPatternPair
+	Rest   *Identifier // written after "...", nil if there is none
+	Rbrace token.Token // the closing '}'
+}
+
+// HashPatternPair is a key of a hash pattern, which is an integer, string or
+// boolean literal, and the pattern its value must match.
+type HashPatternPair struct {
+	Key   Expression
+	Value Pattern
+}
+
+// Shorthand reports whether the pair was written as just the identifier it
+// binds, which is also its key.
+func (pair HashPatternPair) Shorthand() bool {
+	key, ok := pair.Key.(*StringLiteral)
+	return ok && key.Token.Type == token.IDENT
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, pair := range hp.Pairs {
+		if pair.Shorthand() {
+			pairs = append(pairs, pair.Value.String())
+			continue
+		}
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
+	}
+	if hp.Rest != nil {
+		pairs = append(pairs, "..."+hp.Rest.String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// TypePattern, written "pattern is TYPE", matches values of the type named
+// by Type, such as INTEGER, that match Pattern.
+type TypePattern struct {
+	Token   token.Token // the identifier "is"
+	Pattern Pattern
+	Type    *Identifier
+}
+
+func (tp *TypePattern) patternNode()         {}
+func (tp *TypePattern) TokenLiteral() string { return tp.Token.Literal }
+func (tp *TypePattern) Pos() token.Position  { return tp.Pattern.Pos() }
+func (tp *TypePattern) String() string {
+	return tp.Pattern.String() + " is " + tp.Type.String()
+}
+
+// PatternBindings returns the identifiers bound by pattern, in source order.
+func PatternBindings(pattern Pattern) []*Identifier {
+	var idents []*Identifier
+	var bind func(p Pattern)
+	bind = func(p Pattern) {
+		switch p := p.(type) {
+		case *Identifier:
+			if p.Value != "_" {
+				idents = append(idents, p)
+			}
+		case *ArrayPattern:
+			for _, el := range p.Elements {
+				bind(el)
+			}
+			if p.Rest != nil {
+				bind(p.Rest)
+			}
+		case *HashPattern:
+			for _, pair := range p.Pairs {
+				bind(pair.Value)
+			}
+			if p.Rest != nil {
+				bind(p.Rest)
+			}
+		case *TypePattern:
+			bind(p.Pattern)
+		case *DefaultPattern:
+			bind(p.Pattern)
+		}
+	}
+	bind(pattern)
+	return idents
+}
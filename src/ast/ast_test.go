@@ -1,8 +1,12 @@
 package ast
 
 import (
-	"monkey/token"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+
+	"monkey/token"
 )
 
 func TestString(t *testing.T) {
@@ -35,3 +39,440 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok {
+			return node
+		}
+		if integer.Value != 1 {
+			return node
+		}
+		integer.Value = 2
+		return integer
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+		},
+		{
+			&InfixExpression{Left: one(), Operator: "+", Right: two()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&InfixExpression{Left: two(), Operator: "+", Right: one()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&PrefixExpression{Operator: "-", Right: one()},
+			&PrefixExpression{Operator: "-", Right: two()},
+		},
+		{
+			&IndexExpression{Left: one(), Index: one()},
+			&IndexExpression{Left: two(), Index: two()},
+		},
+		{
+			&IfExpression{
+				Condition:   one(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&IfExpression{
+				Condition:   two(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{
+			&ReturnStatement{ReturnValue: one()},
+			&ReturnStatement{ReturnValue: two()},
+		},
+		{
+			&LetStatement{Name: &Identifier{Value: "x"}, Value: one()},
+			&LetStatement{Name: &Identifier{Value: "x"}, Value: two()},
+		},
+		{
+			&FunctionLiteral{Params: []*Identifier{}, Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&FunctionLiteral{Params: []*Identifier{}, Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&MacroLiteral{Params: []*Identifier{}, Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&MacroLiteral{Params: []*Identifier{}, Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{one(), two()}},
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{two(), two()}},
+		},
+		{
+			&ArrayLiteral{Elements: []Expression{one(), one()}},
+			&ArrayLiteral{Elements: []Expression{two(), two()}},
+		},
+		{
+			&HashLiteral{Pairs: []HashPair{{Key: one(), Value: one()}}},
+			&HashLiteral{Pairs: []HashPair{{Key: two(), Value: two()}}},
+		},
+	}
+
+	for _, tt := range tests {
+		before := Modify(tt.input, func(node Node) Node { return node })
+		modified := Modify(tt.input, turnOneIntoTwo)
+
+		if !reflect.DeepEqual(modified, tt.expected) {
+			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.expected)
+		}
+		if !reflect.DeepEqual(tt.input, before) {
+			t.Errorf("input was modified. got=%#v, want=%#v", tt.input, before)
+		}
+	}
+}
+
+// everyNode returns a program that contains every type of node:
+//
+//	let f = fn(x) { return -x; };
+//	let m = macro(y) { y };
+//	if (f(1) < 2) { [true, "s"][0] } else { {"k": f}["k"] }
+//	import "m" as mod;
+//	import { a, b as c } from "m";
+//	mod.a;
+//	throw try { 1 } catch (e) { e } finally { 2 };
+//	match (x) { [1, ...r] => r, {"k": v is INTEGER} if v => v, _ => 0 }
+//	let [p, q = 1] = f;
+//	fn({"k": w}) { w }
+func everyNode() *Program {
+	param := &HashPattern{Pairs: []HashPatternPair{{Key: &StringLiteral{Value: "k"}, Value: &Identifier{Value: "w"}}}}
+
+	return &Program{Statements: []Statement{
+		&LetStatement{
+			Name: &Identifier{Value: "f"},
+			Value: &FunctionLiteral{
+				Params: []*Identifier{{Value: "x"}},
+				Body: &BlockStatement{Statements: []Statement{
+					&ReturnStatement{ReturnValue: &PrefixExpression{Operator: "-", Right: &Identifier{Value: "x"}}},
+				}},
+			},
+		},
+		&LetStatement{
+			Name: &Identifier{Value: "m"},
+			Value: &MacroLiteral{
+				Params: []*Identifier{{Value: "y"}},
+				Body: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &Identifier{Value: "y"}},
+				}},
+			},
+		},
+		&ExpressionStatement{Expression: &IfExpression{
+			Condition: &InfixExpression{
+				Left:     &CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{&IntegerLiteral{Value: 1}}},
+				Operator: "<",
+				Right:    &IntegerLiteral{Value: 2},
+			},
+			Consequence: &BlockStatement{Statements: []Statement{
+				&ExpressionStatement{Expression: &IndexExpression{
+					Left:  &ArrayLiteral{Elements: []Expression{&Boolean{Value: true}, &StringLiteral{Value: "s"}}},
+					Index: &IntegerLiteral{Value: 0},
+				}},
+			}},
+			Alternative: &BlockStatement{Statements: []Statement{
+				&ExpressionStatement{Expression: &IndexExpression{
+					Left:  &HashLiteral{Pairs: []HashPair{{Key: &StringLiteral{Value: "k"}, Value: &Identifier{Value: "f"}}}},
+					Index: &StringLiteral{Value: "k"},
+				}},
+			}},
+		}},
+		&ImportStatement{Path: &StringLiteral{Value: "m"}, Alias: &Identifier{Value: "mod"}},
+		&ImportStatement{
+			Path:  &StringLiteral{Value: "m"},
+			Names: []*ImportName{{Name: &Identifier{Value: "a"}}, {Name: &Identifier{Value: "b"}, Alias: &Identifier{Value: "c"}}},
+		},
+		&ExpressionStatement{Expression: &SelectorExpression{Left: &Identifier{Value: "mod"}, Selector: &Identifier{Value: "a"}}},
+		&ThrowStatement{Value: &TryExpression{
+			Block:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: &IntegerLiteral{Value: 1}}}},
+			Param:   &Identifier{Value: "e"},
+			Catch:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: &Identifier{Value: "e"}}}},
+			Finally: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: &IntegerLiteral{Value: 2}}}},
+		}},
+		&ExpressionStatement{Expression: &MatchExpression{
+			Value: &Identifier{Value: "x"},
+			Arms: []*MatchArm{
+				{
+					Pattern: &ArrayPattern{Elements: []Pattern{&IntegerLiteral{Value: 1}}, Rest: &Identifier{Value: "r"}},
+					Body:    &Identifier{Value: "r"},
+				},
+				{
+					Pattern: &HashPattern{Pairs: []HashPatternPair{{
+						Key:   &StringLiteral{Value: "k"},
+						Value: &TypePattern{Pattern: &Identifier{Value: "v"}, Type: &Identifier{Value: "INTEGER"}},
+					}}},
+					Guard: &Identifier{Value: "v"},
+					Body:  &Identifier{Value: "v"},
+				},
+				{Pattern: &Identifier{Value: "_"}, Body: &IntegerLiteral{Value: 0}},
+			},
+		}},
+		&LetStatement{
+			Pattern: &ArrayPattern{Elements: []Pattern{
+				&Identifier{Value: "p"},
+				&DefaultPattern{Pattern: &Identifier{Value: "q"}, Default: &IntegerLiteral{Value: 1}},
+			}},
+			Value: &Identifier{Value: "f"},
+		},
+		&ExpressionStatement{Expression: &FunctionLiteral{
+			Params:   []*Identifier{ParameterOf(param)},
+			Patterns: []Pattern{param},
+			Body: &BlockStatement{Statements: []Statement{
+				&ExpressionStatement{Expression: &Identifier{Value: "w"}},
+			}},
+		}},
+	}}
+}
+
+// nodeNames describes the nodes passed to an inspect function, and the nil
+// passed after the children of a node as ")".
+type nodeNames []string
+
+func (names *nodeNames) inspect(node Node) bool {
+	switch node := node.(type) {
+	case nil:
+		*names = append(*names, ")")
+	case *Identifier:
+		*names = append(*names, "Identifier "+node.Value)
+	default:
+		*names = append(*names, strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast."))
+	}
+	return true
+}
+
+func TestWalk(t *testing.T) {
+	expected := []string{
+		"Program",
+		"LetStatement", "Identifier f", ")",
+		"FunctionLiteral", "Identifier x", ")",
+		"BlockStatement", "ReturnStatement", "PrefixExpression", "Identifier x", ")", ")", ")", ")", ")", ")",
+		"LetStatement", "Identifier m", ")",
+		"MacroLiteral", "Identifier y", ")",
+		"BlockStatement", "ExpressionStatement", "Identifier y", ")", ")", ")", ")", ")",
+		"ExpressionStatement", "IfExpression",
+		"InfixExpression", "CallExpression", "Identifier f", ")", "IntegerLiteral", ")", ")", "IntegerLiteral", ")", ")",
+		"BlockStatement", "ExpressionStatement", "IndexExpression",
+		"ArrayLiteral", "Boolean", ")", "StringLiteral", ")", ")", "IntegerLiteral", ")", ")", ")", ")",
+		"BlockStatement", "ExpressionStatement", "IndexExpression",
+		"HashLiteral", "StringLiteral", ")", "Identifier f", ")", ")", "StringLiteral", ")", ")", ")", ")",
+		")", ")",
+		"ImportStatement", "StringLiteral", ")", "Identifier mod", ")", ")",
+		"ImportStatement", "Identifier a", ")", "Identifier b", ")", "Identifier c", ")", "StringLiteral", ")", ")",
+		"ExpressionStatement", "SelectorExpression", "Identifier mod", ")", "Identifier a", ")", ")", ")",
+		"ThrowStatement", "TryExpression",
+		"BlockStatement", "ExpressionStatement", "IntegerLiteral", ")", ")", ")",
+		"Identifier e", ")",
+		"BlockStatement", "ExpressionStatement", "Identifier e", ")", ")", ")",
+		"BlockStatement", "ExpressionStatement", "IntegerLiteral", ")", ")", ")",
+		")", ")",
+		"ExpressionStatement", "MatchExpression", "Identifier x", ")",
+		"ArrayPattern", "IntegerLiteral", ")", "Identifier r", ")", ")", "Identifier r", ")",
+		"HashPattern", "StringLiteral", ")", "TypePattern", "Identifier v", ")", "Identifier INTEGER", ")", ")", ")",
+		"Identifier v", ")", "Identifier v", ")",
+		"Identifier _", ")", "IntegerLiteral", ")",
+		")", ")",
+		"LetStatement", "ArrayPattern", "Identifier p", ")",
+		"DefaultPattern", "Identifier q", ")", "IntegerLiteral", ")", ")", ")", "Identifier f", ")", ")",
+		"ExpressionStatement", "FunctionLiteral", "HashPattern", "StringLiteral", ")", "Identifier w", ")", ")",
+		"BlockStatement", "ExpressionStatement", "Identifier w", ")", ")", ")", ")", ")",
+		")",
+	}
+
+	var names nodeNames
+	Inspect(everyNode(), names.inspect)
+
+	if !reflect.DeepEqual([]string(names), expected) {
+		t.Errorf("wrong nodes visited.\nwant=%v\ngot= %v", expected, names)
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	var idents []string
+	Inspect(everyNode(), func(node Node) bool {
+		switch node := node.(type) {
+		case *FunctionLiteral, *HashLiteral:
+			return false
+		case *Identifier:
+			idents = append(idents, node.Value)
+		}
+		return true
+	})
+
+	expected := []string{"f", "m", "y", "y", "f", "mod", "a", "b", "c", "mod", "a", "e", "e",
+		"x", "r", "r", "v", "INTEGER", "v", "v", "_", "p", "q", "f"}
+	if !reflect.DeepEqual(idents, expected) {
+		t.Errorf("wrong identifiers. want=%v, got=%v", expected, idents)
+	}
+}
+
+type countingVisitor map[string]int
+
+func (v countingVisitor) Visit(node Node) Visitor {
+	if node != nil {
+		v[strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")]++
+	}
+	return v
+}
+
+func TestWalkVisitor(t *testing.T) {
+	counts := countingVisitor{}
+	Walk(counts, everyNode())
+
+	expected := countingVisitor{
+		"Program": 1, "LetStatement": 3, "ReturnStatement": 1, "ExpressionStatement": 11,
+		"BlockStatement": 8, "Identifier": 29, "IntegerLiteral": 8, "StringLiteral": 7,
+		"Boolean": 1, "PrefixExpression": 1, "InfixExpression": 1, "IfExpression": 1,
+		"FunctionLiteral": 2, "MacroLiteral": 1, "CallExpression": 1, "ArrayLiteral": 1,
+		"IndexExpression": 2, "HashLiteral": 1, "ImportStatement": 2, "SelectorExpression": 1,
+		"ThrowStatement": 1, "TryExpression": 1, "MatchExpression": 1, "ArrayPattern": 2,
+		"HashPattern": 2, "TypePattern": 1, "DefaultPattern": 1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("wrong node counts.\nwant=%v\ngot= %v", expected, counts)
+	}
+}
+
+func TestModifyEveryNode(t *testing.T) {
+	program := everyNode()
+
+	renamed := Modify(program, func(node Node) Node {
+		if ident, ok := node.(*Identifier); ok {
+			ident.Value = strings.ToUpper(ident.Value)
+		}
+		return node
+	})
+
+	var original, modified []string
+	Inspect(program, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			original = append(original, ident.Value)
+		}
+		return true
+	})
+	Inspect(renamed, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			modified = append(modified, ident.Value)
+		}
+		return true
+	})
+
+	expectedOriginal := []string{"f", "x", "x", "m", "y", "y", "f", "f", "mod", "a", "b", "c", "mod", "a", "e", "e",
+		"x", "r", "r", "v", "INTEGER", "v", "v", "_", "p", "q", "f", "w", "w"}
+	expectedModified := []string{"F", "X", "X", "M", "Y", "Y", "F", "F", "MOD", "A", "B", "C", "MOD", "A", "E", "E",
+		"X", "R", "R", "V", "INTEGER", "V", "V", "_", "P", "Q", "F", "W", "W"}
+	if !reflect.DeepEqual(original, expectedOriginal) {
+		t.Errorf("original was modified. got=%v", original)
+	}
+	if !reflect.DeepEqual(modified, expectedModified) {
+		t.Errorf("wrong identifiers after Modify. want=%v, got=%v", expectedModified, modified)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	program := everyNode()
+
+	data, err := EncodeJSON(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got nodeNames
+	Inspect(program, want.inspect)
+	Inspect(decoded, got.inspect)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong nodes after decoding.\nwant=%v\ngot= %v", want, got)
+	}
+
+	// everyNode leaves out tokens, which decoding fills in, so the decoded
+	// program is compared by encoding it once more
+	again, err := EncodeJSON(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redecoded, err := DecodeJSON(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(redecoded, decoded) {
+		t.Errorf("decoding is not stable.\nwant=%s\ngot= %s", decoded.String(), redecoded.String())
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	pos := func(line, column int) token.Position { return token.Position{Line: line, Column: column} }
+
+	node := &LetStatement{
+		Token: token.Token{Type: token.LET, Literal: "let", Pos: pos(1, 1)},
+		Name:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x", Pos: pos(1, 5)}, Value: "x"},
+		Value: &InfixExpression{
+			Token:    token.Token{Type: token.PLUS, Literal: "+", Pos: pos(1, 11)},
+			Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1", Pos: pos(1, 9)}, Value: 1},
+			Operator: "+",
+			Right:    &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a", Pos: pos(1, 13)}, Value: "a"},
+		},
+	}
+
+	expected := `{"kind":"LetStatement","pos":{"line":1,"column":1},` +
+		`"name":{"kind":"Identifier","pos":{"line":1,"column":5},"name":"x"},` +
+		`"value":{"kind":"InfixExpression","pos":{"line":1,"column":11},` +
+		`"left":{"kind":"IntegerLiteral","pos":{"line":1,"column":9},"literal":"1","value":1},` +
+		`"operator":"+",` +
+		`"right":{"kind":"StringLiteral","pos":{"line":1,"column":13},"value":"a"}}}`
+
+	data, err := EncodeJSON(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("wrong JSON.\nwant=%s\ngot= %s", expected, data)
+	}
+
+	decoded, err := DecodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, node) {
+		t.Errorf("decoded node differs.\nwant=%#v\ngot= %#v", node, decoded)
+	}
+
+	if _, err := EncodeJSON(&LetStatement{Name: &Identifier{Value: "x"}}); err == nil || err.Error() != "LetStatement.value: cannot encode a nil node" {
+		t.Errorf("expected error for missing value. got=%v", err)
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"kind":"Nope"}`, `unknown node kind "Nope"`},
+		{`{"name":"x"}`, `node without kind: {"name":"x"}`},
+		{`{"kind":"Identifier"}`, "Identifier.name: missing"},
+		{`{"kind":"LetStatement","name":{"kind":"Boolean","value":true},"value":{"kind":"Boolean","value":true}}`, "LetStatement.name: expected an Identifier, got Boolean"},
+		{`{"kind":"Program","statements":[{"kind":"Identifier","name":"x"}]}`, "Program.statements: expected a statement, got Identifier"},
+		{`{"kind":"ReturnStatement","value":{"kind":"Identifier","name":1}}`, "ReturnStatement.value: Identifier.name: json: cannot unmarshal number into Go value of type string"},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeJSON([]byte(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("DecodeJSON(%s) wrong error.\nwant=%q\ngot= %v", tt.input, tt.expected, err)
+		}
+	}
+}
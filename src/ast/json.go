@@ -0,0 +1,947 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"monkey/token"
+)
+
+// EncodeJSON encodes node and its children as JSON. Every node is an object
+// whose "kind" is the name of its type, such as "LetStatement", followed by
+// "pos", the position of the token it was parsed from, if known, and then
+// fields depending on the kind:
+//
+//	Program              statements
+//	LetStatement         name (Identifier) or pattern, value, exported (if
+//	                     true)
+//	ImportStatement      names (objects with name and alias, if any; only for
+//	                     selective imports), path (StringLiteral), alias (if any)
+//	ReturnStatement      value
+//	ThrowStatement       value
+//	ExpressionStatement  expression
+//	BlockStatement       statements, end
+//	Identifier           name
+//	IntegerLiteral       literal (as written), value (number)
+//	StringLiteral        value (string)
+//	Boolean              value (boolean)
+//	PrefixExpression     operator, right
+//	InfixExpression      left, operator, right
+//	IfExpression         condition, consequence, alternative (if any)
+//	TryExpression        block, parameter (Identifier) and catch (if any),
+//	                     finally (if any)
+//	MatchExpression      value, arms (objects with pattern, guard (if any) and
+//	                     body), end
+//	FunctionLiteral      parameters (Identifiers, and patterns for those
+//	                     destructured), body
+//	MacroLiteral         parameters (Identifiers), body
+//	CallExpression       function, arguments, end
+//	IndexExpression      left, index
+//	SelectorExpression   left, selector (Identifier)
+//	ArrayLiteral         elements, end
+//	HashLiteral          pairs (objects with key and value), end
+//	ArrayPattern         elements, rest (Identifier, if any), end
+//	HashPattern          pairs (objects with key, value and shorthand, if
+//	                     true), rest (Identifier, if any), end
+//	TypePattern          pattern, type (Identifier)
+//	DefaultPattern       pattern, default
+//
+// Positions are objects with a line and a column, counting from 1; "end"
+// is the position of the closing bracket. The tokens of infix, call, index
+// and selector expressions are their operator, '(', '[' and '.', and that of
+// a type pattern is the word "is". Annotations added by the resolver are not
+// encoded.
+func EncodeJSON(node Node) ([]byte, error) {
+	obj, err := encodeNode(node)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+// DecodeJSON decodes a node encoded by EncodeJSON.
+func DecodeJSON(data []byte) (Node, error) {
+	return decodeNode(data)
+}
+
+// jsonObject is a JSON object that keeps its fields in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value any
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		out.Write(key)
+		out.WriteByte(':')
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func encodeNode(node Node) (jsonObject, error) {
+	if node == nil || isNilNode(node) {
+		return nil, fmt.Errorf("cannot encode a nil node")
+	}
+
+	obj := jsonObject{{"kind", kindOf(node)}}
+	if pos := tokenOf(node).Pos; pos.IsValid() {
+		obj = append(obj, jsonField{"pos", jsonPosition{pos.Line, pos.Column}})
+	}
+
+	var err error
+	add := func(key string, child Node) {
+		if err != nil {
+			return
+		}
+		var encoded jsonObject
+		if encoded, err = encodeNode(child); err != nil {
+			err = fmt.Errorf("%s.%s: %w", kindOf(node), key, err)
+			return
+		}
+		obj = append(obj, jsonField{key, encoded})
+	}
+	addAll := func(key string, children []Node) {
+		list := []jsonObject{}
+		for _, child := range children {
+			if err != nil {
+				return
+			}
+			var encoded jsonObject
+			if encoded, err = encodeNode(child); err != nil {
+				err = fmt.Errorf("%s.%s: %w", kindOf(node), key, err)
+				return
+			}
+			list = append(list, encoded)
+		}
+		obj = append(obj, jsonField{key, list})
+	}
+	addEnd := func(end token.Token) {
+		if end.Pos.IsValid() {
+			obj = append(obj, jsonField{"end", jsonPosition{end.Pos.Line, end.Pos.Column}})
+		}
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		addAll("statements", statementNodes(node.Statements))
+	case *LetStatement:
+		if node.Pattern != nil {
+			add("pattern", node.Pattern)
+		} else {
+			add("name", node.Name)
+		}
+		add("value", node.Value)
+		if node.Exported {
+			obj = append(obj, jsonField{"exported", true})
+		}
+	case *ImportStatement:
+		if node.Names != nil {
+			names := []jsonObject{}
+			for _, name := range node.Names {
+				encoded, nameErr := encodeNode(name.Name)
+				if nameErr != nil {
+					return nil, fmt.Errorf("ImportStatement.names: %w", nameErr)
+				}
+				field := jsonObject{{"name", encoded}}
+				if name.Alias != nil {
+					if encoded, nameErr = encodeNode(name.Alias); nameErr != nil {
+						return nil, fmt.Errorf("ImportStatement.names: %w", nameErr)
+					}
+					field = append(field, jsonField{"alias", encoded})
+				}
+				names = append(names, field)
+			}
+			obj = append(obj, jsonField{"names", names})
+		}
+		add("path", node.Path)
+		if node.Alias != nil {
+			add("alias", node.Alias)
+		}
+	case *ReturnStatement:
+		add("value", node.ReturnValue)
+	case *ThrowStatement:
+		add("value", node.Value)
+	case *ExpressionStatement:
+		add("expression", node.Expression)
+	case *BlockStatement:
+		addAll("statements", statementNodes(node.Statements))
+		addEnd(node.Rbrace)
+	case *Identifier:
+		obj = append(obj, jsonField{"name", node.Value})
+	case *IntegerLiteral:
+		obj = append(obj, jsonField{"literal", node.Token.Literal}, jsonField{"value", node.Value})
+	case *StringLiteral:
+		obj = append(obj, jsonField{"value", node.Value})
+	case *Boolean:
+		obj = append(obj, jsonField{"value", node.Value})
+	case *PrefixExpression:
+		obj = append(obj, jsonField{"operator", node.Operator})
+		add("right", node.Right)
+	case *InfixExpression:
+		add("left", node.Left)
+		obj = append(obj, jsonField{"operator", node.Operator})
+		add("right", node.Right)
+	case *IfExpression:
+		add("condition", node.Condition)
+		add("consequence", node.Consequence)
+		if node.Alternative != nil {
+			add("alternative", node.Alternative)
+		}
+	case *TryExpression:
+		add("block", node.Block)
+		if node.Catch != nil {
+			add("parameter", node.Param)
+			add("catch", node.Catch)
+		}
+		if node.Finally != nil {
+			add("finally", node.Finally)
+		}
+	case *MatchExpression:
+		add("value", node.Value)
+		arms := []jsonObject{}
+		for _, arm := range node.Arms {
+			pattern, patternErr := encodeNode(arm.Pattern)
+			field := jsonObject{{"pattern", pattern}}
+			var guardErr error
+			if arm.Guard != nil {
+				var guard jsonObject
+				guard, guardErr = encodeNode(arm.Guard)
+				field = append(field, jsonField{"guard", guard})
+			}
+			body, bodyErr := encodeNode(arm.Body)
+			if patternErr != nil || guardErr != nil || bodyErr != nil {
+				return nil, fmt.Errorf("MatchExpression.arms: %w", errors.Join(patternErr, guardErr, bodyErr))
+			}
+			arms = append(arms, append(field, jsonField{"body", body}))
+		}
+		obj = append(obj, jsonField{"arms", arms})
+		addEnd(node.Rbrace)
+	case *FunctionLiteral:
+		params := identifierNodes(node.Params)
+		for i, pattern := range node.Patterns {
+			if pattern != nil {
+				params[i] = pattern
+			}
+		}
+		addAll("parameters", params)
+		add("body", node.Body)
+	case *MacroLiteral:
+		addAll("parameters", identifierNodes(node.Params))
+		add("body", node.Body)
+	case *CallExpression:
+		add("function", node.Function)
+		addAll("arguments", expressionNodes(node.Arguments))
+		addEnd(node.Rparen)
+	case *IndexExpression:
+		add("left", node.Left)
+		add("index", node.Index)
+	case *SelectorExpression:
+		add("left", node.Left)
+		add("selector", node.Selector)
+	case *ArrayLiteral:
+		addAll("elements", expressionNodes(node.Elements))
+		addEnd(node.Rbracket)
+	case *HashLiteral:
+		pairs := []jsonObject{}
+		for _, pair := range node.Pairs {
+			key, keyErr := encodeNode(pair.Key)
+			value, valueErr := encodeNode(pair.Value)
+			if keyErr != nil || valueErr != nil {
+				return nil, fmt.Errorf("HashLiteral.pairs: %w", errors.Join(keyErr, valueErr))
+			}
+			pairs = append(pairs, jsonObject{{"key", key}, {"value", value}})
+		}
+		obj = append(obj, jsonField{"pairs", pairs})
+		addEnd(node.Rbrace)
+	case *ArrayPattern:
+		elements := make([]Node, len(node.Elements))
+		for i, elem := range node.Elements {
+			elements[i] = elem
+		}
+		addAll("elements", elements)
+		if node.Rest != nil {
+			add("rest", node.Rest)
+		}
+		addEnd(node.Rbracket)
+	case *HashPattern:
+		pairs := []jsonObject{}
+		for _, pair := range node.Pairs {
+			key, keyErr := encodeNode(pair.Key)
+			value, valueErr := encodeNode(pair.Value)
+			if keyErr != nil || valueErr != nil {
+				return nil, fmt.Errorf("HashPattern.pairs: %w", errors.Join(keyErr, valueErr))
+			}
+			field := jsonObject{{"key", key}, {"value", value}}
+			if pair.Shorthand() {
+				field = append(field, jsonField{"shorthand", true})
+			}
+			pairs = append(pairs, field)
+		}
+		obj = append(obj, jsonField{"pairs", pairs})
+		if node.Rest != nil {
+			add("rest", node.Rest)
+		}
+		addEnd(node.Rbrace)
+	case *TypePattern:
+		add("pattern", node.Pattern)
+		add("type", node.Type)
+	case *DefaultPattern:
+		add("pattern", node.Pattern)
+		add("default", node.Default)
+	default:
+		return nil, fmt.Errorf("cannot encode node of type %T", node)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func decodeNode(data []byte) (Node, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil || kind == "" {
+		return nil, fmt.Errorf("node without kind: %s", data)
+	}
+
+	d := &decoder{kind: kind, fields: fields}
+	tok := d.position("pos")
+
+	var node Node
+	switch kind {
+	case "Program":
+		node = &Program{Statements: d.statements("statements")}
+	case "LetStatement":
+		let := &LetStatement{Token: token.Token{Type: token.LET, Literal: "let", Pos: tok}}
+		if _, ok := fields["pattern"]; ok {
+			let.Pattern = d.pattern("pattern")
+		} else {
+			let.Name = d.identifier("name")
+		}
+		let.Value = d.expression("value")
+		if _, ok := fields["exported"]; ok {
+			d.decode("exported", &let.Exported)
+		}
+		node = let
+	case "ImportStatement":
+		stmt := &ImportStatement{Token: token.Token{Type: token.IMPORT, Literal: "import", Pos: tok}}
+		if _, ok := fields["names"]; ok {
+			stmt.Names = d.importNames("names")
+		}
+		stmt.Path = child(d, "path", d.stringOf)
+		if _, ok := fields["alias"]; ok {
+			stmt.Alias = d.identifier("alias")
+		}
+		node = stmt
+	case "ReturnStatement":
+		node = &ReturnStatement{
+			Token:       token.Token{Type: token.RETURN, Literal: "return", Pos: tok},
+			ReturnValue: d.expression("value"),
+		}
+	case "ThrowStatement":
+		node = &ThrowStatement{
+			Token: token.Token{Type: token.THROW, Literal: "throw", Pos: tok},
+			Value: d.expression("value"),
+		}
+	case "ExpressionStatement":
+		exp := d.expression("expression")
+		stmt := &ExpressionStatement{Expression: exp}
+		if exp != nil {
+			stmt.Token = firstToken(exp)
+		}
+		stmt.Token.Pos = tok
+		node = stmt
+	case "BlockStatement":
+		node = d.block(tok)
+	case "Identifier":
+		name := d.string("name")
+		node = &Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Pos: tok}, Value: name}
+	case "IntegerLiteral":
+		var literal string
+		if _, ok := fields["literal"]; ok {
+			literal = d.string("literal")
+		}
+		var value int64
+		d.decode("value", &value)
+		if literal == "" {
+			literal = strconv.FormatInt(value, 10)
+		}
+		node = &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal, Pos: tok}, Value: value}
+	case "StringLiteral":
+		value := d.string("value")
+		node = &StringLiteral{Token: token.Token{Type: token.STRING, Literal: value, Pos: tok}, Value: value}
+	case "Boolean":
+		var value bool
+		d.decode("value", &value)
+		node = &Boolean{Token: token.Token{Type: token.FALSE, Literal: "false", Pos: tok}, Value: value}
+		if value {
+			node.(*Boolean).Token = token.Token{Type: token.TRUE, Literal: "true", Pos: tok}
+		}
+	case "PrefixExpression":
+		operator := d.string("operator")
+		node = &PrefixExpression{
+			Token:    token.Token{Type: token.TokenType(operator), Literal: operator, Pos: tok},
+			Operator: operator,
+			Right:    d.expression("right"),
+		}
+	case "InfixExpression":
+		operator := d.string("operator")
+		node = &InfixExpression{
+			Token:    token.Token{Type: token.TokenType(operator), Literal: operator, Pos: tok},
+			Left:     d.expression("left"),
+			Operator: operator,
+			Right:    d.expression("right"),
+		}
+	case "IfExpression":
+		exp := &IfExpression{
+			Token:       token.Token{Type: token.IF, Literal: "if", Pos: tok},
+			Condition:   d.expression("condition"),
+			Consequence: d.blockField("consequence"),
+		}
+		if _, ok := fields["alternative"]; ok {
+			exp.Alternative = d.blockField("alternative")
+		}
+		node = exp
+	case "TryExpression":
+		exp := &TryExpression{
+			Token: token.Token{Type: token.TRY, Literal: "try", Pos: tok},
+			Block: d.blockField("block"),
+		}
+		if _, ok := fields["catch"]; ok {
+			exp.Param = d.identifier("parameter")
+			exp.Catch = d.blockField("catch")
+		}
+		if _, ok := fields["finally"]; ok {
+			exp.Finally = d.blockField("finally")
+		}
+		node = exp
+	case "MatchExpression":
+		node = &MatchExpression{
+			Token:  token.Token{Type: token.MATCH, Literal: "match", Pos: tok},
+			Value:  d.expression("value"),
+			Arms:   d.arms("arms"),
+			Rbrace: d.end(token.RBRACE),
+		}
+	case "FunctionLiteral":
+		fn := &FunctionLiteral{Token: token.Token{Type: token.FUNCTION, Literal: "fn", Pos: tok}}
+		fn.Params, fn.Patterns = d.parameters("parameters")
+		fn.Body = d.blockField("body")
+		node = fn
+	case "MacroLiteral":
+		node = &MacroLiteral{
+			Token:  token.Token{Type: token.MACRO, Literal: "macro", Pos: tok},
+			Params: d.identifiers("parameters"),
+			Body:   d.blockField("body"),
+		}
+	case "CallExpression":
+		node = &CallExpression{
+			Token:     token.Token{Type: token.LPAREN, Literal: "(", Pos: tok},
+			Function:  d.expression("function"),
+			Arguments: d.expressions("arguments"),
+			Rparen:    d.end(token.RPAREN),
+		}
+	case "IndexExpression":
+		node = &IndexExpression{
+			Token: token.Token{Type: token.LBRACKET, Literal: "[", Pos: tok},
+			Left:  d.expression("left"),
+			Index: d.expression("index"),
+		}
+	case "SelectorExpression":
+		node = &SelectorExpression{
+			Token:    token.Token{Type: token.DOT, Literal: ".", Pos: tok},
+			Left:     d.expression("left"),
+			Selector: d.identifier("selector"),
+		}
+	case "ArrayLiteral":
+		node = &ArrayLiteral{
+			Token:    token.Token{Type: token.LBRACKET, Literal: "[", Pos: tok},
+			Elements: d.expressions("elements"),
+			Rbracket: d.end(token.RBRACKET),
+		}
+	case "HashLiteral":
+		node = &HashLiteral{
+			Token:  token.Token{Type: token.LBRACE, Literal: "{", Pos: tok},
+			Pairs:  d.pairs("pairs"),
+			Rbrace: d.end(token.RBRACE),
+		}
+	case "ArrayPattern":
+		array := &ArrayPattern{
+			Token:    token.Token{Type: token.LBRACKET, Literal: "[", Pos: tok},
+			Elements: d.patterns("elements"),
+			Rbracket: d.end(token.RBRACKET),
+		}
+		if _, ok := fields["rest"]; ok {
+			array.Rest = d.identifier("rest")
+		}
+		node = array
+	case "HashPattern":
+		hash := &HashPattern{
+			Token:  token.Token{Type: token.LBRACE, Literal: "{", Pos: tok},
+			Pairs:  d.patternPairs("pairs"),
+			Rbrace: d.end(token.RBRACE),
+		}
+		if _, ok := fields["rest"]; ok {
+			hash.Rest = d.identifier("rest")
+		}
+		node = hash
+	case "TypePattern":
+		node = &TypePattern{
+			Token:   token.Token{Type: token.IDENT, Literal: "is", Pos: tok},
+			Pattern: d.pattern("pattern"),
+			Type:    d.identifier("type"),
+		}
+	case "DefaultPattern":
+		node = &DefaultPattern{
+			Token:   token.Token{Type: token.ASSIGN, Literal: "=", Pos: tok},
+			Pattern: d.pattern("pattern"),
+			Default: d.expression("default"),
+		}
+	default:
+		return nil, fmt.Errorf("unknown node kind %q", kind)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+// decoder decodes the fields of a node of the given kind, remembering the
+// first error.
+type decoder struct {
+	kind   string
+	fields map[string]json.RawMessage
+	err    error
+}
+
+func (d *decoder) fail(key string, err error) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%s.%s: %w", d.kind, key, err)
+	}
+}
+
+func (d *decoder) decode(key string, v any) {
+	raw, ok := d.fields[key]
+	if !ok {
+		d.fail(key, fmt.Errorf("missing"))
+		return
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		d.fail(key, err)
+	}
+}
+
+func (d *decoder) string(key string) string {
+	var s string
+	d.decode(key, &s)
+	return s
+}
+
+func (d *decoder) position(key string) token.Position {
+	raw, ok := d.fields[key]
+	if !ok {
+		return token.Position{}
+	}
+	var pos jsonPosition
+	if err := json.Unmarshal(raw, &pos); err != nil {
+		d.fail(key, err)
+	}
+	return token.Position{Line: pos.Line, Column: pos.Column}
+}
+
+func (d *decoder) end(t token.TokenType) token.Token {
+	pos := d.position("end")
+	if !pos.IsValid() {
+		return token.Token{}
+	}
+	return token.Token{Type: t, Literal: string(t), Pos: pos}
+}
+
+func (d *decoder) node(key string, raw json.RawMessage) Node {
+	node, err := decodeNode(raw)
+	if err != nil {
+		d.fail(key, err)
+		return nil
+	}
+	return node
+}
+
+// child decodes the node in field key with convert, which fails if the node
+// is of the wrong kind.
+func child[T Node](d *decoder, key string, convert func(key string, node Node) T) T {
+	var zero T
+	raw, ok := d.fields[key]
+	if !ok {
+		d.fail(key, fmt.Errorf("missing"))
+		return zero
+	}
+	node := d.node(key, raw)
+	if node == nil {
+		return zero
+	}
+	return convert(key, node)
+}
+
+func (d *decoder) children(key string) []Node {
+	var raws []json.RawMessage
+	d.decode(key, &raws)
+	nodes := make([]Node, 0, len(raws))
+	for _, raw := range raws {
+		if node := d.node(key, raw); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (d *decoder) expressionOf(key string, node Node) Expression {
+	exp, ok := node.(Expression)
+	if !ok {
+		d.fail(key, fmt.Errorf("expected an expression, got %s", kindOf(node)))
+	}
+	return exp
+}
+
+func (d *decoder) identifierOf(key string, node Node) *Identifier {
+	ident, ok := node.(*Identifier)
+	if !ok {
+		d.fail(key, fmt.Errorf("expected an Identifier, got %s", kindOf(node)))
+	}
+	return ident
+}
+
+func (d *decoder) stringOf(key string, node Node) *StringLiteral {
+	str, ok := node.(*StringLiteral)
+	if !ok {
+		d.fail(key, fmt.Errorf("expected a StringLiteral, got %s", kindOf(node)))
+	}
+	return str
+}
+
+func (d *decoder) blockOf(key string, node Node) *BlockStatement {
+	block, ok := node.(*BlockStatement)
+	if !ok {
+		d.fail(key, fmt.Errorf("expected a BlockStatement, got %s", kindOf(node)))
+	}
+	return block
+}
+
+func (d *decoder) patternOf(key string, node Node) Pattern {
+	pattern, ok := node.(Pattern)
+	if !ok {
+		d.fail(key, fmt.Errorf("expected a pattern, got %s", kindOf(node)))
+	}
+	return pattern
+}
+
+func (d *decoder) expression(key string) Expression {
+	return child(d, key, d.expressionOf)
+}
+
+func (d *decoder) identifier(key string) *Identifier {
+	return child(d, key, d.identifierOf)
+}
+
+func (d *decoder) blockField(key string) *BlockStatement {
+	return child(d, key, d.blockOf)
+}
+
+func (d *decoder) pattern(key string) Pattern {
+	return child(d, key, d.patternOf)
+}
+
+func (d *decoder) patterns(key string) []Pattern {
+	nodes := d.children(key)
+	patterns := make([]Pattern, len(nodes))
+	for i, node := range nodes {
+		patterns[i] = d.patternOf(key, node)
+	}
+	return patterns
+}
+
+func (d *decoder) expressions(key string) []Expression {
+	nodes := d.children(key)
+	exps := make([]Expression, len(nodes))
+	for i, node := range nodes {
+		exps[i] = d.expressionOf(key, node)
+	}
+	return exps
+}
+
+func (d *decoder) identifiers(key string) []*Identifier {
+	nodes := d.children(key)
+	idents := make([]*Identifier, len(nodes))
+	for i, node := range nodes {
+		idents[i] = d.identifierOf(key, node)
+	}
+	return idents
+}
+
+// parameters decodes the parameters of a function, which are identifiers or
+// the patterns destructuring them.
+func (d *decoder) parameters(key string) ([]*Identifier, []Pattern) {
+	nodes := d.children(key)
+	params := make([]*Identifier, len(nodes))
+	var patterns []Pattern
+	for i, node := range nodes {
+		if ident, ok := node.(*Identifier); ok {
+			params[i] = ident
+			continue
+		}
+		switch node.(type) {
+		case *ArrayPattern, *HashPattern:
+		default:
+			d.fail(key, fmt.Errorf("expected an Identifier, ArrayPattern or HashPattern, got %s", kindOf(node)))
+			continue
+		}
+		if patterns == nil {
+			patterns = make([]Pattern, len(nodes))
+		}
+		patterns[i] = node.(Pattern)
+		params[i] = ParameterOf(patterns[i])
+	}
+	return params, patterns
+}
+
+func (d *decoder) statements(key string) []Statement {
+	nodes := d.children(key)
+	stmts := make([]Statement, len(nodes))
+	for i, node := range nodes {
+		stmt, ok := node.(Statement)
+		if !ok {
+			d.fail(key, fmt.Errorf("expected a statement, got %s", kindOf(node)))
+		}
+		stmts[i] = stmt
+	}
+	return stmts
+}
+
+func (d *decoder) block(pos token.Position) *BlockStatement {
+	return &BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{", Pos: pos},
+		Statements: d.statements("statements"),
+		Rbrace:     d.end(token.RBRACE),
+	}
+}
+
+func (d *decoder) importNames(key string) []*ImportName {
+	var raws []map[string]json.RawMessage
+	d.decode(key, &raws)
+	names := make([]*ImportName, 0, len(raws))
+	for _, raw := range raws {
+		name := &decoder{kind: d.kind, fields: raw}
+		importName := &ImportName{Name: name.identifier("name")}
+		if _, ok := raw["alias"]; ok {
+			importName.Alias = name.identifier("alias")
+		}
+		names = append(names, importName)
+		if name.err != nil {
+			d.fail(key, name.err)
+		}
+	}
+	return names
+}
+
+func (d *decoder) pairs(key string) []HashPair {
+	var raws []map[string]json.RawMessage
+	d.decode(key, &raws)
+	pairs := make([]HashPair, 0, len(raws))
+	for _, raw := range raws {
+		pair := &decoder{kind: d.kind, fields: raw}
+		pairs = append(pairs, HashPair{Key: pair.expression("key"), Value: pair.expression("value")})
+		if pair.err != nil {
+			d.fail(key, pair.err)
+		}
+	}
+	return pairs
+}
+
+func (d *decoder) arms(key string) []*MatchArm {
+	var raws []map[string]json.RawMessage
+	d.decode(key, &raws)
+	arms := make([]*MatchArm, 0, len(raws))
+	for _, raw := range raws {
+		field := &decoder{kind: d.kind, fields: raw}
+		arm := &MatchArm{Pattern: field.pattern("pattern")}
+		if _, ok := raw["guard"]; ok {
+			arm.Guard = field.expression("guard")
+		}
+		arm.Body = field.expression("body")
+		arms = append(arms, arm)
+		if field.err != nil {
+			d.fail(key, field.err)
+		}
+	}
+	return arms
+}
+
+func (d *decoder) patternPairs(key string) []HashPatternPair {
+	var raws []map[string]json.RawMessage
+	d.decode(key, &raws)
+	pairs := make([]HashPatternPair, 0, len(raws))
+	for _, raw := range raws {
+		field := &decoder{kind: d.kind, fields: raw}
+		pair := HashPatternPair{Key: field.expression("key"), Value: field.pattern("value")}
+		var shorthand bool
+		if _, ok := raw["shorthand"]; ok {
+			field.decode("shorthand", &shorthand)
+		}
+		if key, ok := pair.Key.(*StringLiteral); ok && shorthand {
+			key.Token.Type = token.IDENT
+		}
+		pairs = append(pairs, pair)
+		if field.err != nil {
+			d.fail(key, field.err)
+		}
+	}
+	return pairs
+}
+
+func kindOf(node Node) string {
+	return fmt.Sprintf("%T", node)[len("*ast."):]
+}
+
+// isNilNode reports whether node is a nil pointer, as left in the AST by
+// parse errors.
+func isNilNode(node Node) bool {
+	switch node := node.(type) {
+	case *LetStatement:
+		return node == nil
+	case *ImportStatement:
+		return node == nil
+	case *ReturnStatement:
+		return node == nil
+	case *ThrowStatement:
+		return node == nil
+	case *ExpressionStatement:
+		return node == nil
+	case *BlockStatement:
+		return node == nil
+	}
+	return false
+}
+
+// tokenOf returns the token node was parsed from.
+func tokenOf(node Node) token.Token {
+	switch node := node.(type) {
+	case *LetStatement:
+		return node.Token
+	case *ImportStatement:
+		return node.Token
+	case *ReturnStatement:
+		return node.Token
+	case *ThrowStatement:
+		return node.Token
+	case *ExpressionStatement:
+		return node.Token
+	case *BlockStatement:
+		return node.Token
+	case *Identifier:
+		return node.Token
+	case *IntegerLiteral:
+		return node.Token
+	case *StringLiteral:
+		return node.Token
+	case *Boolean:
+		return node.Token
+	case *PrefixExpression:
+		return node.Token
+	case *InfixExpression:
+		return node.Token
+	case *IfExpression:
+		return node.Token
+	case *TryExpression:
+		return node.Token
+	case *MatchExpression:
+		return node.Token
+	case *FunctionLiteral:
+		return node.Token
+	case *MacroLiteral:
+		return node.Token
+	case *CallExpression:
+		return node.Token
+	case *IndexExpression:
+		return node.Token
+	case *SelectorExpression:
+		return node.Token
+	case *ArrayLiteral:
+		return node.Token
+	case *HashLiteral:
+		return node.Token
+	case *ArrayPattern:
+		return node.Token
+	case *HashPattern:
+		return node.Token
+	case *TypePattern:
+		return node.Token
+	case *DefaultPattern:
+		return node.Token
+	}
+	return token.Token{}
+}
+
+// firstToken returns the first token of exp in the source, which is the
+// token of the expression statement it forms.
+func firstToken(exp Expression) token.Token {
+	switch exp := exp.(type) {
+	case *InfixExpression:
+		return firstToken(exp.Left)
+	case *CallExpression:
+		return firstToken(exp.Function)
+	case *IndexExpression:
+		return firstToken(exp.Left)
+	case *SelectorExpression:
+		return firstToken(exp.Left)
+	}
+	return tokenOf(exp)
+}
+
+func statementNodes(stmts []Statement) []Node {
+	nodes := make([]Node, len(stmts))
+	for i, stmt := range stmts {
+		nodes[i] = stmt
+	}
+	return nodes
+}
+
+func expressionNodes(exps []Expression) []Node {
+	nodes := make([]Node, len(exps))
+	for i, exp := range exps {
+		nodes[i] = exp
+	}
+	return nodes
+}
+
+func identifierNodes(idents []*Identifier) []Node {
+	nodes := make([]Node, len(idents))
+	for i, ident := range idents {
+		nodes[i] = ident
+	}
+	return nodes
+}
@@ -0,0 +1,257 @@
+package ast
+
+type ModifierFunc func(Node) Node
+
+// Modify calls modifier on every node of the tree rooted at node, children
+// before their parents, and returns the tree built from what it returns.
+// The tree is copied on the way, so node itself is left unchanged and the
+// result can be modified and annotated independently of it.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch node := node.(type) {
+
+	case *Program:
+		copied := *node
+		copied.Statements = modifyStatements(node.Statements, modifier)
+		return modifier(&copied)
+
+	case *LetStatement:
+		copied := *node
+		if node.Pattern != nil {
+			copied.Pattern = modifyPattern(node.Pattern, modifier)
+		} else {
+			copied.Name, _ = Modify(node.Name, modifier).(*Identifier)
+		}
+		copied.Value = modifyExpression(node.Value, modifier)
+		return modifier(&copied)
+
+	case *ImportStatement:
+		copied := *node
+		if node.Names != nil {
+			copied.Names = make([]*ImportName, len(node.Names))
+			for i, name := range node.Names {
+				copied.Names[i] = &ImportName{}
+				copied.Names[i].Name, _ = Modify(name.Name, modifier).(*Identifier)
+				if name.Alias != nil {
+					copied.Names[i].Alias, _ = Modify(name.Alias, modifier).(*Identifier)
+				}
+			}
+		}
+		copied.Path, _ = Modify(node.Path, modifier).(*StringLiteral)
+		if node.Alias != nil {
+			copied.Alias, _ = Modify(node.Alias, modifier).(*Identifier)
+		}
+		return modifier(&copied)
+
+	case *ReturnStatement:
+		copied := *node
+		copied.ReturnValue = modifyExpression(node.ReturnValue, modifier)
+		return modifier(&copied)
+
+	case *ThrowStatement:
+		copied := *node
+		copied.Value = modifyExpression(node.Value, modifier)
+		return modifier(&copied)
+
+	case *ExpressionStatement:
+		copied := *node
+		copied.Expression = modifyExpression(node.Expression, modifier)
+		return modifier(&copied)
+
+	case *BlockStatement:
+		copied := *node
+		copied.Statements = modifyStatements(node.Statements, modifier)
+		return modifier(&copied)
+
+	case *Identifier:
+		copied := *node
+		return modifier(&copied)
+
+	case *IntegerLiteral:
+		copied := *node
+		return modifier(&copied)
+
+	case *StringLiteral:
+		copied := *node
+		return modifier(&copied)
+
+	case *Boolean:
+		copied := *node
+		return modifier(&copied)
+
+	case *PrefixExpression:
+		copied := *node
+		copied.Right = modifyExpression(node.Right, modifier)
+		return modifier(&copied)
+
+	case *InfixExpression:
+		copied := *node
+		copied.Left = modifyExpression(node.Left, modifier)
+		copied.Right = modifyExpression(node.Right, modifier)
+		return modifier(&copied)
+
+	case *IfExpression:
+		copied := *node
+		copied.Condition = modifyExpression(node.Condition, modifier)
+		copied.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
+		if node.Alternative != nil {
+			copied.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStatement)
+		}
+		return modifier(&copied)
+
+	case *TryExpression:
+		copied := *node
+		copied.Block, _ = Modify(node.Block, modifier).(*BlockStatement)
+		if node.Catch != nil {
+			copied.Param, _ = Modify(node.Param, modifier).(*Identifier)
+			copied.Catch, _ = Modify(node.Catch, modifier).(*BlockStatement)
+		}
+		if node.Finally != nil {
+			copied.Finally, _ = Modify(node.Finally, modifier).(*BlockStatement)
+		}
+		return modifier(&copied)
+
+	case *MatchExpression:
+		copied := *node
+		copied.Value = modifyExpression(node.Value, modifier)
+		copied.Arms = make([]*MatchArm, len(node.Arms))
+		for i, arm := range node.Arms {
+			copied.Arms[i] = &MatchArm{
+				Pattern: modifyPattern(arm.Pattern, modifier),
+				Guard:   modifyExpression(arm.Guard, modifier),
+				Body:    modifyExpression(arm.Body, modifier),
+			}
+		}
+		return modifier(&copied)
+
+	case *FunctionLiteral:
+		copied := *node
+		copied.Params = modifyIdentifiers(node.Params, modifier)
+		if node.Patterns != nil {
+			copied.Patterns = make([]Pattern, len(node.Patterns))
+			for i, pattern := range node.Patterns {
+				copied.Patterns[i] = modifyPattern(pattern, modifier)
+			}
+		}
+		copied.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+		return modifier(&copied)
+
+	case *MacroLiteral:
+		copied := *node
+		copied.Params = modifyIdentifiers(node.Params, modifier)
+		copied.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+		return modifier(&copied)
+
+	case *CallExpression:
+		copied := *node
+		copied.Function = modifyExpression(node.Function, modifier)
+		copied.Arguments = modifyExpressions(node.Arguments, modifier)
+		return modifier(&copied)
+
+	case *ArrayLiteral:
+		copied := *node
+		copied.Elements = modifyExpressions(node.Elements, modifier)
+		return modifier(&copied)
+
+	case *IndexExpression:
+		copied := *node
+		copied.Left = modifyExpression(node.Left, modifier)
+		copied.Index = modifyExpression(node.Index, modifier)
+		return modifier(&copied)
+
+	case *SelectorExpression:
+		copied := *node
+		copied.Left = modifyExpression(node.Left, modifier)
+		copied.Selector, _ = Modify(node.Selector, modifier).(*Identifier)
+		return modifier(&copied)
+
+	case *HashLiteral:
+		copied := *node
+		copied.Pairs = make([]HashPair, len(node.Pairs))
+		for i, pair := range node.Pairs {
+			copied.Pairs[i] = HashPair{
+				Key:   modifyExpression(pair.Key, modifier),
+				Value: modifyExpression(pair.Value, modifier),
+			}
+		}
+		return modifier(&copied)
+
+	case *ArrayPattern:
+		copied := *node
+		copied.Elements = make([]Pattern, len(node.Elements))
+		for i, elem := range node.Elements {
+			copied.Elements[i] = modifyPattern(elem, modifier)
+		}
+		if node.Rest != nil {
+			copied.Rest, _ = Modify(node.Rest, modifier).(*Identifier)
+		}
+		return modifier(&copied)
+
+	case *HashPattern:
+		copied := *node
+		copied.Pairs = make([]HashPatternPair, len(node.Pairs))
+		for i, pair := range node.Pairs {
+			copied.Pairs[i] = HashPatternPair{
+				Key:   modifyExpression(pair.Key, modifier),
+				Value: modifyPattern(pair.Value, modifier),
+			}
+		}
+		if node.Rest != nil {
+			copied.Rest, _ = Modify(node.Rest, modifier).(*Identifier)
+		}
+		return modifier(&copied)
+
+	case *TypePattern:
+		copied := *node
+		copied.Pattern = modifyPattern(node.Pattern, modifier)
+		copied.Type, _ = Modify(node.Type, modifier).(*Identifier)
+		return modifier(&copied)
+
+	case *DefaultPattern:
+		copied := *node
+		copied.Pattern = modifyPattern(node.Pattern, modifier)
+		copied.Default = modifyExpression(node.Default, modifier)
+		return modifier(&copied)
+	}
+
+	return modifier(node)
+}
+
+func modifyPattern(pattern Pattern, modifier ModifierFunc) Pattern {
+	if pattern == nil {
+		return nil
+	}
+	modified, _ := Modify(pattern, modifier).(Pattern)
+	return modified
+}
+
+func modifyExpression(exp Expression, modifier ModifierFunc) Expression {
+	if exp == nil {
+		return nil
+	}
+	modified, _ := Modify(exp, modifier).(Expression)
+	return modified
+}
+
+func modifyExpressions(exps []Expression, modifier ModifierFunc) []Expression {
+	modified := make([]Expression, len(exps))
+	for i, exp := range exps {
+		modified[i] = modifyExpression(exp, modifier)
+	}
+	return modified
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) []Statement {
+	modified := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		modified[i], _ = Modify(stmt, modifier).(Statement)
+	}
+	return modified
+}
+
+func modifyIdentifiers(idents []*Identifier, modifier ModifierFunc) []*Identifier {
+	modified := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		modified[i], _ = Modify(ident, modifier).(*Identifier)
+	}
+	return modified
+}
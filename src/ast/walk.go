@@ -0,0 +1,194 @@
+package ast
+
+// A Visitor's Visit method is called by Walk for each node. If the visitor w
+// it returns is not nil, Walk visits the children of the node with w and
+// then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node depth-first, visiting the children
+// of a node in source order.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		walkStatements(v, node.Statements)
+
+	case *LetStatement:
+		if node.Pattern != nil {
+			Walk(v, node.Pattern)
+		} else {
+			Walk(v, node.Name)
+		}
+		if node.Value != nil {
+			Walk(v, node.Value)
+		}
+
+	case *ImportStatement:
+		for _, name := range node.Names {
+			Walk(v, name.Name)
+			if name.Alias != nil {
+				Walk(v, name.Alias)
+			}
+		}
+		Walk(v, node.Path)
+		if node.Alias != nil {
+			Walk(v, node.Alias)
+		}
+
+	case *ReturnStatement:
+		if node.ReturnValue != nil {
+			Walk(v, node.ReturnValue)
+		}
+
+	case *ThrowStatement:
+		if node.Value != nil {
+			Walk(v, node.Value)
+		}
+
+	case *ExpressionStatement:
+		if node.Expression != nil {
+			Walk(v, node.Expression)
+		}
+
+	case *BlockStatement:
+		walkStatements(v, node.Statements)
+
+	case *Identifier, *IntegerLiteral, *StringLiteral, *Boolean:
+		// no children
+
+	case *PrefixExpression:
+		Walk(v, node.Right)
+
+	case *InfixExpression:
+		Walk(v, node.Left)
+		Walk(v, node.Right)
+
+	case *IfExpression:
+		Walk(v, node.Condition)
+		Walk(v, node.Consequence)
+		if node.Alternative != nil {
+			Walk(v, node.Alternative)
+		}
+
+	case *TryExpression:
+		Walk(v, node.Block)
+		if node.Catch != nil {
+			Walk(v, node.Param)
+			Walk(v, node.Catch)
+		}
+		if node.Finally != nil {
+			Walk(v, node.Finally)
+		}
+
+	case *MatchExpression:
+		Walk(v, node.Value)
+		for _, arm := range node.Arms {
+			Walk(v, arm.Pattern)
+			if arm.Guard != nil {
+				Walk(v, arm.Guard)
+			}
+			Walk(v, arm.Body)
+		}
+
+	case *FunctionLiteral:
+		for i, param := range node.Params {
+			if node.Patterns != nil && node.Patterns[i] != nil {
+				Walk(v, node.Patterns[i])
+			} else {
+				Walk(v, param)
+			}
+		}
+		Walk(v, node.Body)
+
+	case *MacroLiteral:
+		walkIdentifiers(v, node.Params)
+		Walk(v, node.Body)
+
+	case *CallExpression:
+		Walk(v, node.Function)
+		walkExpressions(v, node.Arguments)
+
+	case *ArrayLiteral:
+		walkExpressions(v, node.Elements)
+
+	case *IndexExpression:
+		Walk(v, node.Left)
+		Walk(v, node.Index)
+
+	case *SelectorExpression:
+		Walk(v, node.Left)
+		Walk(v, node.Selector)
+
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			Walk(v, pair.Key)
+			Walk(v, pair.Value)
+		}
+
+	case *ArrayPattern:
+		for _, elem := range node.Elements {
+			Walk(v, elem)
+		}
+		if node.Rest != nil {
+			Walk(v, node.Rest)
+		}
+
+	case *HashPattern:
+		for _, pair := range node.Pairs {
+			Walk(v, pair.Key)
+			Walk(v, pair.Value)
+		}
+		if node.Rest != nil {
+			Walk(v, node.Rest)
+		}
+
+	case *TypePattern:
+		Walk(v, node.Pattern)
+		Walk(v, node.Type)
+
+	case *DefaultPattern:
+		Walk(v, node.Pattern)
+		Walk(v, node.Default)
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, exps []Expression) {
+	for _, exp := range exps {
+		Walk(v, exp)
+	}
+}
+
+func walkIdentifiers(v Visitor, idents []*Identifier) {
+	for _, ident := range idents {
+		Walk(v, ident)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node like Walk, calling f for each
+// node. The children of a node are only visited if f returns true for it.
+// After the children, f is called with nil.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
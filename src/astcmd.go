@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+)
+
+// runAST implements `monkey ast`, which prints the syntax tree of a file,
+// as JSON with -json and as the parser reads it back otherwise.
+func runAST(args []string) int {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey ast [-json] file\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return 1
+	}
+
+	if !*asJSON {
+		for _, stmt := range program.Statements {
+			fmt.Println(stmt.String())
+		}
+		return 0
+	}
+
+	data, err := ast.EncodeJSON(program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	var out bytes.Buffer
+	json.Indent(&out, data, "", "  ")
+	out.WriteString("\n")
+	os.Stdout.Write(out.Bytes())
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"monkey/lint"
+)
+
+// severitiesFlag collects the rule=severity pairs passed with repeated
+// -severity flags.
+type severitiesFlag []string
+
+func (s *severitiesFlag) String() string     { return strings.Join(*s, ",") }
+func (s *severitiesFlag) Set(v string) error { *s = append(*s, v); return nil }
+
+// runCheck implements `monkey check`, which reports likely mistakes in the
+// given files, or standard input if there are none, and fails if any is an
+// error.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the diagnostics as a JSON array")
+	config := flags.String("config", "", "read the severities of rules from the JSON object in `file`")
+	var severities severitiesFlag
+	flags.Var(&severities, "severity", "set the severity of a rule, as `rule=level`; repeatable")
+	listRules := flags.Bool("rules", false, "list the rules with their default severities and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey check [-json] [-config file] [-severity rule=level] [files]\n")
+		fmt.Fprintf(flags.Output(), "       monkey check -rules\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	linter := lint.New()
+	if *listRules {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, rule := range linter.Rules {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", rule.Name, rule.Severity, rule.Doc)
+		}
+		w.Flush()
+		return 0
+	}
+	if *config != "" {
+		data, err := os.ReadFile(*config)
+		if err == nil {
+			err = linter.Configure(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey check: %s: %s\n", *config, err)
+			return 2
+		}
+	}
+	for _, s := range severities {
+		name, level, _ := strings.Cut(s, "=")
+		severity, err := lint.ParseSeverity(level)
+		if err == nil {
+			err = linter.SetSeverity(name, severity)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey check: invalid -severity %s: %s\n", s, err)
+			return 2
+		}
+	}
+
+	var diagnostics []lint.Diagnostic
+	status := 0
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		diagnostics = linter.Check("<stdin>", string(src))
+	}
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		diagnostics = append(diagnostics, linter.Check(path, string(src))...)
+	}
+
+	if *asJSON {
+		if diagnostics == nil {
+			diagnostics = []lint.Diagnostic{}
+		}
+		data, err := json.MarshalIndent(diagnostics, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%s\n", data)
+	} else {
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+	}
+
+	for _, d := range diagnostics {
+		if d.Severity == lint.Error {
+			status = 1
+		}
+	}
+	return status
+}
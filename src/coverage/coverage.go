@@ -0,0 +1,318 @@
+// Package coverage records which statements of Monkey programs are evaluated
+// and which branches of their if expressions are taken, and reports it as a
+// summary, as an annotated listing of the source and in the LCOV format of
+// coverage tools.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"monkey/ast"
+	"monkey/object"
+)
+
+// File is a program whose coverage is recorded.
+type File struct {
+	Name   string
+	Source string
+
+	statements []*statement // in source order
+	branches   []*branch    // in source order
+}
+
+type statement struct {
+	node ast.Statement
+	hits int
+}
+
+// branch counts how often each branch of an if expression was taken.
+type branch struct {
+	node                     *ast.IfExpression
+	consequence, alternative int
+}
+
+// Coverage records the coverage of the programs added to it, while they are
+// evaluated by an interpreter created with evaluator.WithTracer. Programs
+// must be evaluated as they were added, and not optimized first, since
+// statements are told apart by their nodes.
+type Coverage struct {
+	files      []*File
+	statements map[ast.Statement]*statement
+	branches   map[*ast.IfExpression]*branch
+}
+
+// New returns a Coverage without programs.
+func New() *Coverage {
+	return &Coverage{
+		statements: map[ast.Statement]*statement{},
+		branches:   map[*ast.IfExpression]*branch{},
+	}
+}
+
+// Add adds program, parsed from source in the file name, whose macros must be
+// expanded already. Statements that are quoted are not counted, since they
+// are not evaluated.
+func (c *Coverage) Add(name, source string, program *ast.Program) *File {
+	f := &File{Name: name, Source: source}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program:
+			c.addStatements(f, node.Statements)
+		case *ast.BlockStatement:
+			c.addStatements(f, node.Statements)
+		case *ast.IfExpression:
+			b := &branch{node: node}
+			f.branches = append(f.branches, b)
+			c.branches[node] = b
+		case *ast.CallExpression:
+			if ident, ok := node.Function.(*ast.Identifier); ok && ident.Value == "quote" {
+				return false
+			}
+		case *ast.MacroLiteral:
+			return false
+		}
+		return true
+	})
+
+	sort.SliceStable(f.statements, func(i, j int) bool {
+		return before(f.statements[i].node, f.statements[j].node)
+	})
+	sort.SliceStable(f.branches, func(i, j int) bool {
+		return before(f.branches[i].node, f.branches[j].node)
+	})
+	c.files = append(c.files, f)
+	return f
+}
+
+func (c *Coverage) addStatements(f *File, stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		s := &statement{node: stmt}
+		f.statements = append(f.statements, s)
+		c.statements[stmt] = s
+	}
+}
+
+func before(a, b ast.Node) bool {
+	pa, pb := a.Pos(), b.Pos()
+	if pa.Line != pb.Line {
+		return pa.Line < pb.Line
+	}
+	return pa.Column < pb.Column
+}
+
+// Files returns the files added, in the order they were added.
+func (c *Coverage) Files() []*File {
+	return c.files
+}
+
+// Statement implements evaluator.Tracer and counts stmt as evaluated.
+func (c *Coverage) Statement(stmt ast.Statement, env *object.Environment) error {
+	if s, ok := c.statements[stmt]; ok {
+		s.hits++
+	}
+	return nil
+}
+
+// Call implements evaluator.Tracer.
+func (c *Coverage) Call(fn *object.Function, call *ast.CallExpression, env *object.Environment) {}
+
+// Return implements evaluator.Tracer.
+func (c *Coverage) Return(fn *object.Function, result object.Object) {}
+
+// Branch implements evaluator.BranchTracer and counts the branch of node
+// taken.
+func (c *Coverage) Branch(node *ast.IfExpression, consequence bool) {
+	b, ok := c.branches[node]
+	if !ok {
+		return
+	}
+	if consequence {
+		b.consequence++
+	} else {
+		b.alternative++
+	}
+}
+
+// Summary counts the statements and branches of programs, and those that
+// were evaluated and taken. Every if expression has two branches, the
+// alternative being taken when its condition is not truthy, even if it has
+// no else block.
+type Summary struct {
+	Statements, StatementsRun int
+	Branches, BranchesTaken   int
+}
+
+// StatementPercent returns the percentage of statements evaluated, which is
+// 100 if there are none.
+func (s Summary) StatementPercent() float64 { return percent(s.StatementsRun, s.Statements) }
+
+// BranchPercent returns the percentage of branches taken, which is 100 if
+// there are none.
+func (s Summary) BranchPercent() float64 { return percent(s.BranchesTaken, s.Branches) }
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("statements %.1f%% (%d/%d), branches %.1f%% (%d/%d)",
+		s.StatementPercent(), s.StatementsRun, s.Statements,
+		s.BranchPercent(), s.BranchesTaken, s.Branches)
+}
+
+// Summary summarizes the coverage of f.
+func (f *File) Summary() Summary {
+	var s Summary
+	for _, stmt := range f.statements {
+		s.Statements++
+		if stmt.hits > 0 {
+			s.StatementsRun++
+		}
+	}
+	for _, b := range f.branches {
+		s.Branches += 2
+		if b.consequence > 0 {
+			s.BranchesTaken++
+		}
+		if b.alternative > 0 {
+			s.BranchesTaken++
+		}
+	}
+	return s
+}
+
+// Summary summarizes the coverage of all files.
+func (c *Coverage) Summary() Summary {
+	var total Summary
+	for _, f := range c.files {
+		s := f.Summary()
+		total.Statements += s.Statements
+		total.StatementsRun += s.StatementsRun
+		total.Branches += s.Branches
+		total.BranchesTaken += s.BranchesTaken
+	}
+	return total
+}
+
+// WriteSummary writes the summary of each file and, if there are several,
+// of all of them.
+func (c *Coverage) WriteSummary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.files {
+		fmt.Fprintf(bw, "%s: %s\n", f.Name, f.Summary())
+	}
+	if len(c.files) > 1 {
+		fmt.Fprintf(bw, "total: %s\n", c.Summary())
+	}
+	return bw.Flush()
+}
+
+// line counts the statements starting on a line.
+type line struct {
+	statements int
+	hits       int // of the statement evaluated most
+	missed     bool
+}
+
+// lines returns the statements starting on each line, by line number.
+func (f *File) lines() []line {
+	lines := make([]line, strings.Count(f.Source, "\n")+2)
+	for _, s := range f.statements {
+		n := s.node.Pos().Line
+		if n < 1 || n >= len(lines) {
+			continue
+		}
+		l := &lines[n]
+		l.statements++
+		l.hits = max(l.hits, s.hits)
+		l.missed = l.missed || s.hits == 0
+	}
+	return lines
+}
+
+// WriteListing writes the source of each file with the number of times
+// statements on each line were evaluated, followed by "*" if some never
+// were, "#####" for lines with statements that never were and "-" for lines
+// without statements. Lines with if expressions are followed by how often
+// their branches were taken.
+func (c *Coverage) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.files {
+		fmt.Fprintf(bw, "%s: %s\n", f.Name, f.Summary())
+		lines := f.lines()
+		branches := f.branches
+		for i, text := range strings.Split(strings.TrimSuffix(f.Source, "\n"), "\n") {
+			n := i + 1
+			count := "-"
+			switch l := lines[n]; {
+			case l.statements == 0:
+			case l.hits == 0:
+				count = "#####"
+			case l.missed:
+				count = fmt.Sprintf("%d*", l.hits)
+			default:
+				count = fmt.Sprint(l.hits)
+			}
+			fmt.Fprintf(bw, "%9s:%5d: %s\n", count, n, text)
+
+			for len(branches) > 0 && branches[0].node.Pos().Line <= n {
+				b := branches[0]
+				branches = branches[1:]
+				fmt.Fprintf(bw, "%9s %5s  if at %s: %s, %s\n", "", "", b.node.Pos(),
+					taken("then", b.consequence), taken("else", b.alternative))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func taken(name string, n int) string {
+	if n == 0 {
+		return name + " never taken"
+	}
+	return fmt.Sprintf("%s taken %d", name, n)
+}
+
+// WriteLCOV writes the coverage of each file as a record in the LCOV format.
+// Branches of if expressions that were never evaluated have no count, as
+// the format asks.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.files {
+		fmt.Fprintln(bw, "TN:")
+		fmt.Fprintf(bw, "SF:%s\n", f.Name)
+
+		s := f.Summary()
+		for i, b := range f.branches {
+			line := b.node.Pos().Line
+			if b.consequence+b.alternative == 0 {
+				fmt.Fprintf(bw, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", line, i, line, i)
+				continue
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%d,0,%d\nBRDA:%d,%d,1,%d\n", line, i, b.consequence, line, i, b.alternative)
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", s.Branches, s.BranchesTaken)
+
+		found, hit := 0, 0
+		for n, l := range f.lines() {
+			if l.statements == 0 {
+				continue
+			}
+			found++
+			if l.hits > 0 {
+				hit++
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", n, l.hits)
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", found, hit)
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,157 @@
+package coverage
+
+import (
+	"bytes"
+	"testing"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+const source = `let sign = fn(n) {
+  if (n < 0) {
+    -1
+  } else {
+    if (n == 0) { return 0; }
+    1
+  }
+};
+let unused = fn() { puts("never") };
+let quoted = quote(if (true) { 1 });
+sign(5) + sign(7);
+if (false) { 2 }
+`
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+// cover evaluates the sources, named by their index, and returns their
+// coverage.
+func cover(t *testing.T, sources ...string) *Coverage {
+	t.Helper()
+	cov := New()
+	in := evaluator.New(evaluator.WithTracer(cov))
+	for i, source := range sources {
+		program := parse(t, source)
+		cov.Add(string(rune('a'+i))+".mk", source, program)
+		if result := in.Eval(program, object.NewEnvironment()); result != nil && result.Type() == object.ERROR_OBJ {
+			t.Fatalf("evaluation failed: %s", result.Inspect())
+		}
+	}
+	return cov
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Summary
+	}{
+		{source, Summary{Statements: 12, StatementsRun: 8, Branches: 6, BranchesTaken: 3}},
+		{"let x = 1;", Summary{Statements: 1, StatementsRun: 1}},
+		// ifs in tail position and in callbacks
+		{"let f = fn(x) { if (x) { 1 } else { 2 } }; f(true); let apply = fn(g) { g(1) }; apply(fn(x) { if (x > 1) { x } })",
+			Summary{Statements: 10, StatementsRun: 8, Branches: 4, BranchesTaken: 2}},
+		{"", Summary{}},
+	}
+
+	for _, tt := range tests {
+		if got := cover(t, tt.input).Summary(); got != tt.want {
+			t.Errorf("wrong summary of %q. got=%+v, want=%+v", tt.input, got, tt.want)
+		}
+	}
+
+	if got := (Summary{}).String(); got != "statements 100.0% (0/0), branches 100.0% (0/0)" {
+		t.Errorf("wrong empty summary. got=%q", got)
+	}
+	if got := cover(t, source).Summary().String(); got != "statements 66.7% (8/12), branches 50.0% (3/6)" {
+		t.Errorf("wrong summary. got=%q", got)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var out bytes.Buffer
+	cover(t, "1", "if (true) { 1 }").WriteSummary(&out)
+	want := `a.mk: statements 100.0% (1/1), branches 100.0% (0/0)
+b.mk: statements 100.0% (2/2), branches 50.0% (1/2)
+total: statements 100.0% (3/3), branches 50.0% (1/2)
+`
+	if out.String() != want {
+		t.Errorf("wrong summary.\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteListing(t *testing.T) {
+	var out bytes.Buffer
+	cover(t, source).WriteListing(&out)
+	want := `a.mk: statements 66.7% (8/12), branches 50.0% (3/6)
+        1:    1: let sign = fn(n) {
+        2:    2:   if (n < 0) {
+                 if at 2:3: then never taken, else taken 2
+    #####:    3:     -1
+        -:    4:   } else {
+       2*:    5:     if (n == 0) { return 0; }
+                 if at 5:5: then never taken, else taken 2
+        2:    6:     1
+        -:    7:   }
+        -:    8: };
+       1*:    9: let unused = fn() { puts("never") };
+        1:   10: let quoted = quote(if (true) { 1 });
+        1:   11: sign(5) + sign(7);
+       1*:   12: if (false) { 2 }
+                 if at 12:1: then never taken, else taken 1
+`
+	if out.String() != want {
+		t.Errorf("wrong listing.\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteLCOV(t *testing.T) {
+	var out bytes.Buffer
+	cover(t, source, "let f = fn(x) { if (x) { 1 } };").WriteLCOV(&out)
+	want := `TN:
+SF:a.mk
+BRDA:2,0,0,0
+BRDA:2,0,1,2
+BRDA:5,1,0,0
+BRDA:5,1,1,2
+BRDA:12,2,0,0
+BRDA:12,2,1,1
+BRF:6
+BRH:3
+DA:1,1
+DA:2,2
+DA:3,0
+DA:5,2
+DA:6,2
+DA:9,1
+DA:10,1
+DA:11,1
+DA:12,1
+LF:9
+LH:8
+end_of_record
+TN:
+SF:b.mk
+BRDA:1,0,0,-
+BRDA:1,0,1,-
+BRF:2
+BRH:0
+DA:1,1
+LF:1
+LH:1
+end_of_record
+`
+	if out.String() != want {
+		t.Errorf("wrong LCOV.\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"monkey/debugger"
+	"monkey/evaluator"
+	"monkey/repl"
+)
+
+// runDebug implements `monkey debug`, which debugs a file interactively, or
+// with -dap runs a debug adapter speaking the Debug Adapter Protocol over
+// standard input and output, for editors to launch files with.
+func runDebug(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	dap := flags.Bool("dap", false, "serve the Debug Adapter Protocol on standard input and output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey debug file\n")
+		fmt.Fprintf(flags.Output(), "       monkey debug -dap\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	fileAccess := evaluator.WithFileAccess(evaluator.FileAccess{Roots: []string{"."}})
+	if *dap {
+		if flags.NArg() != 0 {
+			flags.Usage()
+			return 2
+		}
+		if err := debugger.ServeDAP(os.Stdin, os.Stdout, fileAccess, evaluator.WithModulePaths(".")); err != nil {
+			fmt.Fprintf(os.Stderr, "monkey debug: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	d := debugger.New(fileAccess, evaluator.WithModulePaths(filepath.Dir(path)))
+	program, err := d.Load(string(source))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	repl.Debug(os.Stdin, os.Stdout, d, program, string(source))
+	return 0
+}
@@ -0,0 +1,434 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// The parts of the Debug Adapter Protocol that the server implements. See
+// https://microsoft.github.io/debug-adapter-protocol/ for the rest.
+
+// dapMessage is a request, response or event of the protocol.
+type dapMessage struct {
+	Seq     int    `json:"seq"`
+	Type    string `json:"type"`
+	Command string `json:"command,omitempty"`
+	Event   string `json:"event,omitempty"`
+
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	RequestSeq int    `json:"request_seq,omitempty"`
+	Success    bool   `json:"success,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+// threadID is the ID of the only thread, which evaluates the program.
+const threadID = 1
+
+// dapServer serves one debugging session over a connection.
+type dapServer struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	mu  sync.Mutex // guards out and seq
+	seq int
+
+	debugger    *Debugger
+	path        string // of the program
+	program     *ast.Program
+	stopOnEntry bool
+
+	// handles for variables, valid while the program is paused
+	handles []any // environments and arrays and hashes
+	running sync.WaitGroup
+}
+
+// ServeDAP serves the Debug Adapter Protocol on in and out until the client
+// disconnects. Programs are debugged with an interpreter created with opts,
+// whose output is sent to the client.
+func ServeDAP(in io.Reader, out io.Writer, opts ...evaluator.Option) error {
+	s := &dapServer{in: bufio.NewReader(in), out: out}
+	opts = append(opts, evaluator.WithOutput(outputWriter{s}))
+	s.debugger = New(opts...)
+
+	for {
+		msg, err := s.read()
+		if err != nil {
+			s.debugger.Stop()
+			s.running.Wait()
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+
+		body, err := s.request(msg)
+		s.respond(msg, body, err)
+		if err == nil {
+			s.after(msg)
+		}
+		if msg.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (s *dapServer) read() (*dapMessage, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &dapMessage{}
+	return msg, json.Unmarshal(body, msg)
+}
+
+func (s *dapServer) send(msg *dapMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *dapServer) respond(req *dapMessage, body any, err error) {
+	resp := &dapMessage{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	s.send(resp)
+}
+
+func (s *dapServer) event(name string, body any) {
+	s.send(&dapMessage{Type: "event", Event: name, Body: body})
+}
+
+// outputWriter sends what the program prints to the client.
+type outputWriter struct{ s *dapServer }
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.event("output", map[string]any{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
+
+// request handles a request and returns the body of its response.
+func (s *dapServer) request(msg *dapMessage) (any, error) {
+	d := s.debugger
+
+	switch msg.Command {
+	case "initialize":
+		return map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.load(args.Program, args.StopOnEntry)
+
+	case "setBreakpoints":
+		var args struct {
+			Source struct {
+				Path string `json:"path"`
+			} `json:"source"`
+			Breakpoints []struct {
+				Line      int    `json:"line"`
+				Condition string `json:"condition"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		// these are all the breakpoints of the source
+		file := s.file(args.Source.Path)
+		for _, bp := range d.Breakpoints() {
+			if bp.File == file {
+				d.ClearBreakpoint(file, bp.Line)
+			}
+		}
+		breakpoints := []map[string]any{}
+		for _, bp := range args.Breakpoints {
+			result := map[string]any{"line": bp.Line, "verified": true}
+			if err := d.SetBreakpoint(file, bp.Line, bp.Condition); err != nil {
+				result["verified"] = false
+				result["message"] = err.Error()
+			}
+			breakpoints = append(breakpoints, result)
+		}
+		return map[string]any{"breakpoints": breakpoints}, nil
+
+	case "configurationDone":
+		if s.program == nil {
+			return nil, errors.New("no program was launched")
+		}
+		return nil, nil
+
+	case "threads":
+		return map[string]any{"threads": []map[string]any{{"id": threadID, "name": "main"}}}, nil
+
+	case "stackTrace":
+		frames := []map[string]any{}
+		for i, f := range d.Stack() {
+			frames = append(frames, map[string]any{
+				"id":     i,
+				"name":   f.Name,
+				"line":   f.Pos.Line,
+				"column": f.Pos.Column,
+				"source": s.source(f.File),
+			})
+		}
+		return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		stack := d.Stack()
+		if args.FrameID < 0 || args.FrameID >= len(stack) {
+			return nil, fmt.Errorf("no frame %d", args.FrameID)
+		}
+		scopes := []map[string]any{}
+		for env, depth := stack[args.FrameID].Env, 0; env != nil; env, depth = env.Outer(), depth+1 {
+			name := "Locals"
+			switch {
+			case env.Outer() == nil:
+				name = "Globals"
+			case depth > 0:
+				name = fmt.Sprintf("Closure %d", depth)
+			}
+			scopes = append(scopes, map[string]any{"name": name, "variablesReference": s.handle(env), "expensive": false})
+		}
+		return map[string]any{"scopes": scopes}, nil
+
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+			return nil, fmt.Errorf("no variables %d", args.VariablesReference)
+		}
+		return map[string]any{"variables": s.variables(s.handles[args.VariablesReference-1])}, nil
+
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		result, err := d.Evaluate(args.FrameID, args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"result": inspect(result), "type": string(result.Type()), "variablesReference": s.handle(result)}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		if !d.Paused() {
+			return nil, errors.New("the program is not paused")
+		}
+		return map[string]any{"allThreadsContinued": true}, nil
+
+	case "terminate", "disconnect":
+		d.Stop()
+		s.running.Wait()
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unsupported request %q", msg.Command)
+}
+
+// after does what follows the response to a request: the program starts
+// when it is configured, and resumes when asked to.
+func (s *dapServer) after(msg *dapMessage) {
+	d := s.debugger
+
+	switch msg.Command {
+	case "initialize":
+		s.event("initialized", nil)
+	case "configurationDone":
+		s.run(func() Event { return d.Start(s.program, object.NewEnvironment(), s.stopOnEntry) })
+	case "continue":
+		s.resume(cmdContinue)
+	case "next":
+		s.resume(cmdStepOver)
+	case "stepIn":
+		s.resume(cmdStepIn)
+	case "stepOut":
+		s.resume(cmdStepOut)
+	case "terminate":
+		s.event("terminated", nil)
+	}
+}
+
+// resume sends cmd to the paused program before the next request is read,
+// so that requests see it running, and reports when it stops.
+func (s *dapServer) resume(cmd command) {
+	if s.debugger.send(cmd) {
+		s.run(s.debugger.wait)
+	}
+}
+
+// run waits in another goroutine for the program to stop and reports it.
+// The handles of variables are invalid once the program runs.
+func (s *dapServer) run(wait func() Event) {
+	s.handles = nil
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		event := wait()
+		if event.Reason != ReasonExited {
+			s.event("stopped", map[string]any{"reason": event.Reason, "threadId": threadID, "allThreadsStopped": true})
+			return
+		}
+
+		exitCode := 0
+		if errObj, ok := event.Result.(*object.Error); ok {
+			exitCode = 1
+			s.event("output", map[string]any{"category": "stderr", "output": "ERROR: " + errObj.Message + "\n"})
+		}
+		s.event("exited", map[string]any{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+// load reads the program at path.
+func (s *dapServer) load(path string, stopOnEntry bool) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	program, err := s.debugger.Load(string(source))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	s.path, s.program, s.stopOnEntry = path, program, stopOnEntry
+	return nil
+}
+
+// file returns the file breakpoints are set in for a source at path: empty
+// for the program, and the real path of modules, by which their functions
+// know them.
+func (s *dapServer) file(path string) string {
+	if path == "" || path == s.path {
+		return ""
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if real, err := filepath.EvalSymlinks(s.path); err == nil && real == path {
+		return ""
+	}
+	return path
+}
+
+// source returns the source of a frame in file, a module or the program if
+// it is empty.
+func (s *dapServer) source(file string) map[string]any {
+	if file == "" {
+		file = s.path
+	}
+	return map[string]any{"name": filepath.Base(file), "path": file}
+}
+
+// handle returns the reference by which the client asks for the variables
+// of an environment, or the elements of an array or hash, or 0 for values
+// without any.
+func (s *dapServer) handle(v any) int {
+	switch v := v.(type) {
+	case *object.Environment:
+	case *object.Array:
+		if len(v.Elements) == 0 {
+			return 0
+		}
+	case *object.Hash:
+		if len(v.Pairs) == 0 {
+			return 0
+		}
+	default:
+		return 0
+	}
+	s.handles = append(s.handles, v)
+	return len(s.handles)
+}
+
+func (s *dapServer) variables(v any) []map[string]any {
+	variables := []map[string]any{}
+	add := func(name string, value object.Object) {
+		variables = append(variables, map[string]any{
+			"name":               name,
+			"value":              inspect(value),
+			"type":               string(value.Type()),
+			"variablesReference": s.handle(value),
+		})
+	}
+
+	switch v := v.(type) {
+	case *object.Environment:
+		for _, b := range Bindings(v) {
+			add(b.Name, b.Value)
+		}
+	case *object.Array:
+		for i, el := range v.Elements {
+			add(strconv.Itoa(i), el)
+		}
+	case *object.Hash:
+		for _, pair := range v.SortedPairs() {
+			add(pair.Key.Inspect(), pair.Value)
+		}
+	}
+	return variables
+}
+
+// inspect returns the Inspect output of a value, shortened to its parameters
+// for functions.
+func inspect(obj object.Object) string {
+	if fn, ok := obj.(*object.Function); ok {
+		params := make([]string, len(fn.Parameters))
+		for i, p := range fn.Parameters {
+			params[i] = p.Value
+		}
+		return "fn(" + strings.Join(params, ", ") + ")"
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,435 @@
+// Package debugger runs Monkey programs under control: it pauses them at
+// breakpoints, which may have conditions, steps through them statement by
+// statement, and inspects the call stack and environments while they are
+// paused. Front ends drive it through the methods of Debugger; the package
+// also serves it over the Debug Adapter Protocol.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+)
+
+// Reasons for which a program stops, as reported in Events.
+const (
+	ReasonEntry      = "entry"
+	ReasonBreakpoint = "breakpoint"
+	ReasonStep       = "step"
+	ReasonExited     = "exited"
+)
+
+// Event reports why a program stopped. Programs that exited have the reason
+// ReasonExited and the result of their evaluation, which may be an error.
+type Event struct {
+	Reason string
+	File   string         // the path of the module the statement is in, empty for the program
+	Pos    token.Position // of the statement about to be evaluated
+	Result object.Object
+}
+
+// Breakpoint pauses a program before it evaluates a statement starting on
+// Line in File, the path of an imported module or empty for the program, if
+// Condition is empty or evaluates to a truthy value in the environment of
+// the statement.
+type Breakpoint struct {
+	File      string
+	Line      int
+	Condition string
+	Hits      int // times the program paused at it
+}
+
+// location is a line of the program or of a module, as lines repeat across
+// files.
+type location struct {
+	file string
+	line int
+}
+
+// Frame is a call on the call stack of a paused program.
+type Frame struct {
+	Name     string           // of the function called, or "main" for the program
+	Function *object.Function // nil for the program
+	File     string           // the path of the module the function is in, empty for the program
+	Pos      token.Position   // of the statement being evaluated
+	Env      *object.Environment
+}
+
+// errStopped ends the evaluation of a program stopped by Stop.
+var errStopped = errors.New("stopped by the debugger")
+
+type command int
+
+const (
+	cmdContinue command = iota
+	cmdStepIn
+	cmdStepOver
+	cmdStepOut
+	cmdStop
+)
+
+// Debugger runs one program at a time. Its methods must not be called
+// concurrently, except for Stop.
+type Debugger struct {
+	interpreter *evaluator.Interpreter
+
+	mu          sync.Mutex
+	breakpoints map[location]*Breakpoint
+	paused      bool
+	last        Event // the event the program stopped with last
+
+	events   chan Event
+	commands chan command
+	stopped  atomic.Bool
+
+	// The state of the running program. While it is paused, the goroutine
+	// evaluating it is blocked, so methods may read it.
+	frames      []*Frame
+	mode        command // how the program was resumed
+	modeDepth   int     // the depth of the call stack then
+	stopOnEntry bool
+	lastFile    string // of the statement evaluated last
+	lastLine    int
+	lastDepth   int
+	evaluating  bool // an expression for the debugger, not the program
+}
+
+// New returns a debugger that evaluates programs with an interpreter created
+// with opts.
+func New(opts ...evaluator.Option) *Debugger {
+	d := &Debugger{
+		breakpoints: map[location]*Breakpoint{},
+		events:      make(chan Event),
+		commands:    make(chan command),
+	}
+	d.interpreter = evaluator.New(append(opts, evaluator.WithTracer(d))...)
+	return d
+}
+
+// SetBreakpoint sets a breakpoint on line of file, the path of a module or
+// empty for the program, replacing any breakpoint there. It fails if
+// condition is not a valid expression.
+func (d *Debugger) SetBreakpoint(file string, line int, condition string) error {
+	condition = strings.TrimSpace(condition)
+	if condition != "" {
+		if _, err := parse(condition); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[location{file, line}] = &Breakpoint{File: file, Line: line, Condition: condition}
+	return nil
+}
+
+// ClearBreakpoint removes the breakpoint on line of file and reports whether
+// there was one.
+func (d *Debugger) ClearBreakpoint(file string, line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.breakpoints[location{file, line}]
+	delete(d.breakpoints, location{file, line})
+	return ok
+}
+
+// ClearBreakpoints removes all breakpoints.
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = map[location]*Breakpoint{}
+}
+
+// Breakpoints returns the breakpoints ordered by file and line, those of the
+// program first.
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var bps []Breakpoint
+	for _, bp := range d.breakpoints {
+		bps = append(bps, *bp)
+	}
+	sort.Slice(bps, func(i, j int) bool {
+		if bps[i].File != bps[j].File {
+			return bps[i].File < bps[j].File
+		}
+		return bps[i].Line < bps[j].Line
+	})
+	return bps
+}
+
+// Load parses the program source and expands its macros.
+func (d *Debugger) Load(source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(p.Errors()[0])
+	}
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+	return d.interpreter.ExpandMacros(program, macros)
+}
+
+// Start evaluates program in env until it stops, before its first statement
+// if stopOnEntry is true. A program that has not exited must be stopped
+// before another one can be started.
+func (d *Debugger) Start(program *ast.Program, env *object.Environment, stopOnEntry bool) Event {
+	d.frames = []*Frame{{Name: "main", Env: env}}
+	d.mode = cmdContinue
+	d.stopOnEntry = stopOnEntry
+	d.lastFile, d.lastLine, d.lastDepth = "", 0, 0
+	d.stopped.Store(false)
+
+	go func() {
+		result := d.interpreter.Eval(program, env)
+		d.events <- Event{Reason: ReasonExited, Result: result}
+	}()
+	return d.wait()
+}
+
+// Continue resumes the paused program until it stops at a breakpoint or
+// exits.
+func (d *Debugger) Continue() Event { return d.resume(cmdContinue) }
+
+// StepIn resumes the paused program until it reaches another statement.
+func (d *Debugger) StepIn() Event { return d.resume(cmdStepIn) }
+
+// StepOver resumes the paused program until it reaches another statement
+// that is not in a function called by the current one.
+func (d *Debugger) StepOver() Event { return d.resume(cmdStepOver) }
+
+// StepOut resumes the paused program until the current function returns.
+func (d *Debugger) StepOut() Event { return d.resume(cmdStepOut) }
+
+// Stop ends the program, which exits with an error, and waits for that
+// unless another goroutine is waiting for it to stop.
+func (d *Debugger) Stop() {
+	d.stopped.Store(true)
+	if d.Paused() {
+		d.resume(cmdStop)
+	}
+}
+
+// Paused reports whether a program is paused.
+func (d *Debugger) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// resume sends cmd to the paused program and waits for it to stop again.
+// Without a paused program, it returns the event the last one stopped with.
+func (d *Debugger) resume(cmd command) Event {
+	if !d.send(cmd) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.last
+	}
+	return d.wait()
+}
+
+// send sends cmd to the paused program, and reports whether there was one.
+func (d *Debugger) send(cmd command) bool {
+	d.mu.Lock()
+	if !d.paused {
+		d.mu.Unlock()
+		return false
+	}
+	d.paused = false
+	d.mu.Unlock()
+
+	d.commands <- cmd
+	return true
+}
+
+func (d *Debugger) wait() Event {
+	event := <-d.events
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = event.Reason != ReasonExited
+	d.last = event
+	return event
+}
+
+// Stack returns the call stack of the paused program, innermost call first.
+func (d *Debugger) Stack() []Frame {
+	if !d.Paused() {
+		return nil
+	}
+	stack := make([]Frame, len(d.frames))
+	for i, f := range d.frames {
+		stack[len(d.frames)-1-i] = *f
+	}
+	return stack
+}
+
+// Evaluate evaluates the expression, or program, source in the environment
+// of the frame of the paused program at index frame of Stack.
+func (d *Debugger) Evaluate(frame int, source string) (object.Object, error) {
+	if !d.Paused() {
+		return nil, errors.New("the program is not paused")
+	}
+	if frame < 0 || frame >= len(d.frames) {
+		return nil, fmt.Errorf("no frame %d", frame)
+	}
+	result, err := d.evaluate(source, d.frames[len(d.frames)-1-frame].Env)
+	if err != nil {
+		return nil, err
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	return result, nil
+}
+
+func (d *Debugger) evaluate(source string, env *object.Environment) (object.Object, error) {
+	program, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	d.evaluating = true
+	defer func() { d.evaluating = false }()
+	result := d.interpreter.Eval(program, env)
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
+
+func parse(source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(p.Errors(), "; "))
+	}
+	return program, nil
+}
+
+// Statement implements evaluator.Tracer and pauses the program if it has
+// to stop before stmt.
+func (d *Debugger) Statement(stmt ast.Statement, env *object.Environment) error {
+	if d.evaluating {
+		return nil
+	}
+	if d.stopped.Load() {
+		return errStopped
+	}
+
+	frame := d.frames[len(d.frames)-1]
+	frame.Pos, frame.Env = stmt.Pos(), env
+
+	loc, depth := location{frame.File, stmt.Pos().Line}, len(d.frames)
+	reason := d.reason(loc, depth, env)
+	d.lastFile, d.lastLine, d.lastDepth = loc.file, loc.line, depth
+	if reason == "" {
+		return nil
+	}
+
+	d.events <- Event{Reason: reason, File: frame.File, Pos: stmt.Pos()}
+	cmd := <-d.commands
+	if cmd == cmdStop {
+		return errStopped
+	}
+	d.mode, d.modeDepth = cmd, depth
+	return nil
+}
+
+// reason returns why the program stops before a statement at loc with depth
+// calls on the stack, or "" if it does not. Statements on the line and in the
+// call that the program stopped at last do not stop it again.
+func (d *Debugger) reason(loc location, depth int, env *object.Environment) string {
+	if d.stopOnEntry {
+		d.stopOnEntry = false
+		return ReasonEntry
+	}
+	if loc.file == d.lastFile && loc.line == d.lastLine && depth == d.lastDepth {
+		return ""
+	}
+
+	switch {
+	case d.mode == cmdStepIn,
+		d.mode == cmdStepOver && depth <= d.modeDepth,
+		d.mode == cmdStepOut && depth < d.modeDepth:
+		return ReasonStep
+	}
+
+	d.mu.Lock()
+	bp, ok := d.breakpoints[loc]
+	d.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	if bp.Condition != "" {
+		// a condition that fails stops the program, so the error can be seen
+		result, err := d.evaluate(bp.Condition, env)
+		if err == nil && result.Type() != object.ERROR_OBJ && !truthy(result) {
+			return ""
+		}
+	}
+	d.mu.Lock()
+	bp.Hits++
+	d.mu.Unlock()
+	return ReasonBreakpoint
+}
+
+func truthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return false
+	case *object.Boolean:
+		return obj.Value
+	}
+	return true
+}
+
+// Call implements evaluator.Tracer and pushes a frame for fn.
+func (d *Debugger) Call(fn *object.Function, call *ast.CallExpression, env *object.Environment) {
+	if d.evaluating {
+		return
+	}
+	name := "fn"
+	if call != nil {
+		if ident, ok := call.Function.(*ast.Identifier); ok {
+			name = ident.Value
+		}
+	}
+	// a new call may stop on the line the program stopped at last
+	d.lastLine = 0
+	d.frames = append(d.frames, &Frame{Name: name, Function: fn, File: fn.File, Pos: fn.Body.Token.Pos, Env: env})
+}
+
+// Return implements evaluator.Tracer and pops the frame of fn.
+func (d *Debugger) Return(fn *object.Function, result object.Object) {
+	if d.evaluating {
+		return
+	}
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+// Binding is a name bound in an environment.
+type Binding struct {
+	Name  string
+	Value object.Object
+}
+
+// Bindings returns the names bound in env, but not in the environments
+// enclosing it, ordered by name.
+func Bindings(env *object.Environment) []Binding {
+	names := env.Names()
+	sort.Strings(names)
+	bindings := make([]Binding, len(names))
+	for i, name := range names {
+		value, _ := env.Get(name)
+		bindings[i] = Binding{Name: name, Value: value}
+	}
+	return bindings
+}
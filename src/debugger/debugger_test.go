@@ -0,0 +1,415 @@
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+const source = `let double = fn(x) {
+    let y = x * 2;
+    y
+};
+let a = double(1);
+let b = double(a);
+let sum = fn(n) {
+    if (n == 0) { return 0; }
+    n + sum(n - 1)
+};
+puts(a + b + sum(3));
+`
+
+func parseProgram(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func newDebugger() (*Debugger, *bytes.Buffer) {
+	var out bytes.Buffer
+	return New(evaluator.WithOutput(&out)), &out
+}
+
+// describe returns the reason and line of an event and the names of the
+// frames on the stack.
+func describe(d *Debugger, event Event) string {
+	if event.Reason == ReasonExited {
+		return "exited " + event.Result.Inspect()
+	}
+	var names []string
+	for _, f := range d.Stack() {
+		names = append(names, f.Name)
+	}
+	return fmt.Sprintf("%s %d %s", event.Reason, event.Pos.Line, strings.Join(names, "<"))
+}
+
+func TestBreakpoints(t *testing.T) {
+	d, out := newDebugger()
+	d.SetBreakpoint("", 2, "")
+	d.SetBreakpoint("", 6, "")
+
+	expected := []string{
+		"breakpoint 2 double<main",
+		"breakpoint 6 main",
+		"breakpoint 2 double<main",
+		"exited null",
+	}
+
+	event := d.Start(parseProgram(t, source), object.NewEnvironment(), false)
+	for i, want := range expected {
+		if i > 0 {
+			event = d.Continue()
+		}
+		if got := describe(d, event); got != want {
+			t.Fatalf("event %d wrong. want=%q, got=%q", i, want, got)
+		}
+	}
+	if out.String() != "12\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	bps := d.Breakpoints()
+	if len(bps) != 2 || bps[0].Hits != 2 || bps[1].Hits != 1 {
+		t.Errorf("wrong breakpoints. got=%+v", bps)
+	}
+	if !d.ClearBreakpoint("", 6) || d.ClearBreakpoint("", 6) || len(d.Breakpoints()) != 1 {
+		t.Errorf("breakpoint was not cleared. got=%+v", d.Breakpoints())
+	}
+}
+
+func TestConditionalBreakpoints(t *testing.T) {
+	d, _ := newDebugger()
+	if err := d.SetBreakpoint("", 9, "n == 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetBreakpoint("", 3, "x +"); err == nil {
+		t.Errorf("expected an error for an invalid condition")
+	}
+
+	event := d.Start(parseProgram(t, source), object.NewEnvironment(), false)
+	if got := describe(d, event); got != "breakpoint 9 sum<sum<sum<main" {
+		t.Fatalf("wrong event. got=%q", got)
+	}
+	n, err := d.Evaluate(0, "n")
+	if err != nil || n.Inspect() != "1" {
+		t.Errorf("wrong n. got=%v, %v", n, err)
+	}
+	if got := describe(d, d.Continue()); got != "exited null" {
+		t.Errorf("wrong event. got=%q", got)
+	}
+
+	// a condition that fails stops the program
+	d.SetBreakpoint("", 2, "missing > 1")
+	event = d.Start(parseProgram(t, source), object.NewEnvironment(), false)
+	if got := describe(d, event); got != "breakpoint 2 double<main" {
+		t.Errorf("wrong event. got=%q", got)
+	}
+	d.Stop()
+}
+
+func TestModuleBreakpoints(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "m.mk"), []byte("export let g = fn(x) {\n    x * 2\n};\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	module, _ := filepath.EvalSymlinks(filepath.Join(dir, "m.mk"))
+	const input = "import \"m.mk\" as m;\nlet y = m.g(1);\nputs(y);\n"
+
+	var out bytes.Buffer
+	d := New(evaluator.WithOutput(&out), evaluator.WithModulePaths(dir))
+
+	// a breakpoint on line 2 of the program does not stop in the module
+	d.SetBreakpoint("", 2, "")
+	event := d.Start(parseProgram(t, input), object.NewEnvironment(), false)
+	if got := describe(d, event); got != "breakpoint 2 main" || event.File != "" {
+		t.Fatalf("wrong event. got=%q in %q", got, event.File)
+	}
+	if got := describe(d, d.Continue()); got != "exited null" {
+		t.Fatalf("wrong event. got=%q", got)
+	}
+
+	d.ClearBreakpoints()
+	d.SetBreakpoint(module, 2, "")
+	event = d.Start(parseProgram(t, input), object.NewEnvironment(), false)
+	if got := describe(d, event); got != "breakpoint 2 fn<main" || event.File != module {
+		t.Fatalf("wrong event. got=%q in %q", got, event.File)
+	}
+	if stack := d.Stack(); stack[0].File != module || stack[1].File != "" {
+		t.Errorf("wrong files. got=%q, %q", stack[0].File, stack[1].File)
+	}
+	if got := describe(d, d.Continue()); got != "exited null" {
+		t.Errorf("wrong event. got=%q", got)
+	}
+	if out.String() != "2\n2\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		steps    string // i(n), o(ver), (ou)t
+		expected []string
+	}{
+		{"ooo", []string{"step 5 main", "step 6 main", "step 7 main"}},
+		{"oiiio", []string{
+			"step 5 main", "step 2 double<main", "step 3 double<main", "step 6 main", "step 7 main",
+		}},
+		{"oito", []string{"step 5 main", "step 2 double<main", "step 6 main", "step 7 main"}},
+		{"ooooiit", []string{
+			"step 5 main", "step 6 main", "step 7 main", "step 11 main",
+			"step 8 sum<main", "step 9 sum<main", "exited null",
+		}},
+	}
+
+	for _, tt := range tests {
+		d, _ := newDebugger()
+		event := d.Start(parseProgram(t, source), object.NewEnvironment(), true)
+		if got := describe(d, event); got != "entry 1 main" {
+			t.Fatalf("wrong entry event. got=%q", got)
+		}
+
+		for i, step := range tt.steps {
+			switch step {
+			case 'i':
+				event = d.StepIn()
+			case 'o':
+				event = d.StepOver()
+			case 't':
+				event = d.StepOut()
+			}
+			if got := describe(d, event); got != tt.expected[i] {
+				t.Errorf("%s: step %d wrong. want=%q, got=%q", tt.steps, i, tt.expected[i], got)
+				break
+			}
+		}
+		d.Stop()
+	}
+}
+
+func TestInspection(t *testing.T) {
+	d, _ := newDebugger()
+	d.SetBreakpoint("", 3, "")
+	env := object.NewEnvironment()
+	d.Start(parseProgram(t, source), env, false)
+
+	stack := d.Stack()
+	if len(stack) != 2 || stack[0].Name != "double" || stack[0].Pos.String() != "3:5" || stack[1].Pos.String() != "5:1" {
+		t.Fatalf("wrong stack. got=%+v", stack)
+	}
+
+	var locals []string
+	for _, b := range Bindings(stack[0].Env) {
+		locals = append(locals, b.Name+"="+b.Value.Inspect())
+	}
+	if strings.Join(locals, " ") != "x=1 y=2" {
+		t.Errorf("wrong locals. got=%v", locals)
+	}
+	if stack[0].Env.Outer() != env {
+		t.Errorf("the function's environment does not enclose the program's")
+	}
+
+	tests := []struct {
+		frame    int
+		input    string
+		expected string
+	}{
+		{0, "x + y", "3"},
+		{0, "double(y)", "4"},
+		{1, "double", "fn"},
+		{1, "x", "identifier not found: x"},
+		{0, "x +", "no prefix parse function for EOF"},
+		{2, "1", "no frame 2"},
+	}
+	for _, tt := range tests {
+		result, err := d.Evaluate(tt.frame, tt.input)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = result.Inspect()
+		}
+		if !strings.HasPrefix(got, tt.expected) {
+			t.Errorf("Evaluate(%d, %q) wrong. want=%q, got=%q", tt.frame, tt.input, tt.expected, got)
+		}
+	}
+
+	// evaluating does not stop at breakpoints or change the stack
+	if len(d.Stack()) != 2 {
+		t.Errorf("evaluating changed the stack. got=%+v", d.Stack())
+	}
+	d.Stop()
+	if d.Paused() || d.Stack() != nil {
+		t.Errorf("program is still paused after Stop")
+	}
+	if _, err := d.Evaluate(0, "1"); err == nil {
+		t.Errorf("expected an error evaluating without a paused program")
+	}
+}
+
+func TestStop(t *testing.T) {
+	d, out := newDebugger()
+	d.SetBreakpoint("", 2, "")
+	d.Start(parseProgram(t, source), object.NewEnvironment(), false)
+	d.Stop()
+
+	event := d.Continue()
+	if event.Reason != ReasonExited || event.Result.Inspect() != "ERROR: stopped by the debugger" {
+		t.Errorf("wrong event after Stop. got=%+v", event)
+	}
+	if out.Len() != 0 {
+		t.Errorf("stopped program printed %q", out.String())
+	}
+
+	// tail calls replace their frame
+	d.ClearBreakpoints()
+	d.SetBreakpoint("", 2, "n == 0")
+	program := parseProgram(t, "let loop = fn(n) {\n  if (n == 0) { n } else { loop(n - 1) }\n};\nloop(1000)")
+	if got := describe(d, d.Start(program, object.NewEnvironment(), false)); got != "breakpoint 2 loop<main" {
+		t.Errorf("wrong event. got=%q", got)
+	}
+	if got := describe(d, d.Continue()); got != "exited 0" {
+		t.Errorf("wrong event. got=%q", got)
+	}
+}
+
+// dapClient drives a DAP server in tests.
+type dapClient struct {
+	t   *testing.T
+	in  *io.PipeWriter
+	out *bufio.Reader
+	seq int
+}
+
+func (c *dapClient) request(command string, arguments any) {
+	c.t.Helper()
+	c.seq++
+	msg := map[string]any{"seq": c.seq, "type": "request", "command": command}
+	if arguments != nil {
+		msg["arguments"] = arguments
+	}
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// expect reads messages until a response to command, or an event named
+// command, and returns its body.
+func (c *dapClient) expect(command string) map[string]any {
+	c.t.Helper()
+	for {
+		header, err := textproto.NewReader(c.out).ReadMIMEHeader()
+		if err != nil {
+			c.t.Fatalf("reading %s: %s", command, err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		data := make([]byte, length)
+		io.ReadFull(c.out, data)
+
+		var msg struct {
+			Type, Command, Event, Message string
+			Success                       bool
+			Body                          map[string]any
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.t.Fatalf("invalid message %s: %s", data, err)
+		}
+		switch {
+		case msg.Type == "response" && msg.Command == command:
+			if !msg.Success {
+				c.t.Fatalf("%s failed: %s", command, msg.Message)
+			}
+			return msg.Body
+		case msg.Type == "event" && msg.Event == command:
+			return msg.Body
+		}
+	}
+}
+
+func TestDAP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "program.mk")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() { done <- ServeDAP(inR, outW) }()
+	c := &dapClient{t: t, in: inW, out: bufio.NewReader(outR)}
+
+	c.request("initialize", map[string]any{"adapterID": "monkey"})
+	c.expect("initialize")
+	c.expect("initialized")
+	c.request("launch", map[string]any{"program": path})
+	c.expect("launch")
+	c.request("setBreakpoints", map[string]any{"breakpoints": []map[string]any{{"line": 2, "condition": "x > 1"}}})
+	if bps := c.expect("setBreakpoints")["breakpoints"].([]any); len(bps) != 1 || bps[0].(map[string]any)["verified"] != true {
+		t.Errorf("wrong breakpoints. got=%v", bps)
+	}
+	c.request("configurationDone", nil)
+	c.expect("configurationDone")
+	if stopped := c.expect("stopped"); stopped["reason"] != ReasonBreakpoint {
+		t.Errorf("wrong reason. got=%v", stopped["reason"])
+	}
+
+	c.request("stackTrace", map[string]any{"threadId": threadID})
+	frames := c.expect("stackTrace")["stackFrames"].([]any)
+	var stack []string
+	for _, f := range frames {
+		f := f.(map[string]any)
+		stack = append(stack, fmt.Sprintf("%s:%v", f["name"], f["line"]))
+	}
+	if got := strings.Join(stack, " "); got != "double:2 main:6" {
+		t.Errorf("wrong stack. got=%q", got)
+	}
+
+	c.request("scopes", map[string]any{"frameId": 0})
+	scopes := c.expect("scopes")["scopes"].([]any)
+	locals := scopes[0].(map[string]any)
+	if locals["name"] != "Locals" || len(scopes) != 2 {
+		t.Errorf("wrong scopes. got=%v", scopes)
+	}
+	c.request("variables", map[string]any{"variablesReference": locals["variablesReference"]})
+	variables := c.expect("variables")["variables"].([]any)
+	if x := variables[0].(map[string]any); len(variables) != 1 || x["name"] != "x" || x["value"] != "2" {
+		t.Errorf("wrong variables. got=%v", variables)
+	}
+
+	c.request("evaluate", map[string]any{"expression": "[x, x * 3]", "frameId": 0})
+	if result := c.expect("evaluate"); result["result"] != "[2, 6]" || result["variablesReference"].(float64) == 0 {
+		t.Errorf("wrong result. got=%v", result)
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID})
+	c.expect("continue")
+	if output := c.expect("output"); output["output"] != "12\n" {
+		t.Errorf("wrong output. got=%v", output)
+	}
+	if exited := c.expect("exited"); exited["exitCode"].(float64) != 0 {
+		t.Errorf("wrong exit code. got=%v", exited)
+	}
+	c.expect("terminated")
+
+	c.request("disconnect", nil)
+	c.expect("disconnect")
+	if err := <-done; err != nil {
+		t.Errorf("ServeDAP returned %s", err)
+	}
+}
@@ -0,0 +1,393 @@
+// Package embedding makes it easy to use Monkey from Go programs: it converts
+// between Go values and Monkey objects, exposes Go functions to scripts and
+// calls Monkey functions from Go.
+package embedding
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+
+	"monkey/object"
+)
+
+// Struct fields are converted to hash keys named after the field, unless a
+// `monkey:"name"` tag says otherwise. A tag of "-" skips the field. The fields
+// of embedded structs are promoted, unless the embedded struct is tagged, in
+// which case it is converted like any other field.
+const tagName = "monkey"
+
+var objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+
+// ToObject converts a Go value to a Monkey object. Supported are nil, bools,
+// integers, strings, slices and arrays, maps with string, integer or bool
+// keys, structs, pointers to any of these, functions (see NewFunction) and
+// values that already are an object.Object. Values that contain themselves
+// cannot be converted.
+func ToObject(v any) (object.Object, error) {
+	if v == nil {
+		return object.NULL, nil
+	}
+	return toObject(reflect.ValueOf(v), map[visit]bool{})
+}
+
+// visit identifies a pointer, map or slice by the memory it refers to.
+type visit struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// enter marks v, a non-nil pointer, map or slice, as being converted and
+// returns the function that unmarks it, or an error if v is already being
+// converted, which means that it contains itself.
+func enter(v reflect.Value, visiting map[visit]bool) (func(), error) {
+	key := visit{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if visiting[key] {
+		return nil, fmt.Errorf("cannot convert %s that contains itself", v.Type())
+	}
+	visiting[key] = true
+	return func() { delete(visiting, key) }, nil
+}
+
+// toObject converts v, which is contained in the pointers, maps and slices
+// in visiting.
+func toObject(v reflect.Value, visiting map[visit]bool) (object.Object, error) {
+	if v.Type().Implements(objectType) {
+		if v.IsNil() {
+			return object.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return object.TRUE, nil
+		}
+		return object.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows a Monkey integer", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return object.NULL, nil
+		}
+		if v.Kind() == reflect.Pointer {
+			leave, err := enter(v, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		return toObject(v.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return object.NULL, nil
+			}
+			leave, err := enter(v, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			el, err := toObject(v.Index(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return object.NULL, nil
+		}
+		leave, err := enter(v, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, v.Len())}
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toObject(iter.Key(), visiting)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := toObject(iter.Value(), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", key.Inspect(), err)
+			}
+			hash.Pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		for _, field := range structFields(v.Type()) {
+			f, err := v.FieldByIndexErr(field.index)
+			if err != nil {
+				// promoted through a nil embedded pointer
+				continue
+			}
+			value, err := toObject(f, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.name, err)
+			}
+			key := &object.String{Value: field.name}
+			hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return object.NULL, nil
+		}
+		return NewFunction(v.Interface())
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Monkey object", v.Type())
+	}
+}
+
+// FromObject stores obj in the value pointed to by target, converting it to
+// the target's type the same way ToObject converts in the other direction.
+// Targets of type any receive the value returned by ToGo.
+func FromObject(obj object.Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+	return fromObject(obj, v.Elem())
+}
+
+func fromObject(obj object.Object, v reflect.Value) error {
+	if v.Type() == objectType {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			break
+		}
+		if goValue := ToGo(obj); goValue != nil {
+			v.Set(reflect.ValueOf(goValue))
+		} else {
+			v.SetZero()
+		}
+		return nil
+	case reflect.Pointer:
+		if obj.Type() == object.NULL_OBJ {
+			v.SetZero()
+			return nil
+		}
+		ptr := reflect.New(v.Type().Elem())
+		if err := fromObject(obj, ptr.Elem()); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			v.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return fmt.Errorf("%d overflows %s", i.Value, v.Type())
+			}
+			v.SetInt(i.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*object.Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return fmt.Errorf("%d overflows %s", i.Value, v.Type())
+			}
+			v.SetUint(uint64(i.Value))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			v.SetString(s.Value)
+			return nil
+		}
+	case reflect.Slice:
+		if obj.Type() == object.NULL_OBJ {
+			v.SetZero()
+			return nil
+		}
+		if arr, ok := obj.(*object.Array); ok {
+			slice := reflect.MakeSlice(v.Type(), len(arr.Elements), len(arr.Elements))
+			for i, el := range arr.Elements {
+				if err := fromObject(el, slice.Index(i)); err != nil {
+					return fmt.Errorf("index %d: %w", i, err)
+				}
+			}
+			v.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*object.Array); ok {
+			if len(arr.Elements) != v.Len() {
+				return fmt.Errorf("cannot convert ARRAY of length %d to %s", len(arr.Elements), v.Type())
+			}
+			for i, el := range arr.Elements {
+				if err := fromObject(el, v.Index(i)); err != nil {
+					return fmt.Errorf("index %d: %w", i, err)
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if obj.Type() == object.NULL_OBJ {
+			v.SetZero()
+			return nil
+		}
+		if hash, ok := obj.(*object.Hash); ok {
+			m := reflect.MakeMapWithSize(v.Type(), len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key := reflect.New(v.Type().Key()).Elem()
+				if err := fromObject(pair.Key, key); err != nil {
+					return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				value := reflect.New(v.Type().Elem()).Elem()
+				if err := fromObject(pair.Value, value); err != nil {
+					return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if hash, ok := obj.(*object.Hash); ok {
+			for _, field := range structFields(v.Type()) {
+				key := &object.String{Value: field.name}
+				pair, ok := hash.Pairs[key.HashKey()]
+				if !ok {
+					continue
+				}
+				f, err := fieldByIndex(v, field.index)
+				if err != nil {
+					return fmt.Errorf("field %s: %w", field.name, err)
+				}
+				if err := fromObject(pair.Value, f); err != nil {
+					return fmt.Errorf("field %s: %w", field.name, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), v.Type())
+}
+
+// ToGo converts obj to the natural Go representation: int64, string, bool,
+// nil, []any, map[string]any for hashes with string keys and map[any]any
+// for other hashes. Functions and other objects are returned unchanged.
+func ToGo(obj object.Object) any {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		values := make([]any, len(obj.Elements))
+		for i, el := range obj.Elements {
+			values[i] = ToGo(el)
+		}
+		return values
+	case *object.Hash:
+		stringKeys := make(map[string]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				break
+			}
+			stringKeys[key.Value] = ToGo(pair.Value)
+		}
+		if len(stringKeys) == len(obj.Pairs) {
+			return stringKeys
+		}
+		anyKeys := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			anyKeys[ToGo(pair.Key)] = ToGo(pair.Value)
+		}
+		return anyKeys
+	default:
+		return obj
+	}
+}
+
+// fieldByIndex returns the field of the struct v at index, like
+// reflect.Value.FieldByIndex, but allocates the nil embedded struct pointers
+// the field is promoted through.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot allocate embedded pointer to unexported %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields lists the exported fields of t, including those promoted from
+// untagged embedded structs, with the names they have in Monkey hashes.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	var tagged [][]int // embedded fields whose fields are not promoted
+	for _, f := range reflect.VisibleFields(t) {
+		if slices.ContainsFunc(tagged, func(index []int) bool {
+			return len(f.Index) > len(index) && slices.Equal(f.Index[:len(index)], index)
+		}) {
+			continue
+		}
+		tag := f.Tag.Get(tagName)
+		if f.Anonymous && tag != "" {
+			tagged = append(tagged, f.Index)
+		}
+		if !f.IsExported() || tag == "-" || f.Anonymous && tag == "" {
+			continue
+		}
+		name := f.Name
+		if tag != "" {
+			name = tag
+		}
+		fields = append(fields, structField{name: name, index: f.Index})
+	}
+	return fields
+}
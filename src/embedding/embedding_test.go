@@ -0,0 +1,481 @@
+package embedding
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+type address struct {
+	City string `monkey:"city"`
+}
+
+type customer struct {
+	Name    string   `monkey:"name"`
+	Age     int      `monkey:"age"`
+	Tags    []string `monkey:"tags"`
+	Address *address `monkey:"address"`
+	Secret  string   `monkey:"-"`
+	Plain   bool
+	hidden  int
+}
+
+type Point struct {
+	X int `monkey:"x"`
+}
+
+type labeled struct {
+	*Point
+	Label string `monkey:"label"`
+}
+
+type located struct {
+	*Point `monkey:"point"`
+	Label  string `monkey:"label"`
+}
+
+type node struct {
+	Next *node `monkey:"next"`
+}
+
+func TestToObject(t *testing.T) {
+	shared := &Point{X: 1}
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{"monkey", "monkey"},
+		{true, "true"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"b": 2, "a": 1}, "{a: 1, b: 2}"},
+		{map[int]bool{1: true}, "{1: true}"},
+		{&address{City: "Lisbon"}, "{city: Lisbon}"},
+		{(*address)(nil), "null"},
+		{
+			customer{Name: "Ann", Age: 30, Tags: []string{"vip"}, Secret: "x", Plain: true, hidden: 1},
+			"{Plain: true, address: null, age: 30, name: Ann, tags: [vip]}",
+		},
+		{&object.Integer{Value: 5}, "5"},
+		{labeled{Label: "origin"}, "{label: origin}"},
+		{labeled{Point: &Point{X: 1}, Label: "a"}, "{label: a, x: 1}"},
+		{located{Point: &Point{X: 1}, Label: "a"}, "{label: a, point: {x: 1}}"},
+		{located{Label: "a"}, "{label: a, point: null}"},
+		{[]*Point{shared, shared}, "[{x: 1}, {x: 1}]"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("ToObject(%#v) returned error: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("ToObject(%#v) wrong. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	if _, err := ToObject(1.5); err == nil {
+		t.Errorf("expected error converting float64")
+	}
+	if _, err := ToObject(map[[1]int]int{{1}: 1}); err == nil {
+		t.Errorf("expected error converting map with array keys")
+	}
+}
+
+func TestToObjectCycles(t *testing.T) {
+	n := &node{}
+	n.Next = &node{Next: n}
+	m := map[string]any{}
+	m["self"] = []any{m}
+	s := []any{nil}
+	s[0] = s
+
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{n, "field next: field next: cannot convert *embedding.node that contains itself"},
+		{m, "key self: index 0: cannot convert map[string]interface {} that contains itself"},
+		{s, "index 0: cannot convert []interface {} that contains itself"},
+	}
+
+	for _, tt := range tests {
+		_, err := ToObject(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("ToObject(%T) wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	e := New()
+	obj, err := e.Run(`{"name": "Ann", "age": 30, "tags": ["vip"], "address": {"city": "Lisbon"}, "Plain": true, "extra": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c customer
+	if err := FromObject(obj, &c); err != nil {
+		t.Fatal(err)
+	}
+	expected := customer{Name: "Ann", Age: 30, Tags: []string{"vip"}, Address: &address{City: "Lisbon"}, Plain: true}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("FromObject wrong. want=%+v, got=%+v", expected, c)
+	}
+
+	var l labeled
+	if err := FromObject(&object.Hash{Pairs: map[object.HashKey]object.HashPair{}}, &l); err != nil || l.Point != nil {
+		t.Errorf("FromObject allocated an embedded pointer without its fields. got=%+v, err=%v", l, err)
+	}
+	if _, err := e.Run(`let l = {"x": 2, "label": "b"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Get("l", &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Point == nil || l.X != 2 || l.Label != "b" {
+		t.Errorf("Get into embedded pointer wrong. got=%+v", l)
+	}
+	if err := e.Set("unset", labeled{Label: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	var unset labeled
+	if err := e.Get("unset", &unset); err != nil || unset.Point != nil || unset.Label != "c" {
+		t.Errorf("Get of a nil embedded pointer wrong. got=%+v, err=%v", unset, err)
+	}
+
+	var loc located
+	if _, err := e.Run(`let loc = {"point": {"x": 3}, "x": 4, "label": "d"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Get("loc", &loc); err != nil || loc.Point == nil || loc.X != 3 || loc.Label != "d" {
+		t.Errorf("Get into tagged embedded pointer wrong. got=%+v, err=%v", loc, err)
+	}
+
+	var m map[string]any
+	if err := FromObject(obj, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["age"] != int64(30) || !reflect.DeepEqual(m["tags"], []any{"vip"}) {
+		t.Errorf("FromObject into map wrong. got=%v", m)
+	}
+
+	var small int8
+	err = FromObject(&object.Integer{Value: 300}, &small)
+	if err == nil || err.Error() != "300 overflows int8" {
+		t.Errorf("expected overflow error. got=%v", err)
+	}
+
+	var s string
+	err = FromObject(&object.Integer{Value: 1}, &s)
+	if err == nil || err.Error() != "cannot convert INTEGER to string" {
+		t.Errorf("expected conversion error. got=%v", err)
+	}
+
+	if err := FromObject(obj, c); err == nil {
+		t.Errorf("expected error for non-pointer target")
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	e := New()
+	must(t, e.Register("add", func(a, b int) int { return a + b }))
+	must(t, e.Register("join", func(sep string, parts ...string) string { return strings.Join(parts, sep) }))
+	must(t, e.Register("check", func(n int) (bool, error) {
+		if n < 0 {
+			return false, errors.New("negative input")
+		}
+		return n%2 == 0, nil
+	}))
+	must(t, e.Register("greet", func(c customer) string { return "hi " + c.Name }))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`add(1, 2)`, "3"},
+		{`join("-", "a", "b", "c")`, "a-b-c"},
+		{`join("-")`, ""},
+		{`check(4)`, "true"},
+		{`greet({"name": "Ann"})`, "hi Ann"},
+		{`type(add)`, "BUILTIN"},
+	}
+
+	for _, tt := range tests {
+		obj, err := e.Run(tt.input)
+		if err != nil {
+			t.Errorf("%s returned error: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("%s wrong. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`check(-1)`, "negative input"},
+		{`add(1)`, "wrong number of arguments. got=1, want=2"},
+		{`add(1, "2")`, "argument 2: cannot convert STRING to int"},
+		{`join()`, "wrong number of arguments. got=0, want at least 1"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := e.Run(tt.input)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.expected {
+			t.Errorf("%s wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	if err := e.Register("bad", 5); err == nil {
+		t.Errorf("expected error registering a non-function")
+	}
+}
+
+func TestCallMonkeyFunction(t *testing.T) {
+	e := New()
+	must(t, e.Set("discount", 10))
+	_, err := e.Run(`let price = fn(item) { item["base"] - discount };`)
+	must(t, err)
+
+	var result int
+	must(t, e.Call("price", &result, map[string]int{"base": 100}))
+	if result != 90 {
+		t.Errorf("price wrong. want=90, got=%d", result)
+	}
+
+	err = e.Call("price", &result, 1)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "index operator not supported: INTEGER" {
+		t.Errorf("expected runtime error. got=%v", err)
+	}
+
+	if err := e.Call("missing", nil); err == nil {
+		t.Errorf("expected error calling an unknown function")
+	}
+
+	var discount int
+	must(t, e.Get("discount", &discount))
+	if discount != 10 {
+		t.Errorf("discount wrong. got=%d", discount)
+	}
+
+	_, err = e.Run(`let x = ;`)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("expected parse error. got=%v", err)
+	}
+}
+
+func TestEngineMacros(t *testing.T) {
+	e := New()
+	_, err := e.Run(`let unless = macro(cond, then, otherwise) { quote(if (!(unquote(cond))) { unquote(then) } else { unquote(otherwise) }) };`)
+	must(t, err)
+
+	obj, err := e.Run(`unless(1 > 2, "yes", "no")`)
+	must(t, err)
+	if obj.Inspect() != "yes" {
+		t.Errorf("wrong result. want=yes, got=%s", obj.Inspect())
+	}
+
+	if _, err := e.Run(`unless(true)`); err == nil || err.Error() != "macro unless: wrong number of arguments. got=1, want=3" {
+		t.Errorf("expected expansion error. got=%v", err)
+	}
+}
+
+func TestEngineModules(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "greet.mk"), []byte(`export let greet = fn(name) { "hello " + name };`), 0o644)
+	must(t, err)
+
+	e := New(evaluator.WithModulePaths(dir))
+	must(t, e.Set("name", "monkey"))
+	obj, err := e.Run(`import { greet } from "greet.mk"; greet(name)`)
+	must(t, err)
+	if obj.Inspect() != "hello monkey" {
+		t.Errorf("wrong result. want=hello monkey, got=%s", obj.Inspect())
+	}
+
+	program, err := Compile(`import "greet.mk" as g; g.greet(name)`, []string{"name"}, evaluator.WithModulePaths(dir))
+	must(t, err)
+	obj, err = program.Eval("go")
+	must(t, err)
+	if obj.Inspect() != "hello go" {
+		t.Errorf("wrong result. want=hello go, got=%s", obj.Inspect())
+	}
+
+	if _, err := New().Run(`import "greet.mk"`); err == nil || err.Error() != "imports are disabled: there are no module paths" {
+		t.Errorf("expected imports to be disabled. got=%v", err)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const pricingRule = `
+let discount = fn(qty) { if (qty > 100) { 10 } else { if (qty > 10) { 5 } else { 0 } } };
+base * qty - base * qty * discount(qty) / 100 + shipping[region]
+`
+
+var pricingVars = []string{"base", "qty", "region", "shipping"}
+
+func TestCompile(t *testing.T) {
+	program, err := Compile(pricingRule, pricingVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shipping := map[string]int{"eu": 5, "us": 7}
+	tests := []struct {
+		base, qty int
+		region    string
+		expected  string
+	}{
+		{10, 1, "eu", "15"},
+		{10, 20, "us", "197"},
+		{10, 200, "eu", "1805"},
+	}
+
+	for _, tt := range tests {
+		result, err := program.Eval(tt.base, tt.qty, tt.region, shipping)
+		if err != nil {
+			t.Errorf("Eval returned error: %s", err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%s, got=%s", tt.expected, result.Inspect())
+		}
+	}
+
+	if _, err := program.Eval(1, 2); err == nil || err.Error() != "wrong number of values. got=2, want=4" {
+		t.Errorf("expected error for missing values. got=%v", err)
+	}
+
+	_, err = program.Eval(1, 2, "eu", 3)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("expected runtime error. got=%v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		vars     []string
+		expected string
+	}{
+		{`price * qty`, []string{"price"}, "identifier not found: qty"},
+		{`fn(x) { x + y }`, nil, "identifier not found: y"},
+		{`if (true) { let y = 1; }; fn() { y }`, nil, ""},
+		{`let f = fn() { g() }; let g = fn() { len(str(upper)) };`, nil, ""},
+		{`let a = [fn(x) { let b = 1; x }]; b`, nil, "identifier not found: b"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.input, tt.vars)
+		switch {
+		case tt.expected == "" && err != nil:
+			t.Errorf("Compile(%q) returned error: %s", tt.input, err)
+		case tt.expected != "" && (err == nil || err.Error() != tt.expected):
+			t.Errorf("Compile(%q) wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestCompiledProgramConcurrently(t *testing.T) {
+	program, err := Compile(pricingRule, pricingVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipping := map[string]int{"eu": 5}
+
+	done := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func(qty int) {
+			for j := 0; j < 100; j++ {
+				result, err := program.Eval(10, qty, "eu", shipping)
+				if err != nil {
+					done <- err
+					return
+				}
+				var total int
+				if err := FromObject(result, &total); err != nil || total != 10*qty+5 {
+					done <- fmt.Errorf("qty %d: wrong total %d", qty, total)
+					return
+				}
+			}
+			done <- nil
+		}(i + 1)
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func BenchmarkParseAndEval(b *testing.B) {
+	e := New()
+	e.Set("base", 10)
+	e.Set("region", "eu")
+	e.Set("shipping", map[string]int{"eu": 5})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Set("qty", i%300)
+		if _, err := e.Run(pricingRule); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledEval(b *testing.B) {
+	program, err := Compile(pricingRule, pricingVars)
+	if err != nil {
+		b.Fatal(err)
+	}
+	shipping, _ := ToObject(map[string]int{"eu": 5})
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := program.Eval(10, i%300, "eu", shipping); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledEvalParallel(b *testing.B) {
+	program, err := Compile(pricingRule, pricingVars)
+	if err != nil {
+		b.Fatal(err)
+	}
+	shipping, _ := ToObject(map[string]int{"eu": 5})
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			if _, err := program.Eval(10, i%300, "eu", shipping); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
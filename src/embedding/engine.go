@@ -0,0 +1,139 @@
+package embedding
+
+import (
+	"fmt"
+	"strings"
+
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+// ParseError is returned when a script does not parse.
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return "parser errors: " + strings.Join(e.Errors, "; ")
+}
+
+// RuntimeError is returned when evaluating a script or calling a Monkey
+// function results in a Monkey error.
+type RuntimeError struct {
+	Message string
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+
+// Engine runs Monkey scripts in a global environment shared between calls,
+// so values set from Go are visible to scripts and vice versa.
+type Engine struct {
+	interpreter *evaluator.Interpreter
+	env         *object.Environment
+	macros      *object.Environment
+}
+
+// New returns an engine whose interpreter is created with opts. Scripts can
+// only import modules from the directories given with
+// evaluator.WithModulePaths.
+func New(opts ...evaluator.Option) *Engine {
+	return &Engine{
+		interpreter: evaluator.New(opts...),
+		env:         object.NewEnvironment(),
+		macros:      object.NewEnvironment(),
+	}
+}
+
+// Interpreter returns the interpreter used by the engine.
+func (e *Engine) Interpreter() *evaluator.Interpreter { return e.interpreter }
+
+// Set converts value with ToObject and binds it to name.
+func (e *Engine) Set(name string, value any) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	e.env.Set(name, obj)
+	return nil
+}
+
+// Register exposes the Go function fn to scripts as name. See NewFunction.
+func (e *Engine) Register(name string, fn any) error {
+	builtin, err := NewFunction(fn)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	e.env.Set(name, builtin)
+	return nil
+}
+
+// Get stores the value bound to name in target, see FromObject.
+func (e *Engine) Get(name string, target any) error {
+	obj, ok := e.env.Get(name)
+	if !ok {
+		return fmt.Errorf("identifier not found: %s", name)
+	}
+	return FromObject(obj, target)
+}
+
+// Run evaluates source and returns the value of its last statement.
+func (e *Engine) Run(source string) (object.Object, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	// macros defined by one script can be used by later ones
+	evaluator.DefineMacros(program, e.macros)
+	program, err := e.interpreter.ExpandMacros(program, e.macros)
+	if err != nil {
+		return nil, err
+	}
+
+	result := e.interpreter.Eval(program, e.env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Message: errObj.Message}
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
+
+// Call calls the Monkey function bound to name with args converted by
+// ToObject, and stores its result in target unless target is nil.
+func (e *Engine) Call(name string, target any, args ...any) error {
+	fn, ok := e.env.Get(name)
+	if !ok {
+		return fmt.Errorf("identifier not found: %s", name)
+	}
+	return Call(e.interpreter, fn, target, args...)
+}
+
+// Call calls fn, a Monkey function or builtin, with args converted by
+// ToObject, and stores its result in target unless target is nil.
+func Call(interpreter *evaluator.Interpreter, fn object.Object, target any, args ...any) error {
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objs[i] = obj
+	}
+
+	result := interpreter.Call(fn, objs...)
+	if errObj, ok := result.(*object.Error); ok {
+		return &RuntimeError{Message: errObj.Message}
+	}
+	if target == nil {
+		return nil
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return FromObject(result, target)
+}
@@ -0,0 +1,84 @@
+package embedding
+
+import (
+	"fmt"
+	"reflect"
+
+	"monkey/object"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// NewFunction wraps the Go function fn as a Monkey builtin. Arguments are
+// converted to the parameter types of fn with FromObject, and the result with
+// ToObject. fn may be variadic and may return nothing, a value, an error, or
+// a value and an error; a non-nil error becomes a Monkey error.
+func NewFunction(fn any) (*object.Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("expected a function, got %T", fn)
+	}
+	t := v.Type()
+
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	numValues := t.NumOut()
+	if returnsError {
+		numValues--
+	}
+	if numValues > 1 {
+		return nil, fmt.Errorf("%s returns more than one value", t)
+	}
+
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			in, err := functionArgs(t, args)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+
+			out := v.Call(in)
+			if returnsError {
+				if err, _ := out[len(out)-1].Interface().(error); err != nil {
+					return &object.Error{Message: err.Error()}
+				}
+			}
+			if numValues == 0 {
+				return object.NULL
+			}
+
+			result, err := toObject(out[0], map[visit]bool{})
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return result
+		},
+	}, nil
+}
+
+func functionArgs(t reflect.Type, args []object.Object) ([]reflect.Value, error) {
+	numFixed := t.NumIn()
+	if t.IsVariadic() {
+		numFixed--
+		if len(args) < numFixed {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), numFixed)
+		}
+	} else if len(args) != numFixed {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), numFixed)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if i < numFixed {
+			paramType = t.In(i)
+		} else {
+			paramType = t.In(numFixed).Elem()
+		}
+		param := reflect.New(paramType).Elem()
+		if err := fromObject(arg, param); err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in[i] = param
+	}
+	return in, nil
+}
@@ -0,0 +1,77 @@
+package embedding
+
+import (
+	"fmt"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+// Program is a Monkey script that has been parsed, resolved and optimized
+// once and can then be evaluated many times, also concurrently, with
+// different values for its variables.
+type Program struct {
+	interpreter *evaluator.Interpreter
+	program     *ast.Program
+	vars        []string
+}
+
+// Compile parses source, which may refer to the variables named in vars
+// besides the builtins of the interpreter. Referring to any other identifier
+// that the script does not define itself is an error.
+func Compile(source string, vars []string, opts ...evaluator.Option) (*Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	interpreter := evaluator.New(opts...)
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+	program, err := interpreter.ExpandMacros(program, macros)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolving up front also spares every evaluation from doing it
+	if errors := interpreter.Resolve(program, nil, vars...); len(errors) != 0 {
+		return nil, fmt.Errorf("%s", errors[0])
+	}
+	program = evaluator.Optimize(program)
+
+	return &Program{
+		interpreter: interpreter,
+		program:     program,
+		vars:        vars,
+	}, nil
+}
+
+// Eval evaluates the program with values bound to the variables passed to
+// Compile, in the same order.
+func (p *Program) Eval(values ...any) (object.Object, error) {
+	if len(values) != len(p.vars) {
+		return nil, fmt.Errorf("wrong number of values. got=%d, want=%d", len(values), len(p.vars))
+	}
+
+	env := object.NewEnvironment()
+	for i, name := range p.vars {
+		obj, err := ToObject(values[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		env.Set(name, obj)
+	}
+
+	result := p.interpreter.Eval(p.program, env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Message: errObj.Message}
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
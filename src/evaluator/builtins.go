@@ -1,6 +1,16 @@
 package evaluator
 
-import "monkey/object"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"monkey/object"
+)
 
 var builtins = map[string]*object.Builtin{
 	"len": {
@@ -11,9 +21,386 @@ var builtins = map[string]*object.Builtin{
 			switch arg := args[0].(type) {
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.Array:
+				return &object.Integer{Value: int64(len(arg.Elements))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(len(arg.Pairs))}
 			default:
 				return newError("argument to `len` not supported, got %s", args[0].Type())
 			}
 		},
 	},
+
+	// ****** Strings ******//
+	"split": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("split", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			parts := strings.Split(args[0].(*object.String).Value, args[1].(*object.String).Value)
+			elements := make([]object.Object, len(parts))
+			for i, part := range parts {
+				elements[i] = &object.String{Value: part}
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"join": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("join", args, object.ARRAY_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			elements := args[0].(*object.Array).Elements
+			parts := make([]string, len(elements))
+			for i, el := range elements {
+				s, ok := el.(*object.String)
+				if !ok {
+					return newError("elements of argument 1 to `join` must be STRING, got %s at index %d", el.Type(), i)
+				}
+				parts[i] = s.Value
+			}
+			return &object.String{Value: strings.Join(parts, args[1].(*object.String).Value)}
+		},
+	},
+	"trim": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) == 2 {
+				if err := checkArgs("trim", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+					return err
+				}
+				return &object.String{Value: strings.Trim(args[0].(*object.String).Value, args[1].(*object.String).Value)}
+			}
+			if err := checkArgs("trim", args, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return &object.String{Value: strings.TrimSpace(args[0].(*object.String).Value)}
+		},
+	},
+	"upper": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("upper", args, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return &object.String{Value: strings.ToUpper(args[0].(*object.String).Value)}
+		},
+	},
+	"lower": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("lower", args, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return &object.String{Value: strings.ToLower(args[0].(*object.String).Value)}
+		},
+	},
+	"replace": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("replace", args, object.STRING_OBJ, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			s := args[0].(*object.String).Value
+			old := args[1].(*object.String).Value
+			with := args[2].(*object.String).Value
+			return &object.String{Value: strings.ReplaceAll(s, old, with)}
+		},
+	},
+	"contains": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("contains", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return nativeBoolToBooleanObject(strings.Contains(args[0].(*object.String).Value, args[1].(*object.String).Value))
+		},
+	},
+	"starts_with": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("starts_with", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return nativeBoolToBooleanObject(strings.HasPrefix(args[0].(*object.String).Value, args[1].(*object.String).Value))
+		},
+	},
+	"ends_with": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("ends_with", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return nativeBoolToBooleanObject(strings.HasSuffix(args[0].(*object.String).Value, args[1].(*object.String).Value))
+		},
+	},
+	"index_of": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("index_of", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+				return err
+			}
+			s := args[0].(*object.String).Value
+			idx := strings.Index(s, args[1].(*object.String).Value)
+			if idx < 0 {
+				return &object.Integer{Value: -1}
+			}
+			// report the position in characters, like len and substr do
+			return &object.Integer{Value: int64(utf8.RuneCountInString(s[:idx]))}
+		},
+	},
+	"repeat": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("repeat", args, object.STRING_OBJ, object.INTEGER_OBJ); err != nil {
+				return err
+			}
+			count := args[1].(*object.Integer).Value
+			if count < 0 {
+				return newError("argument 2 to `repeat` must not be negative, got %d", count)
+			}
+			return repeatString(args[0].(*object.String).Value, count)
+		},
+	},
+	"substr": {
+		Fn: func(args ...object.Object) object.Object {
+			var err *object.Error
+			if len(args) == 2 {
+				err = checkArgs("substr", args, object.STRING_OBJ, object.INTEGER_OBJ)
+			} else {
+				err = checkArgs("substr", args, object.STRING_OBJ, object.INTEGER_OBJ, object.INTEGER_OBJ)
+			}
+			if err != nil {
+				return err
+			}
+
+			runes := []rune(args[0].(*object.String).Value)
+			start := args[1].(*object.Integer).Value
+			if start < 0 {
+				return newError("argument 2 to `substr` must not be negative, got %d", start)
+			}
+			end := int64(len(runes))
+			if len(args) == 3 {
+				length := args[2].(*object.Integer).Value
+				if length < 0 {
+					return newError("argument 3 to `substr` must not be negative, got %d", length)
+				}
+				if length < end-start {
+					end = start + length
+				}
+			}
+			if start >= end {
+				return &object.String{Value: ""}
+			}
+			return &object.String{Value: string(runes[start:end])}
+		},
+	},
+	// ****************************//
+
+	// ****** Types ******//
+	"type": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return &object.String{Value: string(args[0].Type())}
+		},
+	},
+	"int": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *object.Integer:
+				return arg
+			case *object.String:
+				value, err := strconv.ParseInt(arg.Value, 10, 64)
+				if err != nil {
+					return newError("could not convert %q to INTEGER", arg.Value)
+				}
+				return &object.Integer{Value: value}
+			case *object.Boolean:
+				if arg.Value {
+					return &object.Integer{Value: 1}
+				}
+				return &object.Integer{Value: 0}
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	"str": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if str, ok := args[0].(*object.String); ok {
+				return str
+			}
+			return &object.String{Value: args[0].Inspect()}
+		},
+	},
+	"bool": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return nativeBoolToBooleanObject(isTruthy(args[0]))
+		},
+	},
+	"is_int":    typePredicate(object.INTEGER_OBJ),
+	"is_string": typePredicate(object.STRING_OBJ),
+	"is_bool":   typePredicate(object.BOOLEAN_OBJ),
+	"is_array":  typePredicate(object.ARRAY_OBJ),
+	"is_null":   typePredicate(object.NULL_OBJ),
+	"is_hash":   typePredicate(object.HASH_OBJ),
+	"is_fn":     typePredicate(object.FUNCTION_OBJ, object.BUILTIN_OBJ),
+	// ****************************//
+
+	// ****** JSON ******//
+	"json_parse": {
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("json_parse", args, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return jsonParse(args[0].(*object.String).Value)
+		},
+	},
+	"json_stringify": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) == 2 {
+				if args[1].Type() != object.INTEGER_OBJ {
+					return newError("argument 2 to `json_stringify` must be INTEGER, got %s", args[1].Type())
+				}
+				return jsonStringify(args[0], args[1].(*object.Integer).Value)
+			}
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return jsonStringify(args[0], 0)
+		},
+	},
+	// ****************************//
+
+	// ****** Paths ******//
+	"path_join": {
+		Fn: func(args ...object.Object) object.Object {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				str, ok := arg.(*object.String)
+				if !ok {
+					return newError("argument %d to `path_join` must be STRING, got %s", i+1, arg.Type())
+				}
+				parts[i] = str.Value
+			}
+			return &object.String{Value: filepath.Join(parts...)}
+		},
+	},
+	"path_base": pathHelper("path_base", filepath.Base),
+	"path_dir":  pathHelper("path_dir", filepath.Dir),
+	"path_ext":  pathHelper("path_ext", filepath.Ext),
+	// ****************************//
+}
+
+// boundBuiltins returns the builtins that depend on the configuration of the
+// interpreter.
+func (in *Interpreter) boundBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"puts": {
+			Fn: func(args ...object.Object) object.Object {
+				for _, arg := range args {
+					fmt.Fprintln(in.out, arg.Inspect())
+				}
+				return NULL
+			},
+		},
+
+		// ****** Files ******//
+		"read_file": {
+			Fn: func(args ...object.Object) object.Object {
+				if err := checkArgs("read_file", args, object.STRING_OBJ); err != nil {
+					return err
+				}
+				return in.files.readFile(args[0].(*object.String).Value)
+			},
+		},
+		"write_file": {
+			Fn: func(args ...object.Object) object.Object {
+				if err := checkArgs("write_file", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+					return err
+				}
+				return in.files.writeFile(args[0].(*object.String).Value, args[1].(*object.String).Value, os.O_TRUNC)
+			},
+		},
+		"append_file": {
+			Fn: func(args ...object.Object) object.Object {
+				if err := checkArgs("append_file", args, object.STRING_OBJ, object.STRING_OBJ); err != nil {
+					return err
+				}
+				return in.files.writeFile(args[0].(*object.String).Value, args[1].(*object.String).Value, os.O_APPEND)
+			},
+		},
+		"list_dir": {
+			Fn: func(args ...object.Object) object.Object {
+				if err := checkArgs("list_dir", args, object.STRING_OBJ); err != nil {
+					return err
+				}
+				return in.files.listDir(args[0].(*object.String).Value)
+			},
+		},
+		"exists": {
+			Fn: func(args ...object.Object) object.Object {
+				if err := checkArgs("exists", args, object.STRING_OBJ); err != nil {
+					return err
+				}
+				return in.files.fileExists(args[0].(*object.String).Value)
+			},
+		},
+		// ****************************//
+	}
+}
+
+// pathHelper wraps a function manipulating a path string as a builtin. These
+// only compute paths, so they do not need file system access.
+func pathHelper(name string, fn func(string) string) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs(name, args, object.STRING_OBJ); err != nil {
+				return err
+			}
+			return &object.String{Value: fn(args[0].(*object.String).Value)}
+		},
+	}
+}
+
+// typePredicate returns a builtin reporting whether its argument is of one of
+// the given types.
+func typePredicate(types ...object.ObjectType) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return nativeBoolToBooleanObject(slices.Contains(types, args[0].Type()))
+		},
+	}
+}
+
+// maxRepeatLength bounds the length of the strings built by repeating
+// another, so a huge count fails instead of exhausting memory.
+const maxRepeatLength = 1 << 30
+
+// repeatString returns s repeated count times, which must not be negative,
+// or an error if the result would be longer than maxRepeatLength bytes.
+func repeatString(s string, count int64) object.Object {
+	if count > 0 && int64(len(s)) > maxRepeatLength/count {
+		return newError("repeated string would be longer than %d bytes", maxRepeatLength)
+	}
+	return &object.String{Value: strings.Repeat(s, int(count))}
+}
+
+// checkArgs validates the number and types of the arguments passed to the
+// builtin called name.
+func checkArgs(name string, args []object.Object, want ...object.ObjectType) *object.Error {
+	if len(args) != len(want) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), len(want))
+	}
+	for i, arg := range args {
+		if arg.Type() != want[i] {
+			return newError("argument %d to `%s` must be %s, got %s", i+1, name, want[i], arg.Type())
+		}
+	}
+	return nil
 }
@@ -7,10 +7,12 @@ import (
 	"monkey/object"
 )
 
+// The singletons live in the object package so that values produced by
+// different interpreters can be compared; these are kept for existing callers.
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
 func isError(obj object.Object) bool {
@@ -20,20 +22,24 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+func (r *run) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := r.step(); err != nil {
+		return err
+	}
+
 	switch node := node.(type) {
 
 	case *ast.Program:
-		return evalProgram(node, env)
+		return r.evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return r.eval(node.Expression, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return r.evalBlockStatement(node, env)
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return r.evalIfExpression(node, env)
 
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
@@ -45,88 +51,210 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := r.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := r.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := r.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := r.eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := r.eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if node.Pattern != nil {
+			return r.destructure(node.Pattern, val, env)
+		}
+		setIdentifier(node.Name, val, env)
+
+	case *ast.ThrowStatement:
+		val := r.eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		return thrownError(val)
+
+	case *ast.TryExpression:
+		return r.evalTryExpression(node, env)
+
+	case *ast.MatchExpression:
+		return r.evalMatchExpression(node, env, false)
+
+	case *ast.ImportStatement:
+		return r.evalImportStatement(node, env)
 
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return r.evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
 		return &object.Function{
 			Parameters: node.Params,
+			Patterns:   node.Patterns,
+			File:       r.file,
 			Body:       node.Body,
 			Env:        env,
+			Locals:     node.Locals,
+			Pos:        node.Pos(),
 		}
 
+	case *ast.MacroLiteral:
+		return newError("macros can only be defined by top-level let statements")
+
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		if isQuoteCall(node) {
+			return r.quote(node, env)
+		}
+		function := r.eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
 
-		args := evalExpressions(node.Arguments, env)
+		args := r.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return r.applyFunction(function, args, node)
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := r.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 
+	case *ast.HashLiteral:
+		return r.evalHashLiteral(node, env)
+
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := r.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := r.eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+
+	case *ast.SelectorExpression:
+		left := r.eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalSelectorExpression(left, node.Selector.Value)
+	}
+
+	return nil
+}
+
+// trace tells the tracer, if there is one, that stmt is about to be
+// evaluated in env.
+func (r *run) trace(stmt ast.Statement, env *object.Environment) *object.Error {
+	if r.tracer == nil || r.untraced {
+		return nil
+	}
+	if err := r.tracer.Statement(stmt, env); err != nil {
+		return &object.Error{Message: err.Error(), Fatal: true}
+	}
+	return nil
+}
+
+// branch tells the tracer, if it traces branches, which branch of node is
+// taken.
+func (r *run) branch(node *ast.IfExpression, consequence bool) {
+	if r.untraced {
+		return
+	}
+	if t, ok := r.tracer.(BranchTracer); ok {
+		t.Branch(node, consequence)
 	}
+}
 
+// step counts an evaluation step against the step limit.
+func (r *run) step() *object.Error {
+	if r.limits.MaxSteps > 0 {
+		r.steps++
+		if r.steps > r.limits.MaxSteps {
+			return &object.Error{Message: fmt.Sprintf("evaluation step limit of %d exceeded", r.limits.MaxSteps), Fatal: true}
+		}
+	}
 	return nil
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn with args. call is the expression making the call,
+// or nil if it is made by Interpreter.Call.
+func (r *run) applyFunction(fn object.Object, args []object.Object, call *ast.CallExpression) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+		}
+		if r.limits.MaxCallDepth > 0 && r.depth >= r.limits.MaxCallDepth {
+			return newError("maximum call depth of %d exceeded", r.limits.MaxCallDepth)
+		}
+		r.depth++
+		r.calls = append(r.calls, call)
+		defer func() {
+			r.depth--
+			r.calls = r.calls[:len(r.calls)-1]
+		}()
+
+		// Calls in tail position come back as a tailCall instead of being
+		// made, and are made here in the same Go frame.
+		for {
+			extendedEnv := extendFunctionEnv(fn, args)
+			traced := r.tracer != nil
+			if traced {
+				r.tracer.Call(fn, call, extendedEnv)
+			}
+			untraced, file := r.untraced, r.file
+			r.untraced, r.file = !traced, fn.File
+			evaluated := r.destructureArguments(fn, args, extendedEnv)
+			if evaluated == nil {
+				evaluated = r.evalBody(fn.Body, extendedEnv, true)
+			}
+			r.untraced, r.file = untraced, file
+
+			tail, ok := evaluated.(*tailCall)
+			if ok && len(tail.args) != len(tail.fn.Parameters) {
+				evaluated = newError("wrong number of arguments. got=%d, want=%d", len(tail.args), len(tail.fn.Parameters))
+				ok = false
+			}
+			if !ok {
+				result := unwrapReturnValue(evaluated)
+				if errObj, ok := result.(*object.Error); ok && errObj.Stack == nil {
+					errObj.Stack = r.stack()
+				}
+				if traced {
+					r.tracer.Return(fn, result)
+				}
+				return result
+			}
+			if traced {
+				r.tracer.Return(fn, nil)
+			}
+			fn, args, call = tail.fn, tail.args, tail.call
+			r.calls[len(r.calls)-1] = call
+		}
 
 	case *object.Builtin:
 		return fn.Fn(args...)
@@ -136,6 +264,107 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+const TAIL_CALL_OBJ = "TAIL_CALL"
+
+// tailCall is a call to a function in tail position that is left to the
+// enclosing applyFunction, so a recursive loop does not grow the Go stack.
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+	call *ast.CallExpression
+}
+
+func (tc *tailCall) Type() object.ObjectType { return TAIL_CALL_OBJ }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalBody evaluates the statements of a function body, or of a block of an
+// if expression in it, like evalBlockStatement. The value of a return
+// statement is in tail position, and so is the last expression if tail is
+// true; calls there to Monkey functions are returned as a tailCall.
+func (r *run) evalBody(block *ast.BlockStatement, env *object.Environment, tail bool) object.Object {
+	var result object.Object
+
+	for i, stmt := range block.Statements {
+		last := tail && i == len(block.Statements)-1
+
+		if err := r.trace(stmt, env); err != nil {
+			return err
+		}
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			if err := r.step(); err != nil {
+				return err
+			}
+			result = r.evalTail(stmt.ReturnValue, env, true)
+			if result != nil && !isError(result) && result.Type() != TAIL_CALL_OBJ {
+				result = &object.ReturnValue{Value: result}
+			}
+		case *ast.ExpressionStatement:
+			result = r.evalTail(stmt.Expression, env, last)
+		default:
+			result = r.eval(stmt, env)
+		}
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == TAIL_CALL_OBJ {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+// evalTail evaluates exp as a statement of a function body. If tail is
+// true, a call to a Monkey function is not made but returned as a tailCall.
+// If expressions are followed into, since their blocks may hold return
+// statements or, if tail is true, calls in tail position, and so are the
+// bodies of match expressions.
+func (r *run) evalTail(exp ast.Expression, env *object.Environment, tail bool) object.Object {
+	switch exp := exp.(type) {
+	case *ast.IfExpression:
+		if err := r.step(); err != nil {
+			return err
+		}
+		cond := r.eval(exp.Condition, env)
+		if isError(cond) {
+			return cond
+		}
+		r.branch(exp, isTruthy(cond))
+		if isTruthy(cond) {
+			return r.evalBody(exp.Consequence, env, tail)
+		} else if exp.Alternative != nil {
+			return r.evalBody(exp.Alternative, env, tail)
+		}
+		return NULL
+
+	case *ast.MatchExpression:
+		if err := r.step(); err != nil {
+			return err
+		}
+		return r.evalMatchExpression(exp, env, tail)
+
+	case *ast.CallExpression:
+		if !tail || isQuoteCall(exp) {
+			break
+		}
+		function := r.eval(exp.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := r.evalExpressions(exp.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		if fn, ok := function.(*object.Function); ok {
+			return &tailCall{fn: fn, args: args, call: exp}
+		}
+		return r.applyFunction(function, args, exp)
+	}
+
+	return r.eval(exp, env)
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -144,6 +373,14 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+	if fn.Locals != nil {
+		env := object.NewSlotEnvironment(fn.Env, fn.Locals)
+		for paramIdx, param := range fn.Parameters {
+			env.SetSlot(param.Slot, args[paramIdx])
+		}
+		return env
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -151,10 +388,10 @@ func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviro
 	return env
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (r *run) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	objects := []object.Object{}
 	for _, exp := range exps {
-		evaluated := Eval(exp, env)
+		evaluated := r.eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -167,38 +404,40 @@ func newError(format string, s ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, s...)}
 }
 
-func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
-	cond := Eval(node.Condition, env)
+func (r *run) evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
+	cond := r.eval(node.Condition, env)
 	if isError(cond) {
 		return cond
 	}
+	r.branch(node, isTruthy(cond))
 	if isTruthy(cond) {
-		return Eval(node.Consequence, env)
+		return r.eval(node.Consequence, env)
 	} else if node.Alternative != nil {
-		return Eval(node.Alternative, env)
+		return r.eval(node.Alternative, env)
 	} else {
 		return NULL
 	}
 }
 
 func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
+	switch obj := obj.(type) {
+	case *object.Null:
 		return false
+	case *object.Boolean:
+		return obj.Value
 	default:
 		return true
 	}
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (r *run) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		if err := r.trace(stmt, env); err != nil {
+			return err
+		}
+		result = r.eval(stmt, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -210,11 +449,14 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (r *run) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
+		if err := r.trace(stmt, env); err != nil {
+			return err
+		}
+		result = r.eval(stmt, env)
 		if result != nil {
 			rt := result.Type()
 			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
@@ -244,16 +486,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!isTruthy(right))
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
@@ -273,10 +506,14 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 		return evalStringInfixExpression(operator, left, right)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case operator == "*" && left.Type() == object.STRING_OBJ && right.Type() == object.INTEGER_OBJ:
+		return evalStringRepetition(left, right)
+	case operator == "*" && left.Type() == object.INTEGER_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringRepetition(right, left)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(objectsEqual(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!objectsEqual(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -285,14 +522,76 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 }
 
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	if operator != "+" {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+}
 
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
+func evalStringRepetition(str object.Object, count object.Object) object.Object {
+	n := count.(*object.Integer).Value
+	if n < 0 {
+		return newError("negative repeat count: %d", n)
+	}
+	return repeatString(str.(*object.String).Value, n)
+}
+
+// objectsEqual reports whether two objects are structurally equal. Integers,
+// strings and booleans compare by value, arrays compare element by element and
+// hashes compare key by key; every other object is only equal to itself.
+func objectsEqual(left, right object.Object) bool {
+	if left.Type() != right.Type() {
+		return false
+	}
 
-	return &object.String{Value: leftVal + rightVal}
+	switch left := left.(type) {
+	case *object.Integer:
+		return left.Value == right.(*object.Integer).Value
+	case *object.String:
+		return left.Value == right.(*object.String).Value
+	case *object.Boolean:
+		return left.Value == right.(*object.Boolean).Value
+	case *object.Null:
+		return true
+	case *object.Array:
+		rightElements := right.(*object.Array).Elements
+		if len(left.Elements) != len(rightElements) {
+			return false
+		}
+		for i, el := range left.Elements {
+			if !objectsEqual(el, rightElements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		rightPairs := right.(*object.Hash).Pairs
+		if len(left.Pairs) != len(rightPairs) {
+			return false
+		}
+		for key, pair := range left.Pairs {
+			other, ok := rightPairs[key]
+			if !ok || !objectsEqual(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return left == right
+	}
 }
 
 func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -307,6 +606,9 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
@@ -321,12 +623,24 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	}
 }
 
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+func (r *run) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if node.Resolved {
+		if node.Slot >= 0 {
+			if val := env.GetSlot(node.Depth, node.Slot); val != nil {
+				return val
+			}
+		} else {
+			env = env.Ancestor(node.Depth)
+		}
+	}
+
+	// Unresolved identifiers, top-level bindings and locals that have not
+	// been assigned yet are looked up by name.
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := r.builtins[node.Value]; ok {
 		return builtin
 	}
 
@@ -337,6 +651,10 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -353,3 +671,56 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 
 	return arrayObject.Elements[idx]
 }
+
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+	max := int64(len(runes) - 1)
+
+	if idx < 0 || idx > max {
+		return NULL
+	}
+
+	return &object.String{Value: string(runes[idx])}
+}
+
+func (r *run) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for _, pair := range node.Pairs {
+		key := r.eval(pair.Key, env)
+		if isError(key) {
+			return key
+		}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := r.eval(pair.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}
+}
+
+func evalHashIndexExpression(hash, index object.Object) object.Object {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return NULL
+	}
+
+	return pair.Value
+}
@@ -1,8 +1,14 @@
 package evaluator
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
@@ -15,6 +21,13 @@ func testEval(input string) object.Object {
 	return Eval(program, object.NewEnvironment())
 }
 
+func testEvalWith(in *Interpreter, input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	return in.Eval(program, object.NewEnvironment())
+}
+
 // INTS
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []struct {
@@ -220,6 +233,10 @@ if (10 > 1) {
 			`"Hello" - "World!"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"let f = fn(x) { 10 / x }; f(0)",
+			"division by zero",
+		},
 	}
 
 	for _, tt := range tests {
@@ -292,6 +309,17 @@ func TestFunctionApplication(t *testing.T) {
 	for _, tt := range tests {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
 	}
+
+	testErrorObject(t, testEval("fn(x, y) { x }(1)"), "wrong number of arguments. got=1, want=2")
+}
+
+func TestInterpreterCall(t *testing.T) {
+	in := New()
+	fn := testEvalWith(in, "fn(x, y) { x * y }")
+
+	testIntegerObject(t, in.Call(fn, &object.Integer{Value: 6}, &object.Integer{Value: 7}), 42)
+	testIntegerObject(t, in.Call(testEval("len"), &object.String{Value: "abc"}), 3)
+	testErrorObject(t, in.Call(&object.Integer{Value: 1}), "not a function: INTEGER")
 }
 
 func TestClosures(t *testing.T) {
@@ -438,3 +466,1264 @@ func TestArrayIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len("héllo")`, 6}, // bytes, not characters
+		{`join(split("a,b,c", ","), "-")`, "a-b-c"},
+		{`join(split("abc", ""), "|")`, "a|b|c"},
+		{`join(["a", 1], ",")`, errorResult("elements of argument 1 to `join` must be STRING, got INTEGER at index 1")},
+		{`trim("  hi  ")`, "hi"},
+		{`trim("xxhixx", "x")`, "hi"},
+		{`upper("Monkey")`, "MONKEY"},
+		{`lower("Monkey")`, "monkey"},
+		{`replace("banana", "a", "o")`, "bonono"},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "donkey")`, false},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`index_of("monkey", "key")`, 3},
+		{`index_of("héllo", "l")`, 2},
+		{`index_of("monkey", "z")`, -1},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`, errorResult("argument 2 to `repeat` must not be negative, got -1")},
+		{`repeat("ab", 4611686018427387904)`, errorResult("repeated string would be longer than 1073741824 bytes")},
+		{`repeat("", 4611686018427387904)`, ""},
+		{`substr("monkey", 3)`, "key"},
+		{`substr("monkey", 1, 3)`, "onk"},
+		{`substr("monkey", 4, 10)`, "ey"},
+		{`substr("monkey", 10)`, ""},
+		{`substr("abcdef", 1, 9223372036854775807)`, "bcdef"},
+		{`substr("abcdef", 1, -1)`, errorResult("argument 3 to `substr` must not be negative, got -1")},
+		{`upper(1)`, errorResult("argument 1 to `upper` must be STRING, got INTEGER")},
+		{`split("a")`, errorResult("wrong number of arguments. got=1, want=2")},
+		{`contains("a", 1)`, errorResult("argument 2 to `contains` must be STRING, got INTEGER")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[5]`, "y"},
+		{`let s = "héllo"; s[1]`, "é"},
+		{`"monkey"[6]`, nil},
+		{`"monkey"[-1]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := tt.expected.(string)
+		if ok {
+			testStringObject(t, evaluated, str)
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// errorResult marks an expected value in table tests as an error message.
+type errorResult string
+
+func testStringObject(t *testing.T, obj object.Object, expected string) bool {
+	result, ok := obj.(*object.String)
+	if !ok {
+		t.Errorf("object is not String. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func testErrorObject(t *testing.T, obj object.Object, expected string) bool {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Errorf("object is not Error. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+		return false
+	}
+	return true
+}
+
+func TestStringOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" != "a"`, false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" > "abc"`, true},
+		{`"ab" * 3`, "ababab"},
+		{`2 * "ab"`, "abab"},
+		{`"ab" * 0`, ""},
+		{`"ab" * -1`, errorResult("negative repeat count: -1")},
+		{`"ab" * 4611686018427387904`, errorResult("repeated string would be longer than 1073741824 bytes")},
+		{`4611686018427387904 * "ab"`, errorResult("repeated string would be longer than 1073741824 bytes")},
+		{`"a" / "b"`, errorResult("unknown operator: STRING / STRING")},
+		{`"a" == 1`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func TestArrayEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`[1, 2, 3] == [1, 2, 3]`, true},
+		{`[1, 2, 3] == [1, 2]`, false},
+		{`[1, 2, 3] != [1, 2, 4]`, true},
+		{`[] == []`, true},
+		{`[[1, "a"], true] == [[1, "a"], true]`, true},
+		{`[[1, "a"], true] == [[1, "b"], true]`, false},
+		{`[1] == 1`, false},
+		{`let a = [1]; a == a`, true},
+		{`let f = fn() {}; [f] == [f]`, true},
+		{`[fn() {}] == [fn() {}]`, false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTypeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`type(1)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type(true)`, "BOOLEAN"},
+		{`type([1])`, "ARRAY"},
+		{`type(if (false) { 1 })`, "NULL"},
+		{`type(fn(x) { x })`, "FUNCTION"},
+		{`type(len)`, "BUILTIN"},
+		{`int("42")`, 42},
+		{`int("-7")`, -7},
+		{`int(5)`, 5},
+		{`int(true)`, 1},
+		{`int(false)`, 0},
+		{`int("4x")`, errorResult(`could not convert "4x" to INTEGER`)},
+		{`int("")`, errorResult(`could not convert "" to INTEGER`)},
+		{`int([1])`, errorResult("argument to `int` not supported, got ARRAY")},
+		{`str(42)`, "42"},
+		{`str("a")`, "a"},
+		{`str(true)`, "true"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`str(1, 2)`, errorResult("wrong number of arguments. got=2, want=1")},
+		{`bool(0)`, true},
+		{`bool("")`, true},
+		{`bool(false)`, false},
+		{`bool(if (false) { 1 })`, false},
+		{`is_int(1)`, true},
+		{`is_int("1")`, false},
+		{`is_string("1")`, true},
+		{`is_bool(false)`, true},
+		{`is_array([])`, true},
+		{`is_array("[]")`, false},
+		{`is_null(if (false) { 1 })`, true},
+		{`is_fn(fn() {})`, true},
+		{`is_fn(len)`, true},
+		{`is_fn(1)`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+{
+    "one": 10 - 9,
+    two: 1 + 1,
+    "thr" + "ee": 6 / 2,
+    4: 4,
+    true: 5,
+    false: 6
+}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		TRUE.HashKey():                             5,
+		FALSE.HashKey():                            6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{"foo": 5}[fn(x) { x }]`, errorResult("unusable as hash key: FUNCTION")},
+		{`{[1]: 5}`, errorResult("unusable as hash key: ARRAY")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{"a": 1} != {"a": 1, "b": 2}`, true},
+		{`{} == {}`, true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`json_parse("42")`, 42},
+		{`json_parse("true")`, true},
+		{`is_null(json_parse("null"))`, true},
+		{`json_parse("[1, 2, 3]")[1]`, 2},
+		{`json_parse("{\"a\": {\"b\": [\"c\"]}}")["a"]["b"][0]`, "c"},
+		{`json_parse("[1, 2] x")`, errorResult("invalid JSON: unexpected data after top-level value")},
+		{`json_parse("1.5")`, errorResult("invalid JSON: number 1.5 is not an integer")},
+		{`json_parse("{")`, errorResult("invalid JSON: unexpected EOF")},
+		{`json_parse(1)`, errorResult("argument 1 to `json_parse` must be STRING, got INTEGER")},
+		{`json_stringify({"b": [1, true, "x"], "a": if (false) { 1 }})`, `{"a":null,"b":[1,true,"x"]}`},
+		{`json_stringify("<&>")`, `"<&>"`},
+		{`json_stringify({"a": [1]}, 2)`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{`json_stringify([1], 4611686018427387904)`, errorResult("repeated string would be longer than 1073741824 bytes")},
+		{`json_stringify([fn(x) { x }])`, errorResult("cannot encode FUNCTION as JSON")},
+		{`json_stringify({1: 2})`, errorResult("cannot encode hash key of type INTEGER as JSON")},
+		{`json_stringify(1, "2")`, errorResult("argument 2 to `json_stringify` must be INTEGER, got STRING")},
+		{`let v = json_parse("{\"k\": [1, {\"n\": null}]}"); json_parse(json_stringify(v)) == v`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	in := New(WithFileAccess(FileAccess{Roots: []string{root}}))
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`exists("notes.txt")`, false},
+		{`write_file("notes.txt", "one")`, nil},
+		{`append_file("notes.txt", ",two")`, nil},
+		{`read_file("notes.txt")`, "one,two"},
+		{`exists("notes.txt")`, true},
+		{`write_file("b.txt", ""); join(list_dir("."), ",")`, "b.txt,link,notes.txt"},
+		{`read_file("missing.txt")`, errorResult(`could not read "missing.txt": no such file or directory`)},
+		{`read_file("../x")`, errorResult(`access to "../x" is not allowed`)},
+		{`read_file("link/secret.txt")`, errorResult(`access to "link/secret.txt" is not allowed`)},
+		{`write_file("link/new.txt", "x")`, errorResult(`access to "link/new.txt" is not allowed`)},
+		{`read_file(1)`, errorResult("argument 1 to `read_file` must be STRING, got INTEGER")},
+		{`path_join("a", "b", "c.mk")`, "a/b/c.mk"},
+		{`path_base("a/b/c.mk")`, "c.mk"},
+		{`path_dir("a/b/c.mk")`, "a/b"},
+		{`path_ext("a/b/c.mk")`, ".mk"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+
+	in = New(WithFileAccess(FileAccess{Roots: []string{root}, ReadOnly: true}))
+	testStringObject(t, testEvalWith(in, `read_file("notes.txt")`), "one,two")
+	testErrorObject(t, testEvalWith(in, `write_file("notes.txt", "")`), "file system access is read-only")
+
+	testErrorObject(t, testEval(`exists("notes.txt")`), "file system access is disabled")
+	testStringObject(t, testEval(`path_base("a/b")`), "b")
+}
+
+func TestFileSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"dangling": filepath.Join(outside, "pwned"),
+		"chained":  "dangling",
+		"secret":   filepath.Join(outside, "secret.txt"),
+		"new":      "created.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	in := New(WithFileAccess(FileAccess{Roots: []string{root}}))
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`write_file("dangling", "x")`, errorResult(`access to "dangling" is not allowed`)},
+		{`append_file("dangling", "x")`, errorResult(`access to "dangling" is not allowed`)},
+		{`write_file("chained", "x")`, errorResult(`access to "chained" is not allowed`)},
+		{`exists("dangling")`, errorResult(`access to "dangling" is not allowed`)},
+		{`read_file("secret")`, errorResult(`access to "secret" is not allowed`)},
+		{`write_file("secret", "x")`, errorResult(`access to "secret" is not allowed`)},
+		// links to files inside the roots may be written through
+		{`write_file("new", "x")`, nil},
+		{`read_file("created.txt")`, "x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); err == nil {
+		t.Errorf("a file was created outside the roots")
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret.txt")); string(data) != "secret" {
+		t.Errorf("a file outside the roots was written. got=%q", data)
+	}
+}
+
+func TestModules(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	files := map[string]string{
+		"math.mk":        `puts("loading math"); let square = fn(x) { x * x }; export let twice = fn(x) { square(x) / x * 2 }; export let pi = 3; export let [e, tau] = [2, 2 * pi];`,
+		"lib/util.mk":    `import { value } from "helper.mk"; import "../math.mk" as math; export let value = math.twice(value);`,
+		"lib/helper.mk":  `export let value = 21;`,
+		"a.mk":           `import "b.mk";`,
+		"b.mk":           `import "a.mk";`,
+		"broken.mk":      `let x = ;`,
+		"failing.mk":     `export let x = 1 + "a";`,
+		"macros.mk":      `let unless = macro(c, a) { quote(if (!(unquote(c))) { unquote(a) }) }; export let check = fn(x) { unless(x, "no") };`,
+		"../secret.mk":   `export let secret = 1;`,
+		"../escape/x.mk": `export let x = 1;`,
+	}
+	for name, source := range files {
+		path := filepath.Join(root, "src", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "outside.mk"), []byte("export let x = 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "outside.mk"), filepath.Join(root, "src", "link.mk")); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	in := New(WithModulePaths(filepath.Join(root, "src")), WithOutput(&out))
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`import "math.mk" as math; math.twice(math.pi)`, 6},
+		{`import { twice, pi as p } from "math.mk"; twice(p)`, 6},
+		{`import { e, tau } from "math.mk"; e + tau`, 8},
+		{`import "math.mk"; 1`, 1},
+		{`import "math.mk" as math; math`, `module "math.mk"`},
+		{`import "lib/util.mk" as util; util.value`, 42},
+		{`import "macros.mk" as m; m.check(false)`, "no"},
+		{`import "math.mk" as math; math.square`, errorResult(`module "math.mk" does not export square`)},
+		{`import { square } from "math.mk"`, errorResult(`module "math.mk" does not export square`)},
+		{`import "a.mk"`, errorResult("a.mk: b.mk: import cycle: a.mk -> b.mk -> a.mk")},
+		{`import "missing.mk"`, errorResult(`module "missing.mk" not found`)},
+		{`import "../secret.mk"`, errorResult(`module "../secret.mk" is outside the module paths`)},
+		{`import "link.mk"`, errorResult(`module "link.mk" is outside the module paths`)},
+		{`import "broken.mk"`, errorResult("broken.mk:1:9: no prefix parse function for ;")},
+		{`import "failing.mk"`, errorResult("failing.mk: type mismatch: INTEGER + STRING")},
+		{`let f = fn() { import "math.mk" as m; m }`, errorResult("import statements must be at the top level")},
+		{`let f = fn() { export let x = 1; }`, errorResult("export of x must be at the top level")},
+		{`5.x`, errorResult("selector not supported: INTEGER")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated == nil || evaluated.Inspect() != expected {
+				t.Errorf("wrong result for %q. got=%v, want=%s", tt.input, evaluated, expected)
+			}
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+
+	if got := strings.Count(out.String(), "loading math"); got != 1 {
+		t.Errorf("math.mk was evaluated %d times, want once", got)
+	}
+
+	testErrorObject(t, testEval(`import "math.mk"`), "imports are disabled: there are no module paths")
+}
+
+func TestArrayBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len([1, 2, 3])`, 3},
+		{`len({"a": 1})`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []int64:
+			testIntegerArray(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestInterpreterOptions(t *testing.T) {
+	var out bytes.Buffer
+	double := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}}
+
+	in := New(
+		WithOutput(&out),
+		WithBuiltins(map[string]*object.Builtin{"double": double}),
+		WithoutBuiltins("upper"),
+	)
+
+	testIntegerObject(t, testEvalWith(in, `double(21)`), 42)
+	testErrorObject(t, testEvalWith(in, `upper("a")`), "identifier not found: upper")
+	testErrorObject(t, testEval(`double(21)`), "identifier not found: double")
+	testStringObject(t, testEval(`upper("a")`), "A")
+
+	testNullObject(t, testEvalWith(in, `puts("hello", 1)`))
+	if out.String() != "hello\n1\n" {
+		t.Errorf("puts wrote wrong output. got=%q", out.String())
+	}
+}
+
+func TestBuiltinsTakePrecedence(t *testing.T) {
+	custom := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.String{Value: "custom"}
+	}}
+	in := New(WithBuiltins(map[string]*object.Builtin{"map": custom, "len": custom}))
+
+	for _, name := range []string{"map", "len"} {
+		testStringObject(t, testEvalWith(in, name+`([1], fn(x) { x })`), "custom")
+		if builtin, ok := in.Builtin(name); !ok || builtin != custom {
+			t.Errorf("wrong builtin %s. got=%v", name, builtin)
+		}
+	}
+	testStringObject(t, testEvalWith(in, `let map = fn(a, f) { "shadowed" }; map([1], fn(x) { x })`), "shadowed")
+
+	in = New(WithoutBuiltins("len"))
+	testErrorObject(t, testEvalWith(in, `len([1])`), "identifier not found: len")
+	if in.Defines("len") || slices.Contains(in.Names(), "len") {
+		t.Errorf("len was not removed. got=%v", in.Names())
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	loop := `let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } };`
+
+	sum := `let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } };`
+
+	in := New(WithLimits(Limits{MaxCallDepth: 10}))
+	testIntegerObject(t, testEvalWith(in, sum+"sum(9)"), 45)
+	testErrorObject(t, testEvalWith(in, sum+"sum(10)"), "maximum call depth of 10 exceeded")
+	testIntegerObject(t, testEvalWith(in, loop+"loop(1000)"), 0)
+
+	in = New(WithLimits(Limits{MaxSteps: 100}))
+	testIntegerObject(t, testEvalWith(in, "1 + 2"), 3)
+	testErrorObject(t, testEvalWith(in, loop+"loop(100)"), "evaluation step limit of 100 exceeded")
+}
+
+func TestTryCatch(t *testing.T) {
+	var out bytes.Buffer
+	in := New(WithOutput(&out))
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`try { 5 } catch (e) { 0 }`, 5},
+		{`try { 1 / 0 } catch (e) { e["message"] + ", " + e["type"] }`, "division by zero, RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got INTEGER"},
+		{`try { throw "bad input"; } catch (e) { e["message"] + ", " + e["type"] }`, "bad input, Error"},
+		{`try { throw {"type": "ParseError", "message": "at 3"}; } catch (e) { e["type"] + ": " + e["message"] }`, "ParseError: at 3"},
+		{`try { throw 42; } catch (e) { e["value"] + len(e["message"]) }`, 44},
+		{`let r = try { throw "x"; } catch (e) { 1 }; r + 1`, 2},
+		{`let f = fn(x) { try { throw x; } catch (e) { e["value"] * 2 } }; f(21)`, 42},
+		{`let f = fn() { try { return 1; } finally { puts("cleanup") } }; f()`, 1},
+		{`let f = fn() { try { throw "a"; } finally { return 2; } }; f()`, 2},
+		{`try { try { throw "inner"; } finally { puts("cleanup") } } catch (e) { e["message"] }`, "inner"},
+		{`let inner = fn() { 1 / 0 };
+let outer = fn() { inner() + 1 };
+try { outer() } catch (e) { join(e["stack"], "; ") }`, "inner at 2:20; outer at 3:7"},
+		{`throw "oops";`, errorResult("oops")},
+		{`try { throw "a"; } finally { 1 }`, errorResult("a")},
+		{`try { throw "a"; } catch (e) { throw e; }`, errorResult("a")},
+		{`try { 1 } finally { missing(); }`, errorResult("identifier not found: missing")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+
+	if out.String() != "cleanup\ncleanup\n" {
+		t.Errorf("finally blocks did not run once each. output=%q", out.String())
+	}
+
+	// limits stop evaluation even in try blocks
+	in = New(WithLimits(Limits{MaxSteps: 100}))
+	testErrorObject(t, testEvalWith(in, `let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { 0 }`), "evaluation step limit of 100 exceeded")
+}
+
+func TestMatchExpressions(t *testing.T) {
+	in := New()
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (-3) { -3 => "minus three", _ => "other" }`, "minus three"},
+		{`match ("b") { "a" => 1, s => len(s) + 10 }`, 11},
+		{`match (true) { false => 0, true => 1 }`, 1},
+		{`match (5) { n if n > 10 => "big", n if n > 0 => "small", _ => "negative" }`, "small"},
+		{`match ([]) { [] => 0, [x] => x, _ => -1 }`, 0},
+		{`match ([7]) { [] => 0, [x] => x, _ => -1 }`, 7},
+		{`match ([1, 2]) { [x] => x, [x, y, z] => z, _ => -1 }`, -1},
+		{`match ([1, 2, 3, 4]) { [first, _, ...rest] => first + len(rest) }`, 3},
+		{`match ([1]) { [a, b, ...rest] => 0, [a, ...rest] => len(rest) }`, 0},
+		{`match ([[1, 2], [3]]) { [[a, b], [c]] => a + b + c }`, 6},
+		{`match ({"name": "x", "age": 3}) { {"name": n, "age": a} => a }`, 3},
+		{`match ({"name": "x"}) { {"age": a} => a, {name} => name }`, "x"},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", r} => 3 * r * r }`, 12},
+		{`match ({"a": 1, "b": 2, "c": 3}) { {"a": 1, ...rest} => len(rest) + rest["c"] }`, 5},
+		{`match ({1: "one", true: "yes"}) { {1: one, true: yes} => one + yes }`, "oneyes"},
+		{`match ("s") { n is INTEGER => n, s is STRING => s + "!" }`, "s!"},
+		{`match ([1, "a"]) { [_ is INTEGER, _ is INTEGER] => "ints", [_, s is STRING] is ARRAY => s }`, "a"},
+		{`match (fn(x) { x }) { _ is FUNCTION => 1, _ => 0 }`, 1},
+		{`let x = 1; match (2) { x => x }; x`, 2},
+		{`let f = fn(list) { match (list) { [] => 0, [x, ...xs] => x + f(xs) } }; f([1, 2, 3])`, 6},
+		{`let f = fn(n, acc) { match (n) { 0 => acc, _ => f(n - 1, acc + n) } }; f(100000, 0)`, 5000050000},
+		{`match (3) { 1 => 1, 2 => 2 }`, errorResult("no arm matches INTEGER 3")},
+		{`match ([1, 2]) { [a] => a }`, errorResult("no arm matches ARRAY [1, 2]")},
+		{`match (1) { n if n > 1 => n }`, errorResult("no arm matches INTEGER 1")},
+		{`match (1) { n if missing => n }`, errorResult("identifier not found: missing")},
+		{`match (1 / 0) { _ => 1 }`, errorResult("division by zero")},
+		{`match (1) { n is NUMBER => n }`, errorResult("unknown type in pattern: NUMBER")},
+		{`match ([1, 1]) { [x, x] => x }`, errorResult("x is bound more than once in pattern [x, x]")},
+		{`try { match (0) {} } catch (e) { e["message"] }`, "no arm matches INTEGER 0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	in := New()
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let [a, b] = [1, 2]; a * 10 + b`, 12},
+		{`let [_, b] = [1, 2]; b`, 2},
+		{`let [a, [b, c]] = [1, [2, 3]]; a + b + c`, 6},
+		{`let [first, ...rest] = [1, 2, 3]; first + len(rest)`, 3},
+		{`let [first, ...rest] = [1]; len(rest)`, 0},
+		{`let [a, b = 5] = [1]; a + b`, 6},
+		{`let [a, b = a + 1] = [1]; b`, 2},
+		{`let [a = missing] = [1]; a`, errorResult("identifier not found: missing")},
+		{`let {"name": n, age} = {"name": "x", "age": 3}; n`, "x"},
+		{`let {age = 18} = {}; age`, 18},
+		{`let {"a": [x, y]} = {"a": [1, 2]}; x + y`, 3},
+		{`let {"a": a, ...others} = {"a": 1, "b": 2, "c": 3}; len(others) + others["c"]`, 5},
+		{`let [1, x] = [1, 2]; x`, 2},
+		{`let [n is INTEGER] = [7]; n`, 7},
+		{`let f = fn([a, b]) { a + b }; f([1, 2])`, 3},
+		{`let f = fn(x, {y = 2}) { x * y }; f(3, {}) + f(3, {"y": 4})`, 18},
+		{`let f = fn([x, ...xs], acc) { if (len(xs) == 0) { acc + x } else { f(xs, acc + x) } }; f([1, 2, 3], 0)`, 6},
+		{`let f = fn([n, acc]) { if (n == 0) { acc } else { f([n - 1, acc + n]) } }; f([100000, 0])`, 5000050000},
+		{`let f = fn(x) { let [y, z] = x; y + z }; f([1, 2]) + f([3, 4])`, 10},
+		{`let [a, b] = [1]`, errorResult("expected 2 elements to destructure with [a, b], got 1")},
+		{`let [a] = [1, 2]`, errorResult("expected 1 elements to destructure with [a], got 2")},
+		{`let [a, b = 1] = []`, errorResult("expected 1 to 2 elements to destructure with [a, b = 1], got 0")},
+		{`let [a, b, ...c] = [1]`, errorResult("expected at least 2 elements to destructure with [a, b, ...c], got 1")},
+		{`let [a] = 1`, errorResult("cannot destructure INTEGER as an array with [a]")},
+		{`let {a} = [1]`, errorResult("cannot destructure ARRAY as a hash with {a}")},
+		{`let {a} = {"b": 1}`, errorResult("key a not found to destructure with {a}")},
+		{`let [1, x] = [2, 3]`, errorResult("INTEGER 2 does not match the pattern 1")},
+		{`let [a = 1 / 0] = []`, errorResult("division by zero")},
+		{`let f = fn([a, b]) { a }; f([1])`, errorResult("expected 2 elements to destructure with [a, b], got 1")},
+		{`let [a, a] = [1, 2]`, errorResult("a is bound more than once in pattern [a, a]")},
+		{`let f = fn() { export let [y] = [1]; y }`, errorResult("export of [y] must be at the top level")},
+		{`try { let {k} = {}; 0 } catch (e) { e["message"] }`, "key k not found to destructure with {k}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } }; countdown(1000000)`, 0},
+		{`let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(1000000, 0)`, 500000500000},
+		{`let loop = fn(n) { if (n > 0) { return loop(n - 1); } "done" }; loop(1000000)`, "done"},
+		{`
+		let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(1000001)
+		`, false},
+		{`let last = fn(arr, i) { if (i == len(arr) - 1) { arr[i] } else { last(arr, i + 1) } }; last([1, 2, 3], 0)`, 3},
+		{`let f = fn(n) { if (n == 0) { g(1, 2) } else { f(n - 1) } }; let g = fn(x) { x }; f(3)`, errorResult("wrong number of arguments. got=2, want=1")},
+		{`let f = fn(n) { if (n == 0) { missing } else { f(n - 1) } }; f(3)`, errorResult("identifier not found: missing")},
+		{`let f = fn() { let x = 1; return len("ab"); }; f()`, 2},
+		{`let f = fn() { return fn() { 5 }(); 10 }; f()`, 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		case errorResult:
+			testErrorObject(t, evaluated, string(expected))
+		}
+	}
+}
+
+func testIntegerArray(t *testing.T, obj object.Object, expected []int64) bool {
+	arr, ok := obj.(*object.Array)
+	if !ok {
+		t.Errorf("object is not Array. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if len(arr.Elements) != len(expected) {
+		t.Errorf("wrong number of elements. want=%d, got=%d", len(expected), len(arr.Elements))
+		return false
+	}
+	for i, el := range expected {
+		if !testIntegerObject(t, arr.Elements[i], el) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResolveUndefinedIdentifiers(t *testing.T) {
+	var out bytes.Buffer
+	in := New(WithOutput(&out))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`puts("side effect"); if (false) { missing }`, "identifier not found: missing"},
+		{`let f = fn(x) { x + y }; 1`, "identifier not found: y"},
+		{`let f = fn() { g() }; let g = fn() { 1 }; f()`, ""},
+		{`let f = fn() { if (true) { let a = 1; }; a }; f()`, ""},
+		{`let f = fn(x) { let inner = fn() { x }; inner }; x`, "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalWith(in, tt.input)
+		if tt.expected == "" {
+			if isError(evaluated) {
+				t.Errorf("%q returned error: %s", tt.input, evaluated.Inspect())
+			}
+			continue
+		}
+		testErrorObject(t, evaluated, tt.expected)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("program with undefined identifier was evaluated. output=%q", out.String())
+	}
+
+	env := object.NewEnvironment()
+	env.Set("existing", &object.Integer{Value: 1})
+	program := parser.New(lexer.New("fn() { existing + missing }")).ParseProgram()
+	errors := in.Resolve(program, env, "missing")
+	if len(errors) != 0 || !program.Resolved {
+		t.Errorf("Resolve failed with known names. errors=%v", errors)
+	}
+}
+
+func TestResolvedBindings(t *testing.T) {
+	input := `let g = 1; let f = fn(a, b) { let c = a; fn(d) { a + c + d + g + len("") } };`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if errors := New().Resolve(program, nil); len(errors) != 0 {
+		t.Fatalf("resolver errors: %v", errors)
+	}
+
+	outer := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if strings.Join(outer.Locals, ",") != "a,b,c" {
+		t.Errorf("outer function has wrong locals. got=%v", outer.Locals)
+	}
+
+	ret := outer.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	sum := ret.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+
+	expected := []struct {
+		name        string
+		depth, slot int
+	}{
+		{"len", 2, -1},
+		{"g", 2, -1},
+		{"d", 0, 0},
+		{"c", 1, 2},
+		{"a", 1, 0},
+	}
+
+	var exp ast.Expression = sum
+	for _, want := range expected {
+		var ident *ast.Identifier
+		if infix, ok := exp.(*ast.InfixExpression); ok {
+			switch right := infix.Right.(type) {
+			case *ast.Identifier:
+				ident = right
+			case *ast.CallExpression:
+				ident = right.Function.(*ast.Identifier)
+			}
+			exp = infix.Left
+		} else {
+			ident = exp.(*ast.Identifier)
+		}
+		if ident.Value != want.name || !ident.Resolved || ident.Depth != want.depth || ident.Slot != want.slot {
+			t.Errorf("%s resolved wrong. want=(%d, %d), got=%s (%d, %d)",
+				want.name, want.depth, want.slot, ident.Value, ident.Depth, ident.Slot)
+		}
+	}
+}
+
+func TestSlotEnvironments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()`, 3},
+		{`let f = fn(x, x) { x }; f(1, 2)`, 2},
+		{`let f = fn(x) { let x = x + 1; x }; f(1)`, 2},
+		{`let counter = fn(n) { fn() { n } }; let a = counter(1); let b = counter(2); a() + b()`, 3},
+		{`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`, 610},
+		{`let f = fn() { let g = fn() { h() }; let h = fn() { 7 }; g() }; f()`, 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testErrorObject(t, testEval(`let f = fn() { let y = z; let z = 1; y }; f()`), "identifier not found: z")
+
+	// programs evaluated one after another in the same environment, like
+	// the lines of a REPL session
+	env := object.NewEnvironment()
+	for _, line := range []string{"let a = 5;", "let f = fn(x) { x * a };", "f(2)"} {
+		evaluated := Eval(parser.New(lexer.New(line)).ParseProgram(), env)
+		if line == "f(2)" {
+			testIntegerObject(t, evaluated, 10)
+		}
+	}
+}
+
+func BenchmarkRecursiveFunction(b *testing.B) {
+	program := parser.New(lexer.New(`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(20)`)).ParseProgram()
+	in := New()
+
+	for i := 0; i < b.N; i++ {
+		in.Eval(program, object.NewEnvironment())
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+		{`let f = fn() { quote(x) }; f()`, `x`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote(4 + 4) + 8)`, `(8 + 8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(true))`, `true`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let quotedInfix = quote(4 + 4); quote(unquote(4 + 4) + unquote(quotedInfix))`, `(8 + (4 + 4))`},
+		{`quote(unquote("monkey"))`, `monkey`},
+		{`quote(unquote([1, 2]))`, `[1, 2]`},
+		{`quote(unquote({"b": 2, "a": 1}))`, `{a:1, b:2}`},
+		{`let f = fn(x) { quote(unquote(x) * 2) }; f(1); f(3)`, `(3 * 2)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(tt.input), tt.expected)
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(1, 2)`, "wrong number of arguments. got=2, want=1"},
+		{`quote(unquote(fn(x) { x }))`, "cannot unquote FUNCTION"},
+		{`quote(unquote(missing))`, "identifier not found: missing"},
+		{`unquote(1)`, "identifier not found: unquote"},
+	}
+
+	for _, tt := range errorTests {
+		testErrorObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func testQuoteObject(t *testing.T, obj object.Object, expected string) bool {
+	quote, ok := obj.(*object.Quote)
+	if !ok {
+		t.Errorf("object is not Quote. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if quote.Node == nil {
+		t.Errorf("quote.Node is nil")
+		return false
+	}
+	if quote.Node.String() != expected {
+		t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), expected)
+		return false
+	}
+	return true
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment")
+	}
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+	if macro.Body.String() != "(x + y)" {
+		t.Fatalf("body is not %q. got=%q", "(x + y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let infixExpression = macro() { quote(1 + 2); }; infixExpression();`,
+			`(1 + 2)`,
+		},
+		{
+			`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); }; reverse(2 + 2, 10 - 5);`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`
+			let double = macro(x) { quote(unquote(x) * 2) };
+			let quadruple = macro(x) { quote(double(double(unquote(x)))) };
+			quadruple(a);
+			`,
+			`((a * 2) * 2)`,
+		},
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) }; twice(1); twice(2);`,
+			`(1 + 1); (2 + 2)`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Errorf("ExpandMacros(%q) returned error: %s", tt.input, err)
+			continue
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(x) { x }; m(1, 2)`, "macro m: wrong number of arguments. got=2, want=1"},
+		{`let m = macro() { 1 }; m()`, "macro m: macros must return quoted code, got INTEGER"},
+		{`let m = macro() { missing }; m()`, "macro m: identifier not found: missing"},
+		{`let m = macro() { quote(m()) }; m()`, "macro m: expansion exceeds a depth of 100"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("ExpandMacros(%q) wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	testErrorObject(t, testEval(`let f = fn() { macro(x) { x } }; f()`), "macros can only be defined by top-level let statements")
+}
+
+func TestMacrosEvaluate(t *testing.T) {
+	input := `
+	let unless = macro(condition, consequence, alternative) {
+		quote(if (!(unquote(condition))) { unquote(consequence) } else { unquote(alternative) });
+	};
+	let check = fn(n) { unless(n > 5, "small", "big") };
+	[check(1), check(10), unless(true, len(1), "lazy")]
+	`
+
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, err := ExpandMacros(program, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evaluated := Eval(expanded, object.NewEnvironment())
+	if evaluated.Inspect() != "[small, big, lazy]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"60 * 60 * 24", "86400"},
+		{"-(2 + 3) * 4", "-20"},
+		{`"con" + "cat" + "enation"`, "concatenation"},
+		{`"ab" * 3 == "ababab"`, "true"},
+		{"!(1 < 2) != false", "false"},
+		{"1 / 0", "(1 / 0)"},
+		{"x + 1 * 2", "(x + 2)"},
+		{"if (1 > 2) { a } else { b }", "b"},
+		{"if (true) { a }", "a"},
+		{"if (false) { a }", "if(false)"},
+		{"if (2 > 1) { let a = 1; a }", "if(true)let a = 1;a"},
+		{"if (x) { 1 + 1 } else { 2 * 2 }", "if(x)2else4"},
+		{"let day = 60 * 60 * 24; let week = day * 7; week", "let day = 86400;let week = 604800;604800"},
+		{"let a = 1; let a = 2; a", "let a = 1;let a = 2;a"},
+		{"let a = 1; if (x) { let a = 2 }; a", "let a = 1;if(x)let a = 2;a"},
+		{"a; let a = 1; a", "alet a = 1;1"},
+		{"let a = b; a", "let a = b;a"},
+		{"let a = 1; fn() { a }", "let a = 1;fn()a"},
+		{"fn() { let a = 1; fn() { a } }", "fn()let a = 1;fn()1"},
+		{"fn(a) { let b = 2; a + b }", "fn(a)let b = 2;(a + 2)"},
+		{"fn() { let a = 1; fn(a) { a } }", "fn()let a = 1;fn(a)a"},
+		{"quote(1 + 2)", "quote((1 + 2))"},
+		{"match (1 + 1) { 2 if 1 < 2 => 3 * 3, x => x }", "match(2){2 if true => 9, x => x}"},
+		{"let a = 1; match (x) { a => a }", "let a = 1;match(x){a => a}"},
+		{"let a = 1; let [a, b = 2 * 3] = x; a", "let a = 1;let [a, b = 6] = x;a"},
+		{"let a = 1; fn([a]) { a }", "let a = 1;fn([a])a"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		before := program.String()
+
+		optimized := Optimize(program)
+		if optimized.String() != tt.expected {
+			t.Errorf("Optimize(%q) wrong. want=%q, got=%q", tt.input, tt.expected, optimized.String())
+		}
+		if program.String() != before {
+			t.Errorf("Optimize(%q) modified its input. got=%q", tt.input, program.String())
+		}
+	}
+}
+
+func TestOptimizePreservesResults(t *testing.T) {
+	inputs := []string{
+		"let seconds = 60 * 60 * 24 * 7; seconds / 7",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"let f = fn(x) { if (true) { return x * 2; } 0 }; f(21)",
+		"let a = 5; let f = fn() { a }; let a = 6; f()",
+		"let f = fn() { a }; let a = 1; f()",
+		"if (false) { 1 }",
+		"1 / 0",
+		"let n = 0; let f = fn(x) { x / n }; f(1)",
+		`let greeting = "hello" + " " + "world"; len(greeting)`,
+		`"ab" * -1`,
+		"5 + true",
+		"let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } }; count(10000)",
+		"let f = fn() { let k = 3; let g = fn(x) { x * k }; g(5) }; f()",
+		"let a = 1; let f = fn(v) { match (v) { [a, ...b] => a + len(b), _ => a } }; f([5, 6]) + f(0)",
+		"let a = 1; let f = fn({a, b = a}) { a + b }; f({\"a\": 2})",
+	}
+
+	for _, input := range inputs {
+		program := testParseProgram(input)
+		expected := Eval(program, object.NewEnvironment())
+
+		program = testParseProgram(input)
+		if errors := defaultInterpreter().Resolve(program, nil); len(errors) != 0 {
+			t.Fatalf("Resolve(%q) failed: %v", input, errors)
+		}
+		got := Eval(Optimize(program), object.NewEnvironment())
+
+		if got.Inspect() != expected.Inspect() {
+			t.Errorf("optimized %q evaluates to %s, want %s", input, got.Inspect(), expected.Inspect())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"monkey/ast"
+	"monkey/object"
+)
+
+// The types of caught errors. Thrown hashes with a "type" string choose
+// their own.
+const (
+	runtimeErrorType = "RuntimeError" // raised by the interpreter or a builtin
+	thrownErrorType  = "Error"
+)
+
+// thrownError returns the error raised by throwing val. Its message is val
+// itself if it is a string, the "message" of a hash, as caught by a try
+// expression, and what val prints as otherwise.
+func thrownError(val object.Object) *object.Error {
+	message := val.Inspect()
+	if s, ok := hashString(val, "message"); ok {
+		message = s
+	}
+	return &object.Error{Message: message, Value: val}
+}
+
+// evalTryExpression evaluates the try block and, if it fails with an error
+// that is not fatal, the catch block with the error bound to its parameter.
+// The finally block is evaluated last, and its errors and return statements
+// take the place of the result.
+func (r *run) evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := r.eval(node.Block, env)
+
+	if errObj, ok := result.(*object.Error); ok && node.Catch != nil && !errObj.Fatal {
+		if errObj.Stack == nil {
+			errObj.Stack = r.stack()
+		}
+		setIdentifier(node.Param, caughtError(errObj), env)
+		result = r.eval(node.Catch, env)
+	}
+
+	if node.Finally != nil {
+		final := r.eval(node.Finally, env)
+		if final != nil && (final.Type() == object.ERROR_OBJ || final.Type() == object.RETURN_VALUE_OBJ) {
+			return final
+		}
+	}
+	return result
+}
+
+// caughtError returns the hash a catch block receives for err, with its
+// "message", "type", "stack" and the "value" thrown, or null.
+func caughtError(err *object.Error) *object.Hash {
+	typ := runtimeErrorType
+	var value object.Object = NULL
+	if err.Value != nil {
+		typ, value = thrownErrorType, err.Value
+		if s, ok := hashString(err.Value, "type"); ok {
+			typ = s
+		}
+	}
+
+	stack := &object.Array{Elements: make([]object.Object, len(err.Stack))}
+	for i, frame := range err.Stack {
+		stack.Elements[i] = &object.String{Value: frame}
+	}
+
+	hash := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for _, field := range []struct {
+		key   string
+		value object.Object
+	}{
+		{"message", &object.String{Value: err.Message}},
+		{"type", &object.String{Value: typ}},
+		{"stack", stack},
+		{"value", value},
+	} {
+		key := &object.String{Value: field.key}
+		hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: field.value}
+	}
+	return hash
+}
+
+// hashString returns the string under key if obj is a hash holding one.
+func hashString(obj object.Object, key string) (string, bool) {
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		return "", false
+	}
+	pair, ok := hash.Pairs[(&object.String{Value: key}).HashKey()]
+	if !ok {
+		return "", false
+	}
+	s, ok := pair.Value.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return s.Value, true
+}
+
+// stack describes the functions being called, innermost first, by how they
+// were called and where. A call in tail position replaces its caller.
+func (r *run) stack() []string {
+	frames := make([]string, 0, len(r.calls))
+	for i := len(r.calls) - 1; i >= 0; i-- {
+		call := r.calls[i]
+		if call == nil {
+			frames = append(frames, "fn")
+			continue
+		}
+		name := "fn"
+		switch function := call.Function.(type) {
+		case *ast.Identifier, *ast.SelectorExpression:
+			name = function.String()
+		}
+		frames = append(frames, fmt.Sprintf("%s at %s", name, call.Pos()))
+	}
+	return frames
+}
+
+// setIdentifier binds ident, which has been declared in env, to val.
+func setIdentifier(ident *ast.Identifier, val object.Object, env *object.Environment) {
+	if ident.Resolved && ident.Slot >= 0 {
+		env.SetSlot(ident.Slot, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+}
@@ -0,0 +1,173 @@
+package evaluator
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"monkey/object"
+)
+
+// FileAccess is the capability configuration for the file system builtins.
+// Scripts may only touch paths inside one of Roots; relative paths are
+// resolved against the first root. With no roots, file I/O is disabled.
+type FileAccess struct {
+	Roots    []string
+	ReadOnly bool
+}
+
+// resolve turns a script supplied path into an absolute path and checks that
+// it stays inside one of the allowed roots, following symbolic links so they
+// cannot be used to escape the sandbox.
+func (fa FileAccess) resolve(name string, write bool) (string, *object.Error) {
+	if len(fa.Roots) == 0 {
+		return "", newError("file system access is disabled")
+	}
+	if write && fa.ReadOnly {
+		return "", newError("file system access is read-only")
+	}
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(fa.Roots[0], path)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", newError("invalid path %q: %s", name, err)
+	}
+	real, err := evalSymlinks(path)
+	if err != nil {
+		return "", newError("invalid path %q: %s", name, err)
+	}
+
+	for _, root := range fa.Roots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if realRoot, err := filepath.EvalSymlinks(root); err == nil {
+			root = realRoot
+		}
+		if isWithin(root, real) {
+			return real, nil
+		}
+	}
+	return "", newError("access to %q is not allowed", name)
+}
+
+// evalSymlinks resolves symbolic links in path. Trailing elements that do not
+// exist yet, such as a file about to be written, are kept as they are, but
+// dangling links are followed to the file that writing through them creates.
+func evalSymlinks(path string) (string, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return real, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	dir, file := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if dir == path {
+		return path, nil
+	}
+	realDir, err := evalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	real = filepath.Join(realDir, file)
+	if info, err := os.Lstat(real); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(real)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(realDir, target)
+		}
+		return evalSymlinks(target)
+	}
+	return real, nil
+}
+
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (fa FileAccess) readFile(name string) object.Object {
+	path, errObj := fa.resolve(name, false)
+	if errObj != nil {
+		return errObj
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return newError("could not read %q: %s", name, unwrapPathError(err))
+	}
+	return &object.String{Value: string(data)}
+}
+
+func (fa FileAccess) writeFile(name, content string, flag int) object.Object {
+	path, errObj := fa.resolve(name, true)
+	if errObj != nil {
+		return errObj
+	}
+	f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return newError("could not write %q: %s", name, unwrapPathError(err))
+	}
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return newError("could not write %q: %s", name, unwrapPathError(err))
+	}
+	return NULL
+}
+
+func (fa FileAccess) listDir(name string) object.Object {
+	path, errObj := fa.resolve(name, false)
+	if errObj != nil {
+		return errObj
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return newError("could not list %q: %s", name, unwrapPathError(err))
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	sort.Strings(names)
+
+	elements := make([]object.Object, len(names))
+	for i, n := range names {
+		elements[i] = &object.String{Value: n}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func (fa FileAccess) fileExists(name string) object.Object {
+	path, errObj := fa.resolve(name, false)
+	if errObj != nil {
+		return errObj
+	}
+	_, err := os.Stat(path)
+	return nativeBoolToBooleanObject(err == nil)
+}
+
+// unwrapPathError drops the operation and path from err, since the error
+// messages built from it already name the path the script asked for.
+func unwrapPathError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package evaluator
+
+import (
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Interpreter evaluates Monkey programs with its own set of builtins, output
+// writer, file access and limits. An Interpreter is not modified by Eval, so
+// it can be shared by goroutines evaluating different programs. Eval does
+// modify programs that have not been resolved, so a program evaluated by
+// several goroutines at once must be resolved with Resolve beforehand.
+type Interpreter struct {
+	builtins map[string]*object.Builtin
+	out      io.Writer
+	files    FileAccess
+	limits   Limits
+	tracer   Tracer
+
+	modulePaths []string
+	modules     *moduleCache
+}
+
+// Limits bound the resources a single call to Interpreter.Eval may use. A
+// zero value means no limit.
+type Limits struct {
+	MaxCallDepth int // nested function calls
+	MaxSteps     int // evaluated AST nodes
+}
+
+type Option func(*Interpreter)
+
+// WithBuiltins adds the given builtins, replacing any with the same name.
+func WithBuiltins(builtins map[string]*object.Builtin) Option {
+	return func(in *Interpreter) {
+		maps.Copy(in.builtins, builtins)
+	}
+}
+
+// WithoutBuiltins removes the named builtins.
+func WithoutBuiltins(names ...string) Option {
+	return func(in *Interpreter) {
+		for _, name := range names {
+			delete(in.builtins, name)
+		}
+	}
+}
+
+// WithOutput sets where `puts` writes to. It defaults to os.Stdout.
+func WithOutput(out io.Writer) Option {
+	return func(in *Interpreter) {
+		in.out = out
+	}
+}
+
+// WithFileAccess enables the file system builtins. Without it they fail.
+func WithFileAccess(access FileAccess) Option {
+	return func(in *Interpreter) {
+		in.files = access
+	}
+}
+
+func WithLimits(limits Limits) Option {
+	return func(in *Interpreter) {
+		in.limits = limits
+	}
+}
+
+// Tracer is notified by an Interpreter as it evaluates, for debuggers and
+// profilers. Its methods are called on the goroutine calling Eval.
+type Tracer interface {
+	// Statement is called before each statement is evaluated in env. If it
+	// returns an error, evaluation stops with that error.
+	Statement(stmt ast.Statement, env *object.Environment) error
+
+	// Call is called when fn is called by call, or by Interpreter.Call if
+	// call is nil, with env holding the arguments.
+	Call(fn *object.Function, call *ast.CallExpression, env *object.Environment)
+
+	// Return is called when fn returns result, or with a nil result when it
+	// makes a tail call, which replaces it on the call stack.
+	Return(fn *object.Function, result object.Object)
+}
+
+// BranchTracer is a Tracer that is also notified of the branches that if
+// expressions take, for coverage tools.
+type BranchTracer interface {
+	Tracer
+
+	// Branch is called when the condition of node has been evaluated,
+	// with whether it was truthy. The alternative is taken when it was not,
+	// even if node has none.
+	Branch(node *ast.IfExpression, consequence bool)
+}
+
+// WithTracer makes the interpreter notify t as it evaluates.
+func WithTracer(t Tracer) Option {
+	return func(in *Interpreter) {
+		in.tracer = t
+	}
+}
+
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{
+		builtins: maps.Clone(builtins),
+		out:      os.Stdout,
+		modules:  &moduleCache{modules: map[string]*object.Module{}},
+	}
+	maps.Copy(in.builtins, in.boundBuiltins())
+
+	for _, opt := range opts {
+		opt(in)
+	}
+
+	return in
+}
+
+// Eval evaluates node in env. Programs that have not been resolved yet are
+// resolved first, which annotates their AST, and not evaluated if they refer
+// to undefined identifiers.
+func (in *Interpreter) Eval(node ast.Node, env *object.Environment) object.Object {
+	if program, ok := node.(*ast.Program); ok && !program.Resolved {
+		if errors := in.Resolve(program, env); len(errors) != 0 {
+			return newError("%s", errors[0])
+		}
+	}
+
+	r := &run{Interpreter: in}
+	return r.eval(node, env)
+}
+
+// Call applies fn, which must be a Monkey function or a builtin, to args.
+func (in *Interpreter) Call(fn object.Object, args ...object.Object) object.Object {
+	r := &run{Interpreter: in}
+	return r.applyFunction(fn, args, nil)
+}
+
+// Defines reports whether name refers to a builtin in programs evaluated by
+// the interpreter.
+func (in *Interpreter) Defines(name string) bool {
+	_, ok := in.builtins[name]
+	return ok
+}
+
+// Names returns the sorted names of the builtins.
+func (in *Interpreter) Names() []string {
+	names := make([]string, 0, len(in.builtins))
+	for name := range in.builtins {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Builtin returns the builtin called name, if the interpreter has one.
+func (in *Interpreter) Builtin(name string) (*object.Builtin, bool) {
+	builtin, ok := in.builtins[name]
+	return builtin, ok
+}
+
+var defaultInterpreter = sync.OnceValue(func() *Interpreter { return New() })
+
+// Eval evaluates node in env using an Interpreter with the default options.
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return defaultInterpreter().Eval(node, env)
+}
+
+// run holds the state of a single call to Interpreter.Eval.
+type run struct {
+	*Interpreter
+	depth int
+	steps int
+	calls []*ast.CallExpression // of the functions being called, nil if made by Interpreter.Call
+
+	untraced bool // loading a module
+
+	dir       string        // of the module being loaded, if any
+	file      string        // of the module whose code is evaluated, empty for the program
+	importing []importFrame // the modules being loaded, innermost last
+}
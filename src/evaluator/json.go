@@ -0,0 +1,133 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"monkey/object"
+)
+
+// jsonParse decodes a JSON document into Monkey objects. Objects become
+// hashes with string keys and numbers must be integers, since Monkey has no
+// floating point type.
+func jsonParse(input string) object.Object {
+	dec := json.NewDecoder(strings.NewReader(input))
+	dec.UseNumber()
+
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return newError("invalid JSON: %s", err)
+	}
+	if dec.More() {
+		return newError("invalid JSON: unexpected data after top-level value")
+	}
+
+	return jsonToObject(value)
+}
+
+func jsonToObject(value any) object.Object {
+	switch value := value.(type) {
+	case nil:
+		return NULL
+	case bool:
+		return nativeBoolToBooleanObject(value)
+	case string:
+		return &object.String{Value: value}
+	case json.Number:
+		n, err := value.Int64()
+		if err != nil {
+			return newError("invalid JSON: number %s is not an integer", value)
+		}
+		return &object.Integer{Value: n}
+	case []any:
+		elements := make([]object.Object, len(value))
+		for i, el := range value {
+			obj := jsonToObject(el)
+			if isError(obj) {
+				return obj
+			}
+			elements[i] = obj
+		}
+		return &object.Array{Elements: elements}
+	case map[string]any:
+		pairs := make(map[object.HashKey]object.HashPair, len(value))
+		for k, v := range value {
+			obj := jsonToObject(v)
+			if isError(obj) {
+				return obj
+			}
+			key := &object.String{Value: k}
+			pairs[key.HashKey()] = object.HashPair{Key: key, Value: obj}
+		}
+		return &object.Hash{Pairs: pairs}
+	default:
+		return newError("invalid JSON: unexpected value %v", value)
+	}
+}
+
+// jsonStringify encodes obj as JSON. Hash keys are written in sorted order so
+// the output is deterministic; a positive indent pretty-prints the result
+// with that many spaces per level.
+func jsonStringify(obj object.Object, indent int64) object.Object {
+	value, errObj := objectToJSON(obj)
+	if errObj != nil {
+		return errObj
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if indent > 0 {
+		spaces := repeatString(" ", indent)
+		if isError(spaces) {
+			return spaces
+		}
+		enc.SetIndent("", spaces.(*object.String).Value)
+	}
+	if err := enc.Encode(value); err != nil {
+		return newError("could not encode JSON: %s", err)
+	}
+
+	return &object.String{Value: strings.TrimSuffix(out.String(), "\n")}
+}
+
+func objectToJSON(obj object.Object) (any, *object.Error) {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Array:
+		values := make([]any, len(obj.Elements))
+		for i, el := range obj.Elements {
+			value, err := objectToJSON(el)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case *object.Hash:
+		// encoding/json writes map keys in sorted order
+		values := make(map[string]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return nil, newError("cannot encode hash key of type %s as JSON", pair.Key.Type())
+			}
+			value, err := objectToJSON(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			values[key.Value] = value
+		}
+		return values, nil
+	default:
+		return nil, newError("cannot encode %s as JSON", obj.Type())
+	}
+}
@@ -0,0 +1,133 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+
+	"monkey/ast"
+	"monkey/object"
+)
+
+// maxExpansionDepth bounds how often the code a macro expands to may
+// itself be expanded, so a macro that expands to a call of itself fails
+// instead of looping forever.
+const maxExpansionDepth = 100
+
+// DefineMacros binds the macros defined by top-level let statements of
+// program in env and removes those statements from the program.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	statements := []ast.Statement{}
+
+	for _, stmt := range program.Statements {
+		letStatement, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			statements = append(statements, stmt)
+			continue
+		}
+		macroLiteral, ok := letStatement.Value.(*ast.MacroLiteral)
+		if !ok || letStatement.Name == nil {
+			statements = append(statements, stmt)
+			continue
+		}
+
+		env.Set(letStatement.Name.Value, &object.Macro{
+			Parameters: macroLiteral.Params,
+			Body:       macroLiteral.Body,
+			Env:        env,
+		})
+	}
+
+	program.Statements = statements
+}
+
+// ExpandMacros returns a copy of program in which every call of a macro
+// bound in env has been replaced by the code the macro returns. Macros are
+// called with their arguments quoted, and must return quoted code.
+func (in *Interpreter) ExpandMacros(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	x := &expander{run: &run{Interpreter: in}, env: env}
+	expanded := x.expand(program)
+	if x.err != nil {
+		return nil, x.err
+	}
+	return expanded.(*ast.Program), nil
+}
+
+// ExpandMacros expands macros using an Interpreter with the default options.
+func ExpandMacros(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	return defaultInterpreter().ExpandMacros(program, env)
+}
+
+type expander struct {
+	run   *run
+	env   *object.Environment
+	depth int
+	err   error
+}
+
+func (x *expander) expand(node ast.Node) ast.Node {
+	return ast.Modify(node, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || x.err != nil {
+			return node
+		}
+		macro, ok := x.macro(call)
+		if !ok {
+			return node
+		}
+
+		quote, err := x.call(macro, call.Arguments)
+		if err != nil {
+			x.err = fmt.Errorf("macro %s: %w", call.Function, err)
+			return node
+		}
+		if _, ok := quote.Node.(ast.Expression); !ok {
+			x.err = fmt.Errorf("macro %s: expanded to a statement instead of an expression", call.Function)
+			return node
+		}
+
+		x.depth++
+		defer func() { x.depth-- }()
+		if x.depth > maxExpansionDepth {
+			x.err = fmt.Errorf("macro %s: expansion exceeds a depth of %d", call.Function, maxExpansionDepth)
+			return node
+		}
+		return x.expand(quote.Node)
+	})
+}
+
+func (x *expander) macro(call *ast.CallExpression) (*object.Macro, bool) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := x.env.Get(ident.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func (x *expander) call(macro *object.Macro, args []ast.Expression) (*object.Quote, error) {
+	if len(args) != len(macro.Parameters) {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), len(macro.Parameters))
+	}
+
+	env := object.NewEnclosedEnvironment(macro.Env)
+	for i, param := range macro.Parameters {
+		env.Set(param.Value, &object.Quote{Node: args[i]})
+	}
+
+	evaluated := unwrapReturnValue(x.run.eval(macro.Body, env))
+	if evaluated == nil {
+		evaluated = NULL
+	}
+	if errObj, ok := evaluated.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		return nil, fmt.Errorf("macros must return quoted code, got %s", evaluated.Type())
+	}
+	return quote, nil
+}
@@ -0,0 +1,228 @@
+package evaluator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+// WithModulePaths adds directories to search for the modules named by import
+// statements. A module is first looked for relative to the directory of the
+// module importing it, then in each of paths in order, and must lie inside
+// one of them. Without any module paths, import statements fail.
+func WithModulePaths(paths ...string) Option {
+	return func(in *Interpreter) {
+		in.modulePaths = append(in.modulePaths, paths...)
+	}
+}
+
+// moduleCache holds the modules an interpreter has loaded by the real path
+// of their file, so that each is evaluated only once.
+type moduleCache struct {
+	mu      sync.Mutex
+	modules map[string]*object.Module
+}
+
+func (c *moduleCache) get(path string) (*object.Module, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.modules[path]
+	return m, ok
+}
+
+// add caches m, unless a module was cached for path while m was loading, in
+// which case that one is returned instead.
+func (c *moduleCache) add(path string, m *object.Module) *object.Module {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.modules[path]; ok {
+		return cached
+	}
+	c.modules[path] = m
+	return m
+}
+
+// importFrame is a module that is being loaded, under the name it was
+// imported as.
+type importFrame struct {
+	name string
+	path string
+}
+
+func (r *run) evalImportStatement(node *ast.ImportStatement, env *object.Environment) object.Object {
+	loaded := r.importModule(node.Path.Value)
+	if isError(loaded) {
+		return loaded
+	}
+	module := loaded.(*object.Module)
+
+	if node.Alias != nil {
+		env.Set(node.Alias.Value, module)
+		return nil
+	}
+	for _, name := range node.Names {
+		val, ok := module.Exports[name.Name.Value]
+		if !ok {
+			return newError("module %q does not export %s", module.Name, name.Name.Value)
+		}
+		env.Set(name.Bound().Value, val)
+	}
+	return nil
+}
+
+// importModule returns the module called name, loading it unless it has
+// been loaded before.
+func (r *run) importModule(name string) object.Object {
+	path, errObj := r.findModule(name)
+	if errObj != nil {
+		return errObj
+	}
+
+	for i, frame := range r.importing {
+		if frame.path == path {
+			names := []string{}
+			for _, frame := range r.importing[i:] {
+				names = append(names, frame.name)
+			}
+			names = append(names, name)
+			return newError("import cycle: %s", strings.Join(names, " -> "))
+		}
+	}
+
+	if module, ok := r.modules.get(path); ok {
+		return module
+	}
+	loaded := r.loadModule(name, path)
+	if isError(loaded) {
+		return loaded
+	}
+	return r.modules.add(path, loaded.(*object.Module))
+}
+
+// findModule returns the real path of the module called name.
+func (r *run) findModule(name string) (string, *object.Error) {
+	if len(r.modulePaths) == 0 {
+		return "", newError("imports are disabled: there are no module paths")
+	}
+
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = candidates[:0]
+		if r.dir != "" {
+			candidates = append(candidates, filepath.Join(r.dir, name))
+		}
+		for _, dir := range r.modulePaths {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+
+	outside := false
+	for _, candidate := range candidates {
+		path, err := filepath.Abs(candidate)
+		if err != nil {
+			continue
+		}
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(real); err != nil || info.IsDir() {
+			continue
+		}
+		if r.inModulePaths(real) {
+			return real, nil
+		}
+		outside = true
+	}
+	if outside {
+		return "", newError("module %q is outside the module paths", name)
+	}
+	return "", newError("module %q not found", name)
+}
+
+func (r *run) inModulePaths(path string) bool {
+	for _, dir := range r.modulePaths {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if realDir, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = realDir
+		}
+		if isWithin(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadModule parses and evaluates the module at path in an environment of
+// its own. Errors are prefixed with name, the path it is imported as.
+func (r *run) loadModule(name, path string) object.Object {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return newError("could not read module %q: %s", name, unwrapPathError(err))
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return newError("%s:%s: %s", name, p.ErrorPositions()[0], p.Errors()[0])
+	}
+
+	macros := object.NewEnvironment()
+	DefineMacros(program, macros)
+	program, err = r.ExpandMacros(program, macros)
+	if err != nil {
+		return newError("%s: %s", name, err)
+	}
+
+	env := object.NewEnvironment()
+	if errors := r.Resolve(program, env); len(errors) != 0 {
+		return newError("%s: %s", name, errors[0])
+	}
+	// tracers are given the nodes of the programs they were set up for, which
+	// the optimizer would replace
+	if r.tracer == nil {
+		program = Optimize(program)
+	}
+
+	dir, file, untraced := r.dir, r.file, r.untraced
+	r.dir, r.file, r.untraced = filepath.Dir(path), path, true
+	r.importing = append(r.importing, importFrame{name: name, path: path})
+	result := r.eval(program, env)
+	r.importing = r.importing[:len(r.importing)-1]
+	r.dir, r.file, r.untraced = dir, file, untraced
+
+	if errObj, ok := result.(*object.Error); ok {
+		return newError("%s: %s", name, errObj.Message)
+	}
+
+	module := &object.Module{Name: name, Exports: map[string]object.Object{}}
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok && let.Exported {
+			for _, ident := range let.Bound() {
+				module.Exports[ident.Value], _ = env.Get(ident.Value)
+			}
+		}
+	}
+	return module
+}
+
+func evalSelectorExpression(left object.Object, name string) object.Object {
+	module, ok := left.(*object.Module)
+	if !ok {
+		return newError("selector not supported: %s", left.Type())
+	}
+	val, ok := module.Exports[name]
+	if !ok {
+		return newError("module %q does not export %s", module.Name, name)
+	}
+	return val
+}
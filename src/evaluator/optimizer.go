@@ -0,0 +1,403 @@
+package evaluator
+
+import (
+	"strconv"
+
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+// maxFoldedString bounds the strings that string repetition is folded into,
+// so that optimizing a program does not build strings that evaluating it
+// might never need.
+const maxFoldedString = 1 << 12
+
+// Optimize returns a copy of program in which expressions of integer, string
+// and boolean literals are replaced by their value, if expressions with a
+// literal condition by the branch that is taken, and identifiers bound by
+// let statements to literals by the literal. program itself is not modified.
+//
+// Evaluating the optimized program has the same result as evaluating
+// program: expressions that fail, like 1 / 0, are left to fail at run time.
+// Only names bound once in their scope are inlined, and only after their let
+// statement. Top-level names are not inlined into functions, which may be
+// called after a REPL or an embedder rebinds them.
+//
+// Optimize keeps the annotations of the resolver valid, so it can be called
+// before or after Resolve; calling it after keeps errors about undefined
+// identifiers in branches that it removes.
+func Optimize(program *ast.Program) *ast.Program {
+	o := &optimizer{}
+	o.scopes = append(o.scopes, newConstScope(nil, nil, program))
+
+	optimized := *program
+	optimized.Statements = o.statements(program.Statements, true)
+	return &optimized
+}
+
+type optimizer struct {
+	scopes []*constScope
+}
+
+// constScope tracks the literals that names of a function, or of the
+// program, are bound to.
+type constScope struct {
+	declared map[string]int            // bindings per name, by parameters and lets
+	consts   map[string]ast.Expression // names whose only let has been passed
+	function bool
+}
+
+func newConstScope(params []*ast.Identifier, patterns []ast.Pattern, body ast.Node) *constScope {
+	s := &constScope{
+		declared: map[string]int{},
+		consts:   map[string]ast.Expression{},
+	}
+	for _, param := range params {
+		s.declared[param.Value]++
+	}
+	for _, pattern := range patterns {
+		for _, ident := range ast.PatternBindings(pattern) {
+			s.declared[ident.Value]++
+		}
+	}
+	for _, name := range declaredNames(body) {
+		s.declared[name]++
+	}
+	return s
+}
+
+// statements optimizes stmts. Let statements directly in a scope, and not in
+// a block of an if expression, can bind names to constants.
+func (o *optimizer) statements(stmts []ast.Statement, scopeLevel bool) []ast.Statement {
+	optimized := make([]ast.Statement, len(stmts))
+	for i, stmt := range stmts {
+		optimized[i] = o.statement(stmt, scopeLevel)
+	}
+	return optimized
+}
+
+// pattern optimizes the default values of pattern, which are evaluated in
+// the current scope.
+func (o *optimizer) pattern(pattern ast.Pattern) ast.Pattern {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		copied := *pattern
+		copied.Elements = make([]ast.Pattern, len(pattern.Elements))
+		for i, elem := range pattern.Elements {
+			copied.Elements[i] = o.pattern(elem)
+		}
+		return &copied
+
+	case *ast.HashPattern:
+		copied := *pattern
+		copied.Pairs = make([]ast.HashPatternPair, len(pattern.Pairs))
+		for i, pair := range pattern.Pairs {
+			copied.Pairs[i] = ast.HashPatternPair{Key: pair.Key, Value: o.pattern(pair.Value)}
+		}
+		return &copied
+
+	case *ast.TypePattern:
+		copied := *pattern
+		copied.Pattern = o.pattern(pattern.Pattern)
+		return &copied
+
+	case *ast.DefaultPattern:
+		copied := *pattern
+		copied.Pattern = o.pattern(pattern.Pattern)
+		copied.Default = o.expression(pattern.Default)
+		return &copied
+	}
+	return pattern
+}
+
+func (o *optimizer) statement(stmt ast.Statement, scopeLevel bool) ast.Statement {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		let := *stmt
+		let.Value = o.expression(stmt.Value)
+		if stmt.Pattern != nil {
+			let.Pattern = o.pattern(stmt.Pattern)
+		}
+		scope := o.scopes[len(o.scopes)-1]
+		if scopeLevel && let.Name != nil && isLiteral(let.Value) && scope.declared[let.Name.Value] == 1 {
+			scope.consts[let.Name.Value] = let.Value
+		}
+		return &let
+
+	case *ast.ReturnStatement:
+		ret := *stmt
+		ret.ReturnValue = o.expression(stmt.ReturnValue)
+		return &ret
+
+	case *ast.ThrowStatement:
+		throw := *stmt
+		throw.Value = o.expression(stmt.Value)
+		return &throw
+
+	case *ast.ExpressionStatement:
+		es := *stmt
+		es.Expression = o.expression(stmt.Expression)
+		return &es
+	}
+	return stmt
+}
+
+func (o *optimizer) block(block *ast.BlockStatement) *ast.BlockStatement {
+	optimized := *block
+	optimized.Statements = o.statements(block.Statements, false)
+	return &optimized
+}
+
+func (o *optimizer) expressions(exps []ast.Expression) []ast.Expression {
+	optimized := make([]ast.Expression, len(exps))
+	for i, exp := range exps {
+		optimized[i] = o.expression(exp)
+	}
+	return optimized
+}
+
+func (o *optimizer) expression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return o.identifier(exp)
+
+	case *ast.PrefixExpression:
+		prefix := *exp
+		prefix.Right = o.expression(exp.Right)
+		return foldPrefix(&prefix)
+
+	case *ast.InfixExpression:
+		infix := *exp
+		infix.Left = o.expression(exp.Left)
+		infix.Right = o.expression(exp.Right)
+		return foldInfix(&infix)
+
+	case *ast.IfExpression:
+		return o.ifExpression(exp)
+
+	case *ast.TryExpression:
+		try := *exp
+		try.Block = o.block(exp.Block)
+		if exp.Catch != nil {
+			try.Catch = o.block(exp.Catch)
+		}
+		if exp.Finally != nil {
+			try.Finally = o.block(exp.Finally)
+		}
+		return &try
+
+	case *ast.MatchExpression:
+		match := *exp
+		match.Value = o.expression(exp.Value)
+		match.Arms = make([]*ast.MatchArm, len(exp.Arms))
+		for i, arm := range exp.Arms {
+			optimized := *arm
+			if arm.Guard != nil {
+				optimized.Guard = o.expression(arm.Guard)
+			}
+			optimized.Body = o.expression(arm.Body)
+			match.Arms[i] = &optimized
+		}
+		return &match
+
+	case *ast.FunctionLiteral:
+		s := newConstScope(exp.Params, exp.Patterns, exp.Body)
+		s.function = true
+		o.scopes = append(o.scopes, s)
+		defer func() { o.scopes = o.scopes[:len(o.scopes)-1] }()
+
+		fn := *exp
+		if exp.Patterns != nil {
+			fn.Patterns = make([]ast.Pattern, len(exp.Patterns))
+			for i, pattern := range exp.Patterns {
+				fn.Patterns[i] = o.pattern(pattern)
+			}
+		}
+		body := *exp.Body
+		body.Statements = o.statements(exp.Body.Statements, true)
+		fn.Body = &body
+		return &fn
+
+	case *ast.CallExpression:
+		// quoted code is data, and is returned as written
+		if isQuoteCall(exp) {
+			return exp
+		}
+		call := *exp
+		call.Function = o.expression(exp.Function)
+		call.Arguments = o.expressions(exp.Arguments)
+		return &call
+
+	case *ast.IndexExpression:
+		index := *exp
+		index.Left = o.expression(exp.Left)
+		index.Index = o.expression(exp.Index)
+		return &index
+
+	case *ast.SelectorExpression:
+		selector := *exp
+		selector.Left = o.expression(exp.Left)
+		return &selector
+
+	case *ast.ArrayLiteral:
+		array := *exp
+		array.Elements = o.expressions(exp.Elements)
+		return &array
+
+	case *ast.HashLiteral:
+		hash := *exp
+		hash.Pairs = make([]ast.HashPair, len(exp.Pairs))
+		for i, pair := range exp.Pairs {
+			hash.Pairs[i] = ast.HashPair{Key: o.expression(pair.Key), Value: o.expression(pair.Value)}
+		}
+		return &hash
+	}
+	return exp
+}
+
+// identifier returns the literal ident is bound to, if it is known, and
+// ident otherwise.
+func (o *optimizer) identifier(ident *ast.Identifier) ast.Expression {
+	for i := len(o.scopes) - 1; i >= 0; i-- {
+		s := o.scopes[i]
+		if s.declared[ident.Value] == 0 {
+			continue
+		}
+		value, ok := s.consts[ident.Value]
+		if !ok || (!s.function && i < len(o.scopes)-1) {
+			return ident
+		}
+		return withPos(value, ident.Pos())
+	}
+	return ident
+}
+
+// ifExpression optimizes an if expression and, if its condition is a
+// literal, drops the branch that is not taken. A branch holding a single
+// expression replaces the if expression.
+func (o *optimizer) ifExpression(exp *ast.IfExpression) ast.Expression {
+	cond := o.expression(exp.Condition)
+	if !isLiteral(cond) {
+		optimized := *exp
+		optimized.Condition = cond
+		optimized.Consequence = o.block(exp.Consequence)
+		if exp.Alternative != nil {
+			optimized.Alternative = o.block(exp.Alternative)
+		}
+		return &optimized
+	}
+
+	taken := exp.Alternative
+	if isTruthy(literalObject(cond)) {
+		taken = exp.Consequence
+	}
+	if taken == nil {
+		// if (false) {} still evaluates to null
+		taken = &ast.BlockStatement{Token: exp.Consequence.Token, Rbrace: exp.Consequence.Rbrace}
+		return &ast.IfExpression{
+			Token:       exp.Token,
+			Condition:   literalNode(FALSE, cond.Pos()),
+			Consequence: taken,
+		}
+	}
+
+	block := o.block(taken)
+	if len(block.Statements) == 1 {
+		if es, ok := block.Statements[0].(*ast.ExpressionStatement); ok {
+			return es.Expression
+		}
+	}
+	return &ast.IfExpression{
+		Token:       exp.Token,
+		Condition:   literalNode(TRUE, cond.Pos()),
+		Consequence: block,
+	}
+}
+
+func foldPrefix(exp *ast.PrefixExpression) ast.Expression {
+	if !isLiteral(exp.Right) {
+		return exp
+	}
+	result := evalPrefixExpression(exp.Operator, literalObject(exp.Right))
+	if isError(result) {
+		return exp
+	}
+	return literalNode(result, exp.Pos())
+}
+
+func foldInfix(exp *ast.InfixExpression) ast.Expression {
+	if !isLiteral(exp.Left) || !isLiteral(exp.Right) {
+		return exp
+	}
+	left, right := literalObject(exp.Left), literalObject(exp.Right)
+	if exp.Operator == "*" && !fitsFoldedString(left, right) {
+		return exp
+	}
+	result := evalInfixExpression(exp.Operator, left, right)
+	if isError(result) {
+		return exp
+	}
+	return literalNode(result, exp.Pos())
+}
+
+// fitsFoldedString reports whether left * right is not a string repetition
+// longer than maxFoldedString.
+func fitsFoldedString(left, right object.Object) bool {
+	if _, ok := right.(*object.String); ok {
+		left, right = right, left
+	}
+	str, ok := left.(*object.String)
+	if !ok {
+		return true
+	}
+	count, ok := right.(*object.Integer)
+	if !ok || count.Value <= 0 || len(str.Value) == 0 {
+		return true
+	}
+	return count.Value <= int64(maxFoldedString/len(str.Value))
+}
+
+func isLiteral(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	}
+	return false
+}
+
+// literalObject returns the value of a literal, as isLiteral reports it.
+func literalObject(exp ast.Expression) object.Object {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: exp.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(exp.Value)
+	}
+	return nil
+}
+
+// literalNode returns the literal for an integer, string or boolean, at pos.
+func literalNode(obj object.Object, pos token.Position) ast.Expression {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		literal := strconv.FormatInt(obj.Value, 10)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal, Pos: pos}, Value: obj.Value}
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: obj.Value, Pos: pos}, Value: obj.Value}
+	case *object.Boolean:
+		t := token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}
+	}
+	return nil
+}
+
+// withPos returns a copy of the literal exp at pos.
+func withPos(exp ast.Expression, pos token.Position) ast.Expression {
+	return literalNode(literalObject(exp), pos)
+}
@@ -0,0 +1,259 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// valueTypes are the types a type pattern can name: those of the values a
+// program can hold.
+var valueTypes = map[string]bool{
+	object.INTEGER_OBJ:  true,
+	object.BOOLEAN_OBJ:  true,
+	object.NULL_OBJ:     true,
+	object.STRING_OBJ:   true,
+	object.ARRAY_OBJ:    true,
+	object.HASH_OBJ:     true,
+	object.FUNCTION_OBJ: true,
+	object.BUILTIN_OBJ:  true,
+	object.QUOTE_OBJ:    true,
+	object.MACRO_OBJ:    true,
+	object.MODULE_OBJ:   true,
+}
+
+// evalMatchExpression evaluates the body of the first arm whose pattern
+// matches the value and whose guard, if any, is truthy, with the pattern's
+// identifiers bound. The body is in tail position if tail is true.
+func (r *run) evalMatchExpression(node *ast.MatchExpression, env *object.Environment, tail bool) object.Object {
+	val := r.eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	for _, arm := range node.Arms {
+		var bindings []binding
+		if !matchPattern(arm.Pattern, val, &bindings) {
+			continue
+		}
+		for _, b := range bindings {
+			setIdentifier(b.ident, b.val, env)
+		}
+
+		if arm.Guard != nil {
+			guard := r.eval(arm.Guard, env)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return r.evalTail(arm.Body, env, tail)
+	}
+
+	return newError("no arm matches %s %s", val.Type(), val.Inspect())
+}
+
+// binding is an identifier of a pattern and the value it matched.
+type binding struct {
+	ident *ast.Identifier
+	val   object.Object
+}
+
+// matchPattern reports whether val matches pattern, appending what its
+// identifiers match to bindings.
+func matchPattern(pattern ast.Pattern, val object.Object, bindings *[]binding) bool {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			*bindings = append(*bindings, binding{pattern, val})
+		}
+		return true
+
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return objectsEqual(literalObject(pattern.(ast.Expression)), val)
+
+	case *ast.ArrayPattern:
+		array, ok := val.(*object.Array)
+		if !ok || len(array.Elements) < len(pattern.Elements) {
+			return false
+		}
+		if pattern.Rest == nil && len(array.Elements) != len(pattern.Elements) {
+			return false
+		}
+		for i, elem := range pattern.Elements {
+			if !matchPattern(elem, array.Elements[i], bindings) {
+				return false
+			}
+		}
+		if pattern.Rest != nil {
+			rest := append([]object.Object{}, array.Elements[len(pattern.Elements):]...)
+			return matchPattern(pattern.Rest, &object.Array{Elements: rest}, bindings)
+		}
+		return true
+
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return false
+		}
+		matched := map[object.HashKey]bool{}
+		for _, pair := range pattern.Pairs {
+			key := literalObject(pair.Key).(object.Hashable).HashKey()
+			found, ok := hash.Pairs[key]
+			if !ok || !matchPattern(pair.Value, found.Value, bindings) {
+				return false
+			}
+			matched[key] = true
+		}
+		if pattern.Rest != nil {
+			rest := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+			for key, pair := range hash.Pairs {
+				if !matched[key] {
+					rest.Pairs[key] = pair
+				}
+			}
+			return matchPattern(pattern.Rest, rest, bindings)
+		}
+		return true
+
+	case *ast.TypePattern:
+		return string(val.Type()) == pattern.Type.Value && matchPattern(pattern.Pattern, val, bindings)
+	}
+	return false
+}
+
+// destructureArguments binds the identifiers of the patterns destructuring
+// the arguments of fn in env. It returns nil, or the error if an argument
+// does not match its pattern.
+func (r *run) destructureArguments(fn *object.Function, args []object.Object, env *object.Environment) object.Object {
+	for i, pattern := range fn.Patterns {
+		if pattern == nil {
+			continue
+		}
+		if errObj := r.destructure(pattern, args[i], env); errObj != nil {
+			return errObj
+		}
+	}
+	return nil
+}
+
+// destructure binds the identifiers of pattern in env to the parts of val
+// they match, evaluating the defaults of missing elements in env. It
+// returns nil, or the error if val does not match pattern.
+func (r *run) destructure(pattern ast.Pattern, val object.Object, env *object.Environment) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			setIdentifier(pattern, val, env)
+		}
+		return nil
+
+	case *ast.ArrayPattern:
+		array, ok := val.(*object.Array)
+		if !ok {
+			return newError("cannot destructure %s as an array with %s", val.Type(), pattern)
+		}
+		if errObj := checkArrayLength(pattern, len(array.Elements)); errObj != nil {
+			return errObj
+		}
+		for i, elem := range pattern.Elements {
+			var elemVal object.Object
+			if i < len(array.Elements) {
+				elemVal = array.Elements[i]
+			}
+			if errObj := r.destructureElement(elem, elemVal, env); errObj != nil {
+				return errObj
+			}
+		}
+		if pattern.Rest != nil {
+			rest := []object.Object{}
+			if len(array.Elements) > len(pattern.Elements) {
+				rest = append(rest, array.Elements[len(pattern.Elements):]...)
+			}
+			return r.destructure(pattern.Rest, &object.Array{Elements: rest}, env)
+		}
+		return nil
+
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return newError("cannot destructure %s as a hash with %s", val.Type(), pattern)
+		}
+		destructured := map[object.HashKey]bool{}
+		for _, pair := range pattern.Pairs {
+			key := literalObject(pair.Key).(object.Hashable).HashKey()
+			destructured[key] = true
+			var pairVal object.Object
+			if found, ok := hash.Pairs[key]; ok {
+				pairVal = found.Value
+			} else if _, ok := pair.Value.(*ast.DefaultPattern); !ok {
+				return newError("key %s not found to destructure with %s", pair.Key, pattern)
+			}
+			if errObj := r.destructureElement(pair.Value, pairVal, env); errObj != nil {
+				return errObj
+			}
+		}
+		if pattern.Rest != nil {
+			rest := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+			for key, pair := range hash.Pairs {
+				if !destructured[key] {
+					rest.Pairs[key] = pair
+				}
+			}
+			return r.destructure(pattern.Rest, rest, env)
+		}
+		return nil
+	}
+
+	// literal and type patterns destructure nothing but must match
+	var bindings []binding
+	if !matchPattern(pattern, val, &bindings) {
+		return newError("%s %s does not match the pattern %s", val.Type(), val.Inspect(), pattern)
+	}
+	for _, b := range bindings {
+		setIdentifier(b.ident, b.val, env)
+	}
+	return nil
+}
+
+// destructureElement destructures val, the element of an array or the value
+// of a hash that pattern is for, or its default if val is nil because it is
+// missing.
+func (r *run) destructureElement(pattern ast.Pattern, val object.Object, env *object.Environment) object.Object {
+	dp, ok := pattern.(*ast.DefaultPattern)
+	if !ok {
+		return r.destructure(pattern, val, env)
+	}
+	if val == nil {
+		if val = r.eval(dp.Default, env); isError(val) {
+			return val
+		}
+	}
+	return r.destructure(dp.Pattern, val, env)
+}
+
+// checkArrayLength reports an error if an array of n elements is too short
+// or too long for pattern, whose trailing elements with defaults may be
+// missing.
+func checkArrayLength(pattern *ast.ArrayPattern, n int) object.Object {
+	required := len(pattern.Elements)
+	for required > 0 {
+		if _, ok := pattern.Elements[required-1].(*ast.DefaultPattern); !ok {
+			break
+		}
+		required--
+	}
+
+	switch {
+	case pattern.Rest != nil && n < required:
+		return newError("expected at least %d elements to destructure with %s, got %d", required, pattern, n)
+	case pattern.Rest != nil:
+		return nil
+	case required == len(pattern.Elements) && n != required:
+		return newError("expected %d elements to destructure with %s, got %d", required, pattern, n)
+	case n < required || n > len(pattern.Elements):
+		return newError("expected %d to %d elements to destructure with %s, got %d", required, len(pattern.Elements), pattern, n)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+func isQuoteCall(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	return ok && ident.Value == "quote"
+}
+
+func isUnquoteCall(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	return ok && ident.Value == "unquote"
+}
+
+// quote returns node without evaluating it, except for the arguments of
+// unquote calls in it, which are evaluated in env and replaced by the code
+// of their values.
+func (r *run) quote(node *ast.CallExpression, env *object.Environment) object.Object {
+	if len(node.Arguments) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(node.Arguments))
+	}
+
+	var err *object.Error
+	quoted := ast.Modify(node.Arguments[0], func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || !isUnquoteCall(call) || err != nil {
+			return node
+		}
+		if len(call.Arguments) != 1 {
+			err = newError("wrong number of arguments. got=%d, want=1", len(call.Arguments))
+			return node
+		}
+
+		unquoted := r.eval(call.Arguments[0], env)
+		if errObj, ok := unquoted.(*object.Error); ok {
+			err = errObj
+			return node
+		}
+		converted, convErr := convertObjectToASTNode(unquoted)
+		if convErr != nil {
+			err = newError("%s", convErr)
+			return node
+		}
+		return converted
+	})
+	if err != nil {
+		return err
+	}
+
+	return &object.Quote{Node: quoted}
+}
+
+// convertObjectToASTNode returns code that evaluates to obj.
+func convertObjectToASTNode(obj object.Object) (ast.Node, error) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		literal := strconv.FormatInt(obj.Value, 10)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}, Value: obj.Value}, nil
+
+	case *object.Boolean:
+		if obj.Value {
+			return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}, nil
+		}
+		return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}, nil
+
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: obj.Value}, Value: obj.Value}, nil
+
+	case *object.Array:
+		elements := make([]ast.Expression, len(obj.Elements))
+		for i, el := range obj.Elements {
+			node, err := convertObjectToASTNode(el)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = node.(ast.Expression)
+		}
+		return &ast.ArrayLiteral{Token: token.Token{Type: token.LBRACKET, Literal: "["}, Elements: elements}, nil
+
+	case *object.Hash:
+		pairs := []ast.HashPair{}
+		for _, pair := range obj.SortedPairs() {
+			key, err := convertObjectToASTNode(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := convertObjectToASTNode(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, ast.HashPair{Key: key.(ast.Expression), Value: value.(ast.Expression)})
+		}
+		return &ast.HashLiteral{Token: token.Token{Type: token.LBRACE, Literal: "{"}, Pairs: pairs}, nil
+
+	case *object.Quote:
+		return obj.Node, nil
+
+	default:
+		return nil, fmt.Errorf("cannot unquote %s", obj.Type())
+	}
+}
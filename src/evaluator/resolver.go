@@ -0,0 +1,309 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Resolve annotates every identifier in program with where its binding
+// lives, so evaluation can read local variables from slots instead of
+// searching the environment chain by name. Locals of a function get slots;
+// top-level bindings are still looked up by name, since a REPL or an
+// embedder keeps adding to them.
+//
+// Identifiers that are not bound by program, env, the interpreter's
+// builtins, or one of names are reported as errors. A program is
+// only marked as resolved if there are none. Resolving modifies the AST, so
+// a program must not be resolved while it is being evaluated.
+func (in *Interpreter) Resolve(program *ast.Program, env *object.Environment, names ...string) []string {
+	r := &resolver{
+		in:      in,
+		env:     env,
+		globals: map[string]bool{},
+	}
+	for _, name := range names {
+		r.globals[name] = true
+	}
+	for _, name := range declaredNames(program) {
+		r.globals[name] = true
+	}
+
+	for _, stmt := range program.Statements {
+		r.resolve(stmt)
+	}
+
+	program.Resolved = len(r.errors) == 0
+	return r.errors
+}
+
+type resolver struct {
+	in      *Interpreter
+	env     *object.Environment
+	globals map[string]bool
+	scopes  []*scope
+	errors  []string
+
+	// quoted counts the quote calls the resolver is in. Quoted code is not
+	// resolved, except for the arguments of unquote calls in it.
+	quoted int
+}
+
+// scope holds the slots of one function. Blocks of if expressions do not
+// open a scope of their own.
+type scope struct {
+	slots map[string]int
+	names []string
+}
+
+func (s *scope) declare(name string) int {
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+	s.slots[name] = len(s.names)
+	s.names = append(s.names, name)
+	return s.slots[name]
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if r.quoted == 0 {
+			r.resolveReference(node)
+		}
+
+	case *ast.LetStatement:
+		r.resolve(node.Value)
+		if node.Pattern != nil {
+			r.resolvePattern(node.Pattern)
+		}
+		if r.quoted == 0 {
+			if node.Exported && len(r.scopes) > 0 {
+				var exported ast.Node = node.Name
+				if node.Pattern != nil {
+					exported = node.Pattern
+				}
+				r.errors = append(r.errors, fmt.Sprintf("export of %s must be at the top level", exported))
+			}
+			if node.Name != nil {
+				r.resolveDeclaration(node.Name)
+			}
+		}
+
+	case *ast.ImportStatement:
+		if len(r.scopes) > 0 {
+			r.errors = append(r.errors, "import statements must be at the top level")
+		}
+		for _, ident := range node.Bound() {
+			r.resolveDeclaration(ident)
+		}
+
+	case *ast.FunctionLiteral:
+		if r.quoted > 0 {
+			for _, pattern := range node.Patterns {
+				r.resolvePattern(pattern)
+			}
+			r.resolve(node.Body)
+			return
+		}
+		s := &scope{slots: map[string]int{}}
+		for _, param := range node.Params {
+			s.declare(param.Value)
+		}
+		for _, pattern := range node.Patterns {
+			for _, ident := range ast.PatternBindings(pattern) {
+				s.declare(ident.Value)
+			}
+		}
+		for _, name := range declaredNames(node.Body) {
+			s.declare(name)
+		}
+		node.Locals = s.names
+
+		r.scopes = append(r.scopes, s)
+		for _, param := range node.Params {
+			r.resolveDeclaration(param)
+		}
+		for _, pattern := range node.Patterns {
+			if pattern != nil {
+				r.resolvePattern(pattern)
+			}
+		}
+		r.resolve(node.Body)
+		r.scopes = r.scopes[:len(r.scopes)-1]
+
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			r.resolve(stmt)
+		}
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+	case *ast.ThrowStatement:
+		r.resolve(node.Value)
+	case *ast.TryExpression:
+		r.resolve(node.Block)
+		if node.Catch != nil {
+			if r.quoted == 0 {
+				r.resolveDeclaration(node.Param)
+			}
+			r.resolve(node.Catch)
+		}
+		if node.Finally != nil {
+			r.resolve(node.Finally)
+		}
+	case *ast.MatchExpression:
+		r.resolve(node.Value)
+		for _, arm := range node.Arms {
+			r.resolvePattern(arm.Pattern)
+			if arm.Guard != nil {
+				r.resolve(arm.Guard)
+			}
+			r.resolve(arm.Body)
+		}
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Consequence)
+		if node.Alternative != nil {
+			r.resolve(node.Alternative)
+		}
+	case *ast.CallExpression:
+		switch {
+		case isQuoteCall(node):
+			r.quoted++
+			defer func() { r.quoted-- }()
+		case isUnquoteCall(node) && r.quoted > 0:
+			quoted := r.quoted
+			r.quoted = 0
+			defer func() { r.quoted = quoted }()
+		default:
+			r.resolve(node.Function)
+		}
+		for _, arg := range node.Arguments {
+			r.resolve(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.resolve(el)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			r.resolve(pair.Key)
+			r.resolve(pair.Value)
+		}
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.SelectorExpression:
+		r.resolve(node.Left)
+	}
+}
+
+// resolvePattern declares the identifiers bound by pattern, checks the types
+// it names and resolves its default values.
+func (r *resolver) resolvePattern(pattern ast.Pattern) {
+	ast.Inspect(pattern, func(n ast.Node) bool {
+		if dp, ok := n.(*ast.DefaultPattern); ok {
+			r.resolve(dp.Default)
+		}
+		_, ok := n.(ast.Pattern)
+		return ok
+	})
+	if r.quoted > 0 {
+		return
+	}
+	seen := map[string]bool{}
+	for _, ident := range ast.PatternBindings(pattern) {
+		if seen[ident.Value] {
+			r.errors = append(r.errors, fmt.Sprintf("%s is bound more than once in pattern %s", ident.Value, pattern))
+		}
+		seen[ident.Value] = true
+		r.resolveDeclaration(ident)
+	}
+	ast.Inspect(pattern, func(n ast.Node) bool {
+		if tp, ok := n.(*ast.TypePattern); ok && !valueTypes[tp.Type.Value] {
+			r.errors = append(r.errors, fmt.Sprintf("unknown type in pattern: %s", tp.Type.Value))
+		}
+		return true
+	})
+}
+
+func (r *resolver) resolveDeclaration(ident *ast.Identifier) {
+	ident.Resolved = true
+	ident.Depth = 0
+	ident.Slot = -1
+	if len(r.scopes) > 0 {
+		ident.Slot = r.scopes[len(r.scopes)-1].slots[ident.Value]
+	}
+}
+
+func (r *resolver) resolveReference(ident *ast.Identifier) {
+	ident.Resolved = true
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if slot, ok := r.scopes[i].slots[ident.Value]; ok {
+			ident.Depth = len(r.scopes) - 1 - i
+			ident.Slot = slot
+			return
+		}
+	}
+
+	// a top-level binding, looked up by name from the program's environment
+	ident.Depth = len(r.scopes)
+	ident.Slot = -1
+	if !r.isGlobal(ident.Value) {
+		r.errors = append(r.errors, fmt.Sprintf("identifier not found: %s", ident.Value))
+	}
+}
+
+func (r *resolver) isGlobal(name string) bool {
+	if r.globals[name] || r.in.Defines(name) {
+		return true
+	}
+	if r.env != nil {
+		_, ok := r.env.Get(name)
+		return ok
+	}
+	return false
+}
+
+// declaredNames returns the names bound by let and import statements, catch
+// blocks and match patterns in the scope of node, including those in blocks
+// of if and try expressions but not those inside nested function literals. A
+// name can be used before its let statement, as in functions that call each
+// other.
+func declaredNames(node ast.Node) []string {
+	var names []string
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			for _, ident := range n.Bound() {
+				names = append(names, ident.Value)
+			}
+		case *ast.ImportStatement:
+			for _, ident := range n.Bound() {
+				names = append(names, ident.Value)
+			}
+		case *ast.TryExpression:
+			if n.Param != nil {
+				names = append(names, n.Param.Value)
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				for _, ident := range ast.PatternBindings(arm.Pattern) {
+					names = append(names, ident.Value)
+				}
+			}
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return n == node
+		}
+		return true
+	})
+	return names
+}
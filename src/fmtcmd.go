@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"monkey/format"
+)
+
+// runFmt implements `monkey fmt`, which formats the given files, or standard
+// input if there are none, in the canonical style.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the files instead of standard output")
+	check := flags.Bool("check", false, "only list the files that are not formatted, and fail if there are any")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey fmt [-w | -check] [files]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		formatted, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>: %s\n", err)
+			return 1
+		}
+		if *check {
+			if !bytes.Equal(src, formatted) {
+				fmt.Println("<stdin>")
+				return 1
+			}
+			return 0
+		}
+		os.Stdout.Write(formatted)
+		return 0
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		formatted, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			status = 1
+			continue
+		}
+
+		switch {
+		case *check:
+			if !bytes.Equal(src, formatted) {
+				fmt.Println(path)
+				status = 1
+			}
+		case *write:
+			if bytes.Equal(src, formatted) {
+				continue
+			}
+			if err := os.WriteFile(path, formatted, 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 1
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	return status
+}
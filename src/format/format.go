@@ -0,0 +1,658 @@
+// Package format prints Monkey programs in a canonical style: statements
+// one per line, blocks indented by four spaces, and operators and commas
+// followed by single spaces. The layout depends on the program alone, not on
+// how its source was laid out: blocks, match expressions and lists go on one
+// line if they fit in maxWidth columns and on several lines otherwise. Only
+// comments, which stay in front of the code following them, and single
+// blank lines between statements are kept from the source, so formatting a
+// formatted program changes nothing.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+)
+
+const indentation = "    "
+
+// maxWidth is the number of columns that lines are kept within, where the
+// program can be broken into lines.
+const maxWidth = 80
+
+// Source formats the Monkey program src. It fails if src does not parse.
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors: %s", strings.Join(p.Errors(), "; "))
+	}
+	return []byte(Program(program, p.Comments())), nil
+}
+
+// Program returns program in canonical style, with comments, as returned by
+// the parser, placed by their positions.
+func Program(program *ast.Program, comments []token.Token) string {
+	p := &printer{comments: comments}
+	p.statements(program.Statements, false)
+	p.flushComments(-1)
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+	}
+	return p.out.String()
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+
+	comments []token.Token // not printed yet
+
+	// The source line that what was printed last ends on, and whether it
+	// opened a block, in which case no blank line may follow.
+	lastLine   int
+	blockStart bool
+}
+
+// state is what a printer has printed so far, to go back to if a layout
+// turns out not to fit.
+type state struct {
+	len        int
+	comments   []token.Token
+	lastLine   int
+	blockStart bool
+}
+
+func (p *printer) save() state {
+	return state{p.out.Len(), p.comments, p.lastLine, p.blockStart}
+}
+
+func (p *printer) restore(s state) {
+	p.out.Truncate(s.len)
+	p.comments, p.lastLine, p.blockStart = s.comments, s.lastLine, s.blockStart
+}
+
+// fits reports whether the line of output that offset is on is at most
+// maxWidth wide, with reserve columns to spare. With oneLine, the output after
+// offset must also not span several lines.
+func (p *printer) fits(offset, reserve int, oneLine bool) bool {
+	out := p.out.Bytes()
+	start := bytes.LastIndexByte(out[:offset], '\n') + 1
+	end := len(out)
+	if i := bytes.IndexByte(out[offset:], '\n'); i >= 0 {
+		if oneLine {
+			return false
+		}
+		end = offset + i
+	}
+	return utf8.RuneCount(out[start:end])+reserve <= maxWidth
+}
+
+// newline starts a new line of output for an item starting on line in the
+// source. A blank line in the source between the last item and this one is
+// kept, but only one, and none at the start of a block.
+func (p *printer) newline(line int) {
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+		if !p.blockStart && p.lastLine > 0 && line > p.lastLine+1 {
+			p.out.WriteString("\n")
+		}
+	}
+	p.out.WriteString(strings.Repeat(indentation, p.indent))
+	p.blockStart = false
+}
+
+// flushComments prints the comments before line, or all of them if line is
+// negative. A comment on the line the last printed item ends on stays at the
+// end of it, the others get lines of their own.
+func (p *printer) flushComments(line int) {
+	for len(p.comments) > 0 {
+		c := p.comments[0]
+		if line >= 0 && c.Pos.Line >= line {
+			return
+		}
+		p.comments = p.comments[1:]
+
+		if p.lastLine > 0 && c.Pos.Line == p.lastLine && p.out.Len() > 0 {
+			p.out.WriteString(" " + c.Literal)
+			continue
+		}
+		p.newline(c.Pos.Line)
+		p.out.WriteString(c.Literal)
+		p.lastLine = c.Pos.Line
+	}
+}
+
+// leadingComments prints the comments before pos that have not been printed
+// yet, in front of the node starting there. They are inside an expression,
+// so the node continues on the next line, indented once more.
+func (p *printer) leadingComments(pos token.Position) {
+	if pos.Line == 0 {
+		return
+	}
+	for len(p.comments) > 0 && before(p.comments[0].Pos, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		line := p.out.Bytes()[bytes.LastIndexByte(p.out.Bytes(), '\n')+1:]
+		if len(bytes.TrimSpace(line)) > 0 && !bytes.HasSuffix(line, []byte(" ")) {
+			p.out.WriteString(" ")
+		}
+		p.out.WriteString(c.Literal + "\n" + strings.Repeat(indentation, p.indent+1))
+		p.lastLine = c.Pos.Line
+		p.blockStart = false
+	}
+}
+
+// hasComments reports whether there are comments between the positions
+// from and to.
+func (p *printer) hasComments(from, to token.Position) bool {
+	for _, c := range p.comments {
+		if before(from, c.Pos) && before(c.Pos, to) {
+			return true
+		}
+	}
+	return false
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func (p *printer) statements(stmts []ast.Statement, inBlock bool) {
+	for i, stmt := range stmts {
+		line := stmt.Pos().Line
+		if line > 0 {
+			p.flushComments(line)
+		}
+		p.newline(line)
+		p.statement(stmt, inBlock && i == len(stmts)-1)
+		p.lastLine = endLine(stmt)
+	}
+}
+
+// statement prints stmt. The last statement of a block is its value and is
+// not terminated by a semicolon, and neither are if, try and match
+// expressions.
+func (p *printer) statement(stmt ast.Statement, last bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		if stmt.Exported {
+			p.out.WriteString("export ")
+		}
+		p.out.WriteString("let ")
+		if stmt.Pattern != nil {
+			p.pattern(stmt.Pattern)
+		} else {
+			p.out.WriteString(stmt.Name.Value)
+		}
+		p.out.WriteString(" = ")
+		p.expression(stmt.Value, parser.LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ImportStatement:
+		p.importStatement(stmt)
+
+	case *ast.ReturnStatement:
+		p.out.WriteString("return ")
+		p.expression(stmt.ReturnValue, parser.LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ThrowStatement:
+		p.out.WriteString("throw ")
+		p.expression(stmt.Value, parser.LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression, parser.LOWEST)
+		switch stmt.Expression.(type) {
+		case *ast.IfExpression, *ast.TryExpression, *ast.MatchExpression:
+		default:
+			if !last {
+				p.out.WriteString(";")
+			}
+		}
+	}
+}
+
+func (p *printer) importStatement(stmt *ast.ImportStatement) {
+	p.out.WriteString("import ")
+	if stmt.Names != nil {
+		names := make([]string, len(stmt.Names))
+		for i, name := range stmt.Names {
+			names[i] = name.Name.Value
+			if name.Alias != nil {
+				names[i] += " as " + name.Alias.Value
+			}
+		}
+		if len(names) == 0 {
+			p.out.WriteString("{} from ")
+		} else {
+			p.out.WriteString("{ " + strings.Join(names, ", ") + " } from ")
+		}
+	}
+	p.out.WriteString(quote(stmt.Path.Value))
+	if stmt.Alias != nil {
+		p.out.WriteString(" as " + stmt.Alias.Value)
+	}
+	p.out.WriteString(";")
+}
+
+// block prints a block on one line if it holds a single statement and no
+// comments, and the statement fits on the line, and with one statement per
+// line otherwise.
+func (p *printer) block(block *ast.BlockStatement) {
+	p.leadingComments(block.Token.Pos)
+	start, end := block.Token.Pos.Line, block.Rbrace.Pos.Line
+	comments := p.hasComments(block.Token.Pos, block.Rbrace.Pos)
+
+	if len(block.Statements) == 0 && !comments {
+		p.out.WriteString("{}")
+		p.lastLine = end
+		return
+	}
+	if len(block.Statements) == 1 && !comments {
+		s := p.save()
+		p.out.WriteString("{ ")
+		p.statement(block.Statements[0], true)
+		p.out.WriteString(" }")
+		if p.fits(s.len, 0, true) {
+			p.lastLine = end
+			return
+		}
+		p.restore(s)
+	}
+
+	p.out.WriteString("{")
+	p.lastLine = start
+	p.blockStart = true
+	p.indent++
+	p.statements(block.Statements, true)
+	if end > 0 {
+		p.flushComments(end)
+	}
+	p.indent--
+	p.blockStart = false
+	p.out.WriteString("\n" + strings.Repeat(indentation, p.indent) + "}")
+	p.lastLine = end
+}
+
+// highest is the precedence of expressions that never need parentheses.
+const highest = parser.INDEX + 1
+
+// precedence returns how tightly exp binds, to decide whether it needs
+// parentheses as the operand of an operator.
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(token.TokenType(exp.Operator))
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.SelectorExpression:
+		return parser.INDEX
+	case *ast.IfExpression, *ast.TryExpression, *ast.MatchExpression:
+		return parser.LOWEST
+	case *ast.IntegerLiteral:
+		if exp.Value < 0 {
+			return parser.PREFIX
+		}
+	}
+	return highest
+}
+
+// expression prints exp, in parentheses if it binds less tightly than prec.
+func (p *printer) expression(exp ast.Expression, prec int) {
+	p.leadingComments(exp.Pos())
+	if precedence(exp) < prec {
+		p.out.WriteString("(")
+		defer p.out.WriteString(")")
+	}
+
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(exp.Value)
+
+	case *ast.IntegerLiteral:
+		if exp.Token.Literal != "" {
+			p.out.WriteString(exp.Token.Literal)
+		} else {
+			p.out.WriteString(strconv.FormatInt(exp.Value, 10))
+		}
+
+	case *ast.StringLiteral:
+		p.out.WriteString(quote(exp.Value))
+
+	case *ast.Boolean:
+		p.out.WriteString(strconv.FormatBool(exp.Value))
+
+	case *ast.PrefixExpression:
+		p.out.WriteString(exp.Operator)
+		p.expression(exp.Right, parser.PREFIX)
+
+	case *ast.InfixExpression:
+		// operators are left-associative, so an operand on the right with
+		// the same precedence needs parentheses
+		prec := parser.Precedence(token.TokenType(exp.Operator))
+		p.expression(exp.Left, prec)
+		p.out.WriteString(" " + exp.Operator + " ")
+		p.expression(exp.Right, prec+1)
+
+	case *ast.IfExpression:
+		p.out.WriteString("if (")
+		p.expression(exp.Condition, parser.LOWEST)
+		p.out.WriteString(") ")
+		p.block(exp.Consequence)
+		if exp.Alternative != nil {
+			p.out.WriteString(" else ")
+			p.block(exp.Alternative)
+		}
+
+	case *ast.TryExpression:
+		p.out.WriteString("try ")
+		p.block(exp.Block)
+		if exp.Catch != nil {
+			p.out.WriteString(" catch (" + exp.Param.Value + ") ")
+			p.block(exp.Catch)
+		}
+		if exp.Finally != nil {
+			p.out.WriteString(" finally ")
+			p.block(exp.Finally)
+		}
+
+	case *ast.MatchExpression:
+		p.out.WriteString("match (")
+		p.expression(exp.Value, parser.LOWEST)
+		p.out.WriteString(") ")
+		p.matchArms(exp)
+
+	case *ast.FunctionLiteral:
+		p.out.WriteString("fn")
+		p.parameters(exp.Params, exp.Patterns, exp.Token.Pos, exp.Body.Token.Pos)
+		p.block(exp.Body)
+
+	case *ast.MacroLiteral:
+		p.out.WriteString("macro")
+		p.parameters(exp.Params, nil, exp.Token.Pos, exp.Body.Token.Pos)
+		p.block(exp.Body)
+
+	case *ast.CallExpression:
+		p.expression(exp.Function, parser.CALL)
+		p.list("(", ")", exp.Token.Pos, exp.Rparen.Pos, len(exp.Arguments), 1, func(i int) ast.Node {
+			p.expression(exp.Arguments[i], parser.LOWEST)
+			return exp.Arguments[i]
+		}, func(i int) ast.Node { return exp.Arguments[i] })
+
+	case *ast.IndexExpression:
+		p.expression(exp.Left, parser.CALL)
+		p.out.WriteString("[")
+		p.expression(exp.Index, parser.LOWEST)
+		p.out.WriteString("]")
+
+	case *ast.SelectorExpression:
+		p.expression(exp.Left, parser.CALL)
+		p.out.WriteString("." + exp.Selector.Value)
+
+	case *ast.ArrayLiteral:
+		p.list("[", "]", exp.Token.Pos, exp.Rbracket.Pos, len(exp.Elements), 1, func(i int) ast.Node {
+			p.expression(exp.Elements[i], parser.LOWEST)
+			return exp.Elements[i]
+		}, func(i int) ast.Node { return exp.Elements[i] })
+
+	case *ast.HashLiteral:
+		p.list("{", "}", exp.Token.Pos, exp.Rbrace.Pos, len(exp.Pairs), 1, func(i int) ast.Node {
+			p.expression(exp.Pairs[i].Key, parser.LOWEST)
+			p.out.WriteString(": ")
+			p.expression(exp.Pairs[i].Value, parser.LOWEST)
+			return exp.Pairs[i].Value
+		}, func(i int) ast.Node { return exp.Pairs[i].Key })
+	}
+}
+
+// matchArms prints the arms of a match expression like the statements of a
+// block: on one line if there are no comments among them and they fit on
+// it, and one per line, each followed by a comma, otherwise.
+func (p *printer) matchArms(exp *ast.MatchExpression) {
+	start, end := exp.Token.Pos.Line, exp.Rbrace.Pos.Line
+	comments := p.hasComments(exp.Token.Pos, exp.Rbrace.Pos)
+
+	if len(exp.Arms) == 0 && !comments {
+		p.out.WriteString("{}")
+		p.lastLine = end
+		return
+	}
+	if !comments {
+		s := p.save()
+		p.out.WriteString("{ ")
+		for i, arm := range exp.Arms {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.matchArm(arm)
+		}
+		p.out.WriteString(" }")
+		if p.fits(s.len, 0, true) {
+			p.lastLine = end
+			return
+		}
+		p.restore(s)
+	}
+
+	p.out.WriteString("{")
+	p.lastLine = start
+	p.blockStart = true
+	p.indent++
+	for _, arm := range exp.Arms {
+		line := arm.Pattern.Pos().Line
+		if line > 0 {
+			p.flushComments(line)
+		}
+		p.newline(line)
+		p.matchArm(arm)
+		p.out.WriteString(",")
+		p.lastLine = endLine(arm.Body)
+	}
+	if end > 0 {
+		p.flushComments(end)
+	}
+	p.indent--
+	p.blockStart = false
+	p.out.WriteString("\n" + strings.Repeat(indentation, p.indent) + "}")
+	p.lastLine = end
+}
+
+func (p *printer) matchArm(arm *ast.MatchArm) {
+	p.pattern(arm.Pattern)
+	if arm.Guard != nil {
+		p.out.WriteString(" if ")
+		p.expression(arm.Guard, parser.LOWEST)
+	}
+	p.out.WriteString(" => ")
+	p.expression(arm.Body, parser.LOWEST)
+}
+
+func (p *printer) pattern(pattern ast.Pattern) {
+	p.leadingComments(pattern.Pos())
+	switch pattern := pattern.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		p.expression(pattern.(ast.Expression), parser.LOWEST)
+
+	case *ast.ArrayPattern:
+		p.out.WriteString("[")
+		for i, elem := range pattern.Elements {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.pattern(elem)
+		}
+		p.rest(pattern.Rest, len(pattern.Elements) > 0)
+		p.out.WriteString("]")
+
+	case *ast.HashPattern:
+		p.out.WriteString("{")
+		for i, pair := range pattern.Pairs {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			if pair.Shorthand() {
+				p.pattern(pair.Value)
+				continue
+			}
+			p.expression(pair.Key, parser.LOWEST)
+			p.out.WriteString(": ")
+			p.pattern(pair.Value)
+		}
+		p.rest(pattern.Rest, len(pattern.Pairs) > 0)
+		p.out.WriteString("}")
+
+	case *ast.TypePattern:
+		p.pattern(pattern.Pattern)
+		p.out.WriteString(" is " + pattern.Type.Value)
+
+	case *ast.DefaultPattern:
+		p.pattern(pattern.Pattern)
+		p.out.WriteString(" = ")
+		p.expression(pattern.Default, parser.LOWEST)
+	}
+}
+
+// rest prints the rest element of an array or hash pattern, if there is
+// one, after a comma if it follows other elements.
+func (p *printer) rest(rest *ast.Identifier, separate bool) {
+	if rest == nil {
+		return
+	}
+	if separate {
+		p.out.WriteString(", ")
+	}
+	p.out.WriteString("..." + rest.Value)
+}
+
+// parameters prints params, or the patterns destructuring them where there
+// are some, as a list between the positions start and end of the function
+// literal.
+func (p *printer) parameters(params []*ast.Identifier, patterns []ast.Pattern, start, end token.Position) {
+	param := func(i int) ast.Node {
+		if patterns != nil && patterns[i] != nil {
+			return patterns[i]
+		}
+		return params[i]
+	}
+	p.list("(", ")", start, end, len(params), len(" {"), func(i int) ast.Node {
+		p.pattern(param(i).(ast.Pattern))
+		return param(i)
+	}, param)
+	p.out.WriteString(" ")
+}
+
+// list prints the n elements of a list between the positions start and end
+// with printElement, which returns the last node it printed. The elements
+// go on the line of the brackets if there are no comments among them and
+// that line fits, leaving reserve columns for what follows the list, such as
+// a comma. Only the last element may then span several lines, such as a
+// function literal passed as the last argument. Otherwise each element gets
+// a line of its own. first returns the first node of an element.
+func (p *printer) list(open, close string, start, end token.Position, n, reserve int, printElement, first func(i int) ast.Node) {
+	if !p.hasComments(start, end) {
+		s := p.save()
+		p.out.WriteString(open)
+		last := p.out.Len()
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			last = p.out.Len()
+			printElement(i)
+		}
+		p.out.WriteString(close)
+		if n == 0 || !bytes.Contains(p.out.Bytes()[s.len:last], []byte("\n")) && p.fits(s.len, reserve, false) {
+			return
+		}
+		p.restore(s)
+	}
+
+	p.out.WriteString(open)
+	p.lastLine = start.Line
+	p.blockStart = true
+	p.indent++
+	for i := 0; i < n; i++ {
+		line := first(i).Pos().Line
+		if line > 0 {
+			p.flushComments(line)
+		}
+		p.newline(line)
+		p.lastLine = endLine(printElement(i))
+		if i < n-1 {
+			p.out.WriteString(",")
+		}
+	}
+	if end.Line > 0 {
+		p.flushComments(end.Line)
+	}
+	p.indent--
+	p.blockStart = false
+	p.out.WriteString("\n" + strings.Repeat(indentation, p.indent) + close)
+	p.lastLine = end.Line
+}
+
+// endLine returns the last source line with a token of node, as far as the
+// AST records them.
+func endLine(node ast.Node) int {
+	line := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		line = max(line, n.Pos().Line)
+		switch n := n.(type) {
+		case *ast.BlockStatement:
+			line = max(line, n.Rbrace.Pos.Line)
+		case *ast.CallExpression:
+			line = max(line, n.Rparen.Pos.Line)
+		case *ast.ArrayLiteral:
+			line = max(line, n.Rbracket.Pos.Line)
+		case *ast.HashLiteral:
+			line = max(line, n.Rbrace.Pos.Line)
+		case *ast.MatchExpression:
+			line = max(line, n.Rbrace.Pos.Line)
+		case *ast.ArrayPattern:
+			line = max(line, n.Rbracket.Pos.Line)
+		case *ast.HashPattern:
+			line = max(line, n.Rbrace.Pos.Line)
+		}
+		return true
+	})
+	return line
+}
+
+// quote returns s as a string literal, escaped the way the lexer expects.
+func quote(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
@@ -0,0 +1,307 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"monkey/lexer"
+	"monkey/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let   x=5", "let x = 5;\n"},
+		{"5+5*2;(5+5)*2", "5 + 5 * 2;\n(5 + 5) * 2;\n"},
+		{"a-(b-c); (a-b)-c; -(a+b); !-x; (-a)[0]", "a - (b - c);\na - b - c;\n-(a + b);\n!-x;\n(-a)[0];\n"},
+		{`f(1)[0]; (f)(2); (if (x) { f } else { g })(1)`, "f(1)[0];\nf(2);\n(if (x) { f } else { g })(1);\n"},
+		{`"say \"hi\"\n"`, `"say \"hi\"\n";` + "\n"},
+		{`{"a":1,"b":[1,2,3]}`, `{"a": 1, "b": [1, 2, 3]};` + "\n"},
+		{"let add = fn(a,b){a+b;};", "let add = fn(a, b) { a + b };\n"},
+		{"let f = fn(){};", "let f = fn() {};\n"},
+		{"let f = fn(x){ let y = x; y }", "let f = fn(x) {\n    let y = x;\n    y\n};\n"},
+		{"if (x) {\n1\n} else { return 2 }", "if (x) { 1 } else { return 2; }\n"},
+		{"let m = macro(a) { quote(unquote(a)) }", "let m = macro(a) { quote(unquote(a)) };\n"},
+		{`import"lib.mk"as lib;import{a,b as c}from "x.mk";import {} from "y.mk"`, "import \"lib.mk\" as lib;\nimport { a, b as c } from \"x.mk\";\nimport {} from \"y.mk\";\n"},
+		{"export let x=lib.f(1).y; (-a).b; a[0].b", "export let x = lib.f(1).y;\n(-a).b;\na[0].b;\n"},
+		{
+			"try{parse(s)}catch(e){throw e;}finally{close()}\nlet x = try { 1 } finally { 2 };",
+			"try { parse(s) } catch (e) { throw e; } finally { close() }\nlet x = try { 1 } finally { 2 };\n",
+		},
+		{
+			"match(x){[a,...r] if a>0=>r,{\"k\":-1,n,...h}=>h,_ is INTEGER=>0};let y = match (y) {}",
+			"match (x) {\n    [a, ...r] if a > 0 => r,\n    {\"k\": -1, n, ...h} => h,\n    _ is INTEGER => 0,\n}\nlet y = match (y) {};\n",
+		},
+		{
+			"match (x) {\n  1 => \"one\",\n\n  _ => \"other\"\n}",
+			"match (x) { 1 => \"one\", _ => \"other\" }\n",
+		},
+		{
+			"let [a,[b=1],...r]=xs;export let {\"k\":k=f(1),n,...h}=x; let f = fn(a,{b=a*2},[c]){c}",
+			"let [a, [b = 1], ...r] = xs;\nexport let {\"k\": k = f(1), n, ...h} = x;\nlet f = fn(a, {b = a * 2}, [c]) { c };\n",
+		},
+		{
+			"let xs = [\n1,\n\n2];",
+			"let xs = [1, 2];\n",
+		},
+		{
+			"map(xs, fn(x) {\n  puts(x);\n  x * 2\n})",
+			"map(xs, fn(x) {\n    puts(x);\n    x * 2\n});\n",
+		},
+		{
+			"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;",
+			"let a = 1;\n\nlet b = 2;\nlet c = 3;\n",
+		},
+		{
+			"let f = fn(x) {\n\n  x\n\n};",
+			"let f = fn(x) { x };\n",
+		},
+		{
+			`let letters = ["alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta"];`,
+			"let letters = [\n    \"alpha\",\n    \"beta\",\n    \"gamma\",\n    \"delta\",\n    \"epsilon\",\n    \"zeta\",\n    \"eta\",\n    \"theta\"\n];\n",
+		},
+		{
+			`let total = compute(first_argument, second_argument, third_argument, fourth_argument);`,
+			"let total = compute(\n    first_argument,\n    second_argument,\n    third_argument,\n    fourth_argument\n);\n",
+		},
+		{
+			`let f = fn(first_parameter, second_parameter, third_parameter) { first_parameter };`,
+			"let f = fn(first_parameter, second_parameter, third_parameter) {\n    first_parameter\n};\n",
+		},
+		{
+			`let f = fn(first_parameter, second_parameter, third_parameter, fourth_parameter) { 1 };`,
+			"let f = fn(\n    first_parameter,\n    second_parameter,\n    third_parameter,\n    fourth_parameter\n) { 1 };\n",
+		},
+		{
+			`if (ready) { notify("the job has finished and its results are ready to be read") }`,
+			"if (ready) {\n    notify(\"the job has finished and its results are ready to be read\")\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Source(%q) returned error: %s", tt.input, err)
+			continue
+		}
+		if string(formatted) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant=%q\ngot= %q", tt.input, tt.expected, formatted)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"// only a comment", "// only a comment\n"},
+		{"// header\n\nlet x = 1; // trailing\n// footer", "// header\n\nlet x = 1; // trailing\n// footer\n"},
+		{"let f = fn() { 1 }; // one", "let f = fn() { 1 }; // one\n"},
+		{"let f = fn() { // opening\n  1 }", "let f = fn() { // opening\n    1\n};\n"},
+		{"let f = fn() { /* no */ }", ""},
+		{
+			"let f = fn(x) {\n   // leading\n   x\n        // closing\n};",
+			"let f = fn(x) {\n    // leading\n    x\n    // closing\n};\n",
+		},
+		{"let f = fn() {\n// empty\n};", "let f = fn() {\n    // empty\n};\n"},
+		{
+			"let xs = [1, // one\n2];",
+			"let xs = [\n    1, // one\n    2\n];\n",
+		},
+		{
+			"let h = {\n\"a\": 1,\n// b is next\n\"b\": 2 // two\n};",
+			"let h = {\n    \"a\": 1,\n    // b is next\n    \"b\": 2 // two\n};\n",
+		},
+		{"if (x) {\n  1 // one\n} else {\n  2\n} // done", "if (x) {\n    1 // one\n} else { 2 } // done\n"},
+		{"f(a, // first\n  b);\ng();", "f(\n    a, // first\n    b\n);\ng();\n"},
+		{"f(\n  // leading\n  a)", "f(\n    // leading\n    a\n);\n"},
+		{"f(a // last\n)", "f(\n    a // last\n);\n"},
+		{"puts(f(1, // inner\n 2), 3)", "puts(\n    f(\n        1, // inner\n        2\n    ),\n    3\n);\n"},
+		{"let f = fn(a, // first\n  b) { a };", "let f = fn(\n    a, // first\n    b\n) { a };\n"},
+		{"let f = fn(\n  // the input\n  [x, y]) { x };", "let f = fn(\n    // the input\n    [x, y]\n) { x };\n"},
+		{"let x = 1 + // one\n  2;\nlet y = 3;", "let x = 1 + // one\n    2;\nlet y = 3;\n"},
+		{"let x = -// negated\n  y;", "let x = - // negated\n    y;\n"},
+		{
+			"match (x) { // kinds\n  [] => 0, // empty\n  // anything else\n  _ => 1 }",
+			"match (x) { // kinds\n    [] => 0, // empty\n    // anything else\n    _ => 1,\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source([]byte(tt.input))
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("Source(%q) expected error. got=%q", tt.input, formatted)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Source(%q) returned error: %s", tt.input, err)
+			continue
+		}
+		if string(formatted) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant=%q\ngot= %q", tt.input, tt.expected, formatted)
+		}
+	}
+}
+
+const messy = `
+// Counts words.
+let count=fn(words){
+  let iter=fn(ws,acc){ if(len(ws)==0){acc}else{
+    let w = first(ws);   // current word
+    let n = if (acc[w] == null) { 0 } else { acc[w] };
+    iter(rest(ws), acc)
+  }};
+
+  iter(words,{})
+};
+
+
+let   words = split("a b a"," ");
+puts(count(words)["a"] * -(1 + 2), [1,2,
+  3], {"k":  "v"}) // done
+`
+
+func TestIdempotent(t *testing.T) {
+	once, err := Source([]byte(messy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	twice, err := Source(once)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(once) != string(twice) {
+		t.Errorf("formatting is not idempotent.\nonce:\n%s\ntwice:\n%s", once, twice)
+	}
+
+	for _, comment := range []string{"// Counts words.", "// current word", "// done"} {
+		if !strings.Contains(string(once), comment) {
+			t.Errorf("comment %q was lost:\n%s", comment, once)
+		}
+	}
+}
+
+func TestNestedBlocks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let g = fn() { fn() { 1 } };", "let g = fn() { fn() { 1 } };\n"},
+		{"let g = fn() { fn() { let y = 1; y } };", "let g = fn() {\n    fn() {\n        let y = 1;\n        y\n    }\n};\n"},
+		{"let g = fn() { fn() {\n1 } };", "let g = fn() { fn() { 1 } };\n"},
+		{"if (a) { if (b) { c; d } else { e } }", "if (a) {\n    if (b) {\n        c;\n        d\n    } else { e }\n}\n"},
+		{"try { try { a; b } catch (e) { e } } finally { c }", "try {\n    try {\n        a;\n        b\n    } catch (e) { e }\n} finally { c }\n"},
+		{"match (x) { _ => fn() { a; b } }", "match (x) {\n    _ => fn() {\n        a;\n        b\n    },\n}\n"},
+		{"let f = fn() { [fn() { a; b }] };", "let f = fn() {\n    [fn() {\n        a;\n        b\n    }]\n};\n"},
+	}
+
+	for _, tt := range tests {
+		once, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Source(%q) returned error: %s", tt.input, err)
+			continue
+		}
+		if string(once) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant=%q\ngot= %q", tt.input, tt.expected, once)
+		}
+		twice, err := Source(once)
+		if err != nil {
+			t.Errorf("Source(%q) returned error: %s", once, err)
+			continue
+		}
+		if string(twice) != string(once) {
+			t.Errorf("formatting %q is not idempotent.\nonce= %q\ntwice=%q", tt.input, once, twice)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		inputs   []string
+		expected string
+	}{
+		{
+			[]string{
+				"let f = fn(x) { x * 2 };",
+				"let f = fn(x) {\n  x * 2\n};",
+				"let f = fn(\n  x\n) {\n  x * 2;\n}",
+			},
+			"let f = fn(x) { x * 2 };\n",
+		},
+		{
+			[]string{
+				"if (a) { b } else { c }",
+				"if (a) {\n  b\n} else {\n  c\n}",
+				"if (a)\n{ b }\nelse\n{ c }",
+			},
+			"if (a) { b } else { c }\n",
+		},
+		{
+			[]string{
+				"let g = fn() { let y = 1; y };",
+				"let g = fn() {\nlet y = 1; y };",
+			},
+			"let g = fn() {\n    let y = 1;\n    y\n};\n",
+		},
+		{
+			[]string{
+				`let h = {"a": [1, 2], "b": match (x) { 1 => "one", _ => "many" }};`,
+				"let h = {\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": match (x) {\n    1 => \"one\",\n    _ => \"many\"\n  }\n};",
+			},
+			"let h = {\"a\": [1, 2], \"b\": match (x) { 1 => \"one\", _ => \"many\" }};\n",
+		},
+		{
+			[]string{
+				"map(xs, fn(x) { puts(x); x * 2 })",
+				"map(\n  xs,\n  fn(x) {\n    puts(x);\n    x * 2\n  }\n)",
+			},
+			"map(xs, fn(x) {\n    puts(x);\n    x * 2\n});\n",
+		},
+		{
+			[]string{
+				`let message = join(["the", "quick", "brown", "fox", "jumps", "over", "the", "lazy", "dog"], " ");`,
+				"let message = join(\n  [\"the\", \"quick\", \"brown\", \"fox\", \"jumps\", \"over\", \"the\", \"lazy\", \"dog\"],\n  \" \"\n);",
+			},
+			"let message = join(\n    [\"the\", \"quick\", \"brown\", \"fox\", \"jumps\", \"over\", \"the\", \"lazy\", \"dog\"],\n    \" \"\n);\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, input := range tt.inputs {
+			once, err := Source([]byte(input))
+			if err != nil {
+				t.Errorf("Source(%q) returned error: %s", input, err)
+				continue
+			}
+			if string(once) != tt.expected {
+				t.Errorf("Source(%q) wrong.\nwant=%q\ngot= %q", input, tt.expected, once)
+			}
+			twice, err := Source(once)
+			if err != nil {
+				t.Errorf("Source(%q) returned error: %s", once, err)
+				continue
+			}
+			if string(twice) != string(once) {
+				t.Errorf("formatting %q is not idempotent.\nonce= %q\ntwice=%q", input, once, twice)
+			}
+		}
+	}
+}
+
+func TestPreservesMeaning(t *testing.T) {
+	formatted, err := Source([]byte(messy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := parser.New(lexer.New(messy)).ParseProgram()
+	reparsed := parser.New(lexer.New(string(formatted))).ParseProgram()
+	if original.String() != reparsed.String() {
+		t.Errorf("formatting changed the program.\nbefore: %s\nafter:  %s", original.String(), reparsed.String())
+	}
+}
@@ -1,6 +1,8 @@
 package lexer
 
 import (
+	"strings"
+
 	"monkey/token"
 )
 
@@ -9,15 +11,22 @@ type Lexer struct {
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+	line, column int  // position of ch
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -39,17 +48,31 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	pos := token.Position{Line: l.line, Column: l.column}
 
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: "=="}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
+	case '.':
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.DOT, l.ch)
+		}
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -76,7 +99,12 @@ func (l *Lexer) NextToken() token.Token {
 	case '-':
 		tok = newToken(token.MINUS, l.ch)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '/' {
+			tok.Type = token.COMMENT
+			tok.Literal = l.readComment()
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '<':
@@ -93,10 +121,12 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdentType(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -105,6 +135,7 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.readChar()
 
+	tok.Pos = pos
 	return tok
 }
 
@@ -114,6 +145,17 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
+// Comments
+// readComment returns the comment starting at the current char, up to but
+// not including the end of the line.
+func (l *Lexer) readComment() string {
+	start := l.position
+	for l.peekChar() != '\n' && l.peekChar() != 0 {
+		l.readChar()
+	}
+	return strings.TrimRight(l.input[start:l.readPosition], "\r")
+}
+
 // Identifiers
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
@@ -141,14 +183,32 @@ func (l *Lexer) readNumber() string {
 }
 
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var out strings.Builder
 	for {
 		l.readChar()
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+		if l.ch == '\\' {
+			l.readChar()
+			switch l.ch {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case 0:
+				return out.String()
+			default:
+				// \" and \\ as well as unknown escapes yield the escaped character
+				out.WriteByte(l.ch)
+			}
+			continue
+		}
+		out.WriteByte(l.ch)
 	}
-	return l.input[position:l.position]
+	return out.String()
 }
 
 func newToken(tokenType token.TokenType, ch byte) token.Token {
@@ -29,6 +29,12 @@ if (5 < 10) {
 "foobar"
 "foo bar"
 [1, 2]
+{"foo": "bar"}
+"say \"hi\"\n\\"
+macro(x, y) { x + y; };
+10 / 2 // half
+import "lib.mk" as lib; export let x = lib.y;
+match (x) { [a, ...b] => a }
 `
 
 	tests := []struct {
@@ -125,6 +131,62 @@ if (5 < 10) {
 		{token.INT, "2"},
 		{token.RBRACKET, "]"},
 
+		{token.LBRACE, "{"},
+		{token.STRING, "foo"},
+		{token.COLON, ":"},
+		{token.STRING, "bar"},
+		{token.RBRACE, "}"},
+
+		{token.STRING, "say \"hi\"\n\\"},
+
+		{token.MACRO, "macro"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.COMMA, ","},
+		{token.IDENT, "y"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.IDENT, "y"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.COMMENT, "// half"},
+
+		{token.IMPORT, "import"},
+		{token.STRING, "lib.mk"},
+		{token.IDENT, "as"},
+		{token.IDENT, "lib"},
+		{token.SEMICOLON, ";"},
+		{token.EXPORT, "export"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "lib"},
+		{token.DOT, "."},
+		{token.IDENT, "y"},
+		{token.SEMICOLON, ";"},
+
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "b"},
+		{token.RBRACKET, "]"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "a"},
+		{token.RBRACE, "}"},
+
 		{token.EOF, ""},
 	}
 
@@ -142,3 +204,37 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  // note\n\tx + \"a\";"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{Line: 1, Column: 1}},
+		{token.IDENT, token.Position{Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{Line: 1, Column: 7}},
+		{token.INT, token.Position{Line: 1, Column: 9}},
+		{token.SEMICOLON, token.Position{Line: 1, Column: 10}},
+		{token.COMMENT, token.Position{Line: 2, Column: 3}},
+		{token.IDENT, token.Position{Line: 3, Column: 2}},
+		{token.PLUS, token.Position{Line: 3, Column: 4}},
+		{token.STRING, token.Position{Line: 3, Column: 6}},
+		{token.SEMICOLON, token.Position{Line: 3, Column: 9}},
+		{token.EOF, token.Position{Line: 3, Column: 10}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Package lint finds likely mistakes in Monkey programs without running
+// them. Each kind of mistake is found by a Rule, which reports Diagnostics
+// with a severity that can be configured per rule. Diagnostics are
+// suppressed by comments of the form
+//
+//	// check:ignore [rule[, rule]...] [-- reason]
+//
+// on the line they are reported on, or on a line of their own before it.
+// Without rules, the comment suppresses every diagnostic. A comment of the
+// form "// check:ignore-file" suppresses diagnostics in the whole file.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+)
+
+// Severity is how serious a diagnostic is. Rules whose severity is Off are
+// not checked.
+type Severity int
+
+const (
+	Off Severity = iota
+	Info
+	Warning
+	Error
+)
+
+var severities = [...]string{Off: "off", Info: "info", Warning: "warning", Error: "error"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severities) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severities[s]
+}
+
+// ParseSeverity returns the severity named name.
+func ParseSeverity(name string) (Severity, error) {
+	for s, n := range severities {
+		if n == name {
+			return Severity(s), nil
+		}
+	}
+	return Off, fmt.Errorf("unknown severity %q, want one of %s", name, strings.Join(severities[:], ", "))
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	var err error
+	*s, err = ParseSeverity(string(text))
+	return err
+}
+
+// Diagnostic is a mistake found in a program.
+type Diagnostic struct {
+	File     string
+	Pos      token.Position
+	Rule     string // "syntax" for parser errors
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%s: %s: %s [%s]", d.File, d.Pos, d.Severity, d.Message, d.Rule)
+}
+
+// MarshalJSON encodes d as an object with the fields file, line, column,
+// rule, severity and message.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File     string   `json:"file"`
+		Line     int      `json:"line"`
+		Column   int      `json:"column"`
+		Rule     string   `json:"rule"`
+		Severity Severity `json:"severity"`
+		Message  string   `json:"message"`
+	}{d.File, d.Pos.Line, d.Pos.Column, d.Rule, d.Severity, d.Message})
+}
+
+// Rule finds one kind of mistake.
+type Rule struct {
+	Name     string // in kebab-case
+	Doc      string // one sentence on what the rule finds
+	Severity Severity
+	Check    func(pass *Pass)
+}
+
+// Pass is what a rule checks: a program, with the bindings of its names.
+type Pass struct {
+	Program  *ast.Program
+	Bindings []*Binding
+
+	// Builtin reports whether name is a builtin.
+	Builtin func(name string) bool
+
+	rule        *Rule
+	severity    Severity
+	diagnostics *[]Diagnostic
+}
+
+// Report reports a mistake at pos.
+func (p *Pass) Report(pos token.Position, format string, args ...any) {
+	*p.diagnostics = append(*p.diagnostics, Diagnostic{
+		Pos:      pos,
+		Rule:     p.rule.Name,
+		Severity: p.severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Linter checks programs with a set of rules.
+type Linter struct {
+	Rules []*Rule
+
+	// Severity overrides the severity of rules, by name.
+	Severity map[string]Severity
+
+	// Interpreter defines the builtins of the programs.
+	Interpreter *evaluator.Interpreter
+}
+
+// New returns a linter with the rules of the package, at their default
+// severities, for programs run by an interpreter with the default options.
+func New() *Linter {
+	return &Linter{
+		Rules:       Rules(),
+		Severity:    map[string]Severity{},
+		Interpreter: evaluator.New(),
+	}
+}
+
+// SetSeverity sets the severity of the rule called name.
+func (l *Linter) SetSeverity(name string, severity Severity) error {
+	if l.rule(name) == nil {
+		return fmt.Errorf("unknown rule %q", name)
+	}
+	l.Severity[name] = severity
+	return nil
+}
+
+// Configure sets the severities of rules from a JSON object mapping their
+// names to severities, such as {"unused-let": "off"}.
+func (l *Linter) Configure(config []byte) error {
+	var severities map[string]Severity
+	if err := json.Unmarshal(config, &severities); err != nil {
+		return err
+	}
+	for name, severity := range severities {
+		if err := l.SetSeverity(name, severity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Linter) rule(name string) *Rule {
+	for _, r := range l.Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Check checks the program source, read from file, and returns its
+// diagnostics ordered by position. A program that does not parse has only
+// its parser errors as diagnostics, with the severity Error.
+func (l *Linter) Check(file, source string) []Diagnostic {
+	var diagnostics []Diagnostic
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for i, msg := range p.Errors() {
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     file,
+				Pos:      p.ErrorPositions()[i],
+				Rule:     "syntax",
+				Severity: Error,
+				Message:  msg,
+			})
+		}
+		return diagnostics
+	}
+
+	bindings := bind(program)
+	for _, rule := range l.Rules {
+		severity, ok := l.Severity[rule.Name]
+		if !ok {
+			severity = rule.Severity
+		}
+		if severity == Off {
+			continue
+		}
+		rule.Check(&Pass{
+			Program:     program,
+			Bindings:    bindings,
+			Builtin:     l.Interpreter.Defines,
+			rule:        rule,
+			severity:    severity,
+			diagnostics: &diagnostics,
+		})
+	}
+
+	suppressed := suppressions(source, p.Comments())
+	kept := diagnostics[:0]
+	for _, d := range diagnostics {
+		if !suppressed.covers(d) {
+			d.File = file
+			kept = append(kept, d)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		a, b := kept[i].Pos, kept[j].Pos
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return kept
+}
+
+// suppression holds the rules suppressed by comments, by line, with the
+// line 0 for the whole file. A nil set of rules stands for all of them.
+type suppression map[int]map[string]bool
+
+const (
+	ignore     = "check:ignore"
+	ignoreFile = "check:ignore-file"
+)
+
+func suppressions(source string, comments []token.Token) suppression {
+	lines := strings.Split(source, "\n")
+	s := suppression{}
+	for _, c := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Literal, "//"))
+		text, _, _ = strings.Cut(text, "--")
+
+		var line int
+		var rest string
+		switch {
+		case strings.HasPrefix(text, ignoreFile):
+			rest = text[len(ignoreFile):]
+		case strings.HasPrefix(text, ignore):
+			rest = text[len(ignore):]
+			line = c.Pos.Line
+			// a comment on a line of its own is about the next line
+			if strings.TrimSpace(lines[line-1][:c.Pos.Column-1]) == "" {
+				line++
+			}
+		default:
+			continue
+		}
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			continue // another word, such as check:ignored
+		}
+
+		rules := strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(rules) == 0 {
+			s[line] = nil
+			continue
+		}
+		if set, ok := s[line]; ok && set == nil {
+			continue // already suppresses every rule
+		}
+		if s[line] == nil {
+			s[line] = map[string]bool{}
+		}
+		for _, rule := range rules {
+			s[line][rule] = true
+		}
+	}
+	return s
+}
+
+func (s suppression) covers(d Diagnostic) bool {
+	for _, line := range []int{0, d.Pos.Line} {
+		if rules, ok := s[line]; ok && (rules == nil || rules[d.Rule]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package lint
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"monkey/ast"
+)
+
+// format returns the diagnostics as "line:col severity rule: message".
+func format(diagnostics []Diagnostic) []string {
+	var lines []string
+	for _, d := range diagnostics {
+		lines = append(lines, d.Pos.String()+" "+d.Severity.String()+" "+d.Rule+": "+d.Message)
+	}
+	return lines
+}
+
+func check(t *testing.T, l *Linter, source string, want ...string) {
+	t.Helper()
+	got := format(l.Check("test.mk", source))
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong diagnostics for\n%s\ngot:\n%s\nwant:\n%s", source, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{"let x = 1; let y = x; puts(y);", nil},
+		{"let x = 1; let x = 2; puts(x);", []string{"1:5 warning unused-let: x is bound but never used"}},
+		{"let f = fn(a, b) { let c = a; b }; f(1, 2);", []string{"1:24 warning unused-let: c is bound but never used"}},
+		{"let _ignored = 1; let test_x = fn() { 1 }; let later = fn() { used }; let used = 1; later();", nil},
+		{"let m = macro(a) { quote(fn() { let y = unquote(a); y }) }; m(1);", nil},
+		{`import "m.mk" as m; import { a, b as c } from "n.mk"; export let x = m.y; let len = c;`, []string{
+			"1:79 warning unused-let: len is bound but never used",
+			"1:79 warning shadowed-builtin: len shadows the builtin len",
+		}},
+		{"let len = fn(x) { x }; len(fn(puts) { puts });", []string{
+			"1:5 warning shadowed-builtin: len shadows the builtin len",
+			"1:31 warning shadowed-builtin: puts shadows the builtin puts",
+		}},
+		{"let f = fn() { return 1; puts(2); puts(3) }; f();", []string{"1:26 warning unreachable-code: unreachable code after the return statement at 1:16"}},
+		{"return 1;\nputs(2);", []string{"2:1 warning unreachable-code: unreachable code after the return statement at 1:1"}},
+		{"if (true) { return 1; }; 2;", nil},
+		{`let f = fn() { try { throw "a"; puts(1); } catch (e) { 1 } }; f();`, []string{"1:33 warning unreachable-code: unreachable code after the throw statement at 1:22"}},
+		{`try { 1 } catch (e) { 2 }; try { 1 } catch (_e) { 2 } finally { 3 };`, nil},
+		{`match (x) { 1 => 1, n if n > 1 => n, [a, ...r] => a, {"k": k} is HASH => k, _ => 0 }`, nil},
+		{`match (x) { n => n, 1 => 1 }; match (x) { [a, _] => a, [1, 2] => 2, [_, ...r] => r, [b] => b }`, []string{
+			"1:21 warning unreachable-match-arm: unreachable match arm, the arm at 1:13 matches all its values",
+			"1:56 warning unreachable-match-arm: unreachable match arm, the arm at 1:43 matches all its values",
+			"1:85 warning unreachable-match-arm: unreachable match arm, the arm at 1:69 matches all its values",
+		}},
+		{`match (x) { "a" => 1, _ is STRING => 2, "a" => 3, {"k": v, ...h} => v, {"k": 1, "j": 2} => 4, true is BOOLEAN => 5 }`, []string{
+			"1:41 warning unreachable-match-arm: unreachable match arm, the arm at 1:13 matches all its values",
+			"1:72 warning unreachable-match-arm: unreachable match arm, the arm at 1:51 matches all its values",
+		}},
+		{`let len = 1; match (len) { len => len }; let f = fn(v) { match (v) { puts => puts } }; f(1);`, []string{
+			"1:5 warning shadowed-builtin: len shadows the builtin len",
+			"1:28 warning shadowed-builtin: len shadows the builtin len",
+			"1:70 warning shadowed-builtin: puts shadows the builtin puts",
+		}},
+		{`5(1); "s"(); [1](); {}(); true(); fn(x) { x }(1);`, []string{
+			"1:1 error call-non-function: calling INTEGER 5, which is not a function",
+			`1:7 error call-non-function: calling STRING "s", which is not a function`,
+			"1:14 error call-non-function: calling ARRAY [1], which is not a function",
+			"1:21 error call-non-function: calling HASH {}, which is not a function",
+			"1:27 error call-non-function: calling BOOLEAN true, which is not a function",
+		}},
+		{"let a = [1]; a[0] == a[0]; a != a; 1 < 2; len(a) == len(a);", []string{
+			"1:14 warning self-comparison: ((a[0]) == (a[0])) compares a value with itself and is always true",
+			"1:28 warning self-comparison: (a != a) compares a value with itself and is always false",
+		}},
+		{"let [a, b] = p; let f = fn([x, len = 1], {y = x}) { y }; f([a]);", []string{
+			"1:9 warning unused-let: b is bound but never used",
+			"1:32 warning shadowed-builtin: len shadows the builtin len",
+		}},
+		{"let = 1;", []string{
+			"1:5 error syntax: expected next token to be IDENT. got==",
+			"1:5 error syntax: no prefix parse function for =",
+		}},
+	}
+	for _, tt := range tests {
+		check(t, New(), tt.source, tt.want...)
+	}
+}
+
+func TestSuppression(t *testing.T) {
+	source := `let a = 1; // check:ignore unused-let -- kept for later
+// check:ignore
+let len = 2;
+let b = 3; // check:ignore self-comparison, shadowed-builtin
+let c = 4; // check:ignored
+`
+	check(t, New(), source,
+		"4:5 warning unused-let: b is bound but never used",
+		"5:5 warning unused-let: c is bound but never used",
+	)
+	check(t, New(), "// check:ignore-file unused-let\nlet a = 1;\nlet b = 1;")
+}
+
+func TestSeverity(t *testing.T) {
+	l := New()
+	if err := l.Configure([]byte(`{"unused-let": "off", "self-comparison": "error"}`)); err != nil {
+		t.Fatalf("Configure failed: %s", err)
+	}
+	check(t, l, "let x = 1; 1 == 1;", "1:12 error self-comparison: (1 == 1) compares a value with itself and is always true")
+
+	if err := l.Configure([]byte(`{"no-such-rule": "off"}`)); err == nil || !strings.Contains(err.Error(), `unknown rule "no-such-rule"`) {
+		t.Errorf("wrong error for an unknown rule. got=%v", err)
+	}
+	if err := l.Configure([]byte(`{"unused-let": "loud"}`)); err == nil || !strings.Contains(err.Error(), `unknown severity "loud"`) {
+		t.Errorf("wrong error for an unknown severity. got=%v", err)
+	}
+}
+
+func TestCustomRule(t *testing.T) {
+	l := New()
+	l.Rules = append(l.Rules, &Rule{
+		Name:     "no-puts",
+		Severity: Info,
+		Check: func(pass *Pass) {
+			ast.Inspect(pass.Program, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Identifier); ok && ident.Value == "puts" {
+					pass.Report(ident.Pos(), "puts is for debugging")
+				}
+				return true
+			})
+		},
+	})
+	check(t, l, "puts(1);", "1:1 info no-puts: puts is for debugging")
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	diagnostics := New().Check("a.mk", "let x = 1;")
+	out, err := json.Marshal(diagnostics)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	want := `[{"file":"a.mk","line":1,"column":5,"rule":"unused-let","severity":"warning","message":"x is bound but never used"}]`
+	if string(out) != want {
+		t.Errorf("wrong JSON. got=%s, want=%s", out, want)
+	}
+	if got := diagnostics[0].String(); got != "a.mk:1:5: warning: x is bound but never used [unused-let]" {
+		t.Errorf("wrong String. got=%q", got)
+	}
+}
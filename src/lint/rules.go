@@ -0,0 +1,255 @@
+package lint
+
+import (
+	"strconv"
+	"strings"
+
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Rules returns the rules of the package. Linters take other rules by
+// appending them to these.
+func Rules() []*Rule {
+	return []*Rule{
+		{
+			Name:     "unused-let",
+			Doc:      "Let statements binding names that are never used.",
+			Severity: Warning,
+			Check:    unusedLet,
+		},
+		{
+			Name:     "shadowed-builtin",
+			Doc:      "Let statements and parameters binding the names of builtins, which hide them.",
+			Severity: Warning,
+			Check:    shadowedBuiltin,
+		},
+		{
+			Name:     "unreachable-code",
+			Doc:      "Statements after a return or throw statement in the same block, which never run.",
+			Severity: Warning,
+			Check:    unreachableCode,
+		},
+		{
+			Name:     "unreachable-match-arm",
+			Doc:      "Match arms whose values are all matched by an earlier arm without a guard, which never run.",
+			Severity: Warning,
+			Check:    unreachableMatchArm,
+		},
+		{
+			Name:     "call-non-function",
+			Doc:      "Calls of integers, strings, booleans, arrays and hashes written as literals.",
+			Severity: Error,
+			Check:    callNonFunction,
+		},
+		{
+			Name:     "self-comparison",
+			Doc:      "Comparisons of an expression with itself, whose result is always the same.",
+			Severity: Warning,
+			Check:    selfComparison,
+		},
+	}
+}
+
+// unusedLet reports let bindings without uses. Names starting with "_" are
+// meant to be unused, top-level names starting with "test_" are used by the
+// test runner, and exported names by the modules importing them.
+func unusedLet(pass *Pass) {
+	for _, b := range pass.Bindings {
+		name := b.Name.Value
+		switch {
+		case b.Let == nil || b.Let.Exported || len(b.Uses) != 0 || strings.HasPrefix(name, "_"):
+		case b.Function == nil && strings.HasPrefix(name, "test_"):
+		default:
+			pass.Report(b.Name.Pos(), "%s is bound but never used", name)
+		}
+	}
+}
+
+func shadowedBuiltin(pass *Pass) {
+	for _, b := range pass.Bindings {
+		if pass.Builtin(b.Name.Value) {
+			pass.Report(b.Name.Pos(), "%s shadows the builtin %s", b.Name.Value, b.Name.Value)
+		}
+	}
+}
+
+// unreachableCode reports the first statement after a return or throw
+// statement in the program or a block.
+func unreachableCode(pass *Pass) {
+	check := func(stmts []ast.Statement) {
+		for i, stmt := range stmts[:max(len(stmts)-1, 0)] {
+			switch stmt.(type) {
+			case *ast.ReturnStatement, *ast.ThrowStatement:
+				pass.Report(stmts[i+1].Pos(), "unreachable code after the %s statement at %s", stmt.TokenLiteral(), stmt.Pos())
+				return
+			}
+		}
+	}
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Program:
+			check(n.Statements)
+		case *ast.BlockStatement:
+			check(n.Statements)
+		}
+		return true
+	})
+}
+
+// unreachableMatchArm reports the arms of match expressions that an
+// earlier arm without a guard leaves no values to.
+func unreachableMatchArm(pass *Pass) {
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		match, ok := n.(*ast.MatchExpression)
+		if !ok {
+			return true
+		}
+		for i, arm := range match.Arms {
+			for _, earlier := range match.Arms[:i] {
+				if earlier.Guard == nil && covers(earlier.Pattern, arm.Pattern) {
+					pass.Report(arm.Pattern.Pos(), "unreachable match arm, the arm at %s matches all its values", earlier.Pattern.Pos())
+					break
+				}
+			}
+		}
+		return true
+	})
+}
+
+// covers reports whether every value matching b also matches a, as far as
+// can be told without evaluating anything.
+func covers(a, b ast.Pattern) bool {
+	if _, ok := a.(*ast.Identifier); ok {
+		return true
+	}
+	if a, ok := a.(*ast.TypePattern); ok {
+		if b, ok := b.(*ast.TypePattern); ok {
+			return a.Type.Value == b.Type.Value && covers(a.Pattern, b.Pattern)
+		}
+		return string(patternType(b)) == a.Type.Value && covers(a.Pattern, b)
+	}
+	if b, ok := b.(*ast.TypePattern); ok {
+		return covers(a, b.Pattern)
+	}
+
+	switch a := a.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return patternType(a) == patternType(b) && a.String() == b.String()
+
+	case *ast.ArrayPattern:
+		b, ok := b.(*ast.ArrayPattern)
+		if !ok || len(b.Elements) < len(a.Elements) {
+			return false
+		}
+		if a.Rest == nil && (b.Rest != nil || len(b.Elements) != len(a.Elements)) {
+			return false
+		}
+		for i, elem := range a.Elements {
+			if !covers(elem, b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.HashPattern:
+		b, ok := b.(*ast.HashPattern)
+		if !ok {
+			return false
+		}
+		for _, pair := range a.Pairs {
+			found := false
+			for _, other := range b.Pairs {
+				if patternType(pair.Key) == patternType(other.Key) && pair.Key.String() == other.Key.String() {
+					found = covers(pair.Value, other.Value)
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// patternType returns the type of the values pattern, or the literal key of
+// a hash pattern, matches, or "" if they may be of different types.
+func patternType(pattern ast.Node) object.ObjectType {
+	switch pattern := pattern.(type) {
+	case *ast.IntegerLiteral:
+		return object.INTEGER_OBJ
+	case *ast.StringLiteral:
+		return object.STRING_OBJ
+	case *ast.Boolean:
+		return object.BOOLEAN_OBJ
+	case *ast.ArrayPattern:
+		return object.ARRAY_OBJ
+	case *ast.HashPattern:
+		return object.HASH_OBJ
+	case *ast.TypePattern:
+		return object.ObjectType(pattern.Type.Value)
+	}
+	return ""
+}
+
+func callNonFunction(pass *Pass) {
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpression)
+		if !ok {
+			return true
+		}
+		var typ object.ObjectType
+		switch call.Function.(type) {
+		case *ast.IntegerLiteral:
+			typ = object.INTEGER_OBJ
+		case *ast.StringLiteral:
+			typ = object.STRING_OBJ
+		case *ast.Boolean:
+			typ = object.BOOLEAN_OBJ
+		case *ast.ArrayLiteral:
+			typ = object.ARRAY_OBJ
+		case *ast.HashLiteral:
+			typ = object.HASH_OBJ
+		default:
+			return true
+		}
+		literal := call.Function.String()
+		if s, ok := call.Function.(*ast.StringLiteral); ok {
+			literal = strconv.Quote(s.Value)
+		}
+		pass.Report(call.Pos(), "calling %s %s, which is not a function", typ, literal)
+		return true
+	})
+}
+
+// selfComparison reports comparisons whose operands are written the same
+// way and have no calls, which could have side effects or return different
+// values each time.
+func selfComparison(pass *Pass) {
+	results := map[string]bool{"==": true, "!=": false, "<": false, ">": false}
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		infix, ok := n.(*ast.InfixExpression)
+		if !ok {
+			return true
+		}
+		result, ok := results[infix.Operator]
+		if !ok || infix.Left.String() != infix.Right.String() || hasCall(infix.Left) {
+			return true
+		}
+		pass.Report(infix.Pos(), "%s compares a value with itself and is always %t", infix, result)
+		return true
+	})
+}
+
+func hasCall(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if _, ok := n.(*ast.CallExpression); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
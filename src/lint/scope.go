@@ -0,0 +1,248 @@
+package lint
+
+import (
+	"monkey/ast"
+	"monkey/token"
+)
+
+// Binding is a name bound by a let or import statement, a parameter, a
+// catch block or a match pattern, or destructured by a let statement or
+// parameter.
+type Binding struct {
+	Name     *ast.Identifier
+	Let      *ast.LetStatement // nil unless bound by a let statement
+	Function ast.Node          // the function or macro binding it, nil for the program
+
+	// Uses are the identifiers referring to the binding.
+	Uses []*ast.Identifier
+}
+
+// scope holds the names bound in the program or in a function or macro.
+// Blocks of if expressions share the scope they are in, as in the evaluator.
+type scope struct {
+	parent   *scope
+	function ast.Node
+	bindings map[string][]*Binding
+}
+
+// binder resolves the identifiers of a program, the way the language server
+// does.
+type binder struct {
+	bindings []*Binding
+	quoted   int
+}
+
+// bind returns the bindings of the names in program, scope by scope, with
+// their uses. Identifiers in quoted code, whose functions do not open
+// scopes of their own, use the bindings visible where they are quoted, as
+// they usually do where they are unquoted.
+func bind(program *ast.Program) []*Binding {
+	b := &binder{}
+	root := b.openScope(nil, nil)
+	b.declare(root, program)
+	b.node(program, root)
+	return b.bindings
+}
+
+func (b *binder) openScope(parent *scope, function ast.Node) *scope {
+	return &scope{parent: parent, function: function, bindings: map[string][]*Binding{}}
+}
+
+// declare binds the names of the let and import statements, catch blocks and
+// match patterns in the scope of node, in source order, but not those inside nested
+// functions or quoted code.
+func (b *binder) declare(s *scope, node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			for _, ident := range n.Bound() {
+				b.bind(s, &Binding{Name: ident, Let: n, Function: s.function})
+			}
+		case *ast.ImportStatement:
+			for _, ident := range n.Bound() {
+				b.bind(s, &Binding{Name: ident, Function: s.function})
+			}
+		case *ast.TryExpression:
+			if n.Param != nil {
+				b.bind(s, &Binding{Name: n.Param, Function: s.function})
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				for _, ident := range ast.PatternBindings(arm.Pattern) {
+					b.bind(s, &Binding{Name: ident, Function: s.function})
+				}
+			}
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return n == node
+		case *ast.CallExpression:
+			return !isQuoteCall(n)
+		}
+		return true
+	})
+}
+
+func (b *binder) bind(s *scope, binding *Binding) {
+	s.bindings[binding.Name.Value] = append(s.bindings[binding.Name.Value], binding)
+	b.bindings = append(b.bindings, binding)
+}
+
+// lookup returns the binding of name used at pos: the last one before pos in
+// the innermost scope binding name, or its first one if all come later.
+func (s *scope) lookup(name string, pos token.Position) *Binding {
+	for ; s != nil; s = s.parent {
+		bindings := s.bindings[name]
+		if len(bindings) == 0 {
+			continue
+		}
+		found := bindings[0]
+		for _, b := range bindings {
+			if !before(pos, b.Name.Pos()) {
+				found = b
+			}
+		}
+		return found
+	}
+	return nil
+}
+
+func (b *binder) node(node ast.Node, s *scope) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if binding := s.lookup(node.Value, node.Pos()); binding != nil {
+			binding.Uses = append(binding.Uses, node)
+		}
+
+	case *ast.LetStatement:
+		b.node(node.Value, s)
+		b.defaults(node.Pattern, s)
+
+	case *ast.FunctionLiteral:
+		b.function(node, node.Params, node.Patterns, node.Body, s)
+	case *ast.MacroLiteral:
+		b.function(node, node.Params, nil, node.Body, s)
+
+	case *ast.Program:
+		for _, stmt := range node.Statements {
+			b.node(stmt, s)
+		}
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			b.node(stmt, s)
+		}
+	case *ast.ReturnStatement:
+		b.node(node.ReturnValue, s)
+	case *ast.ThrowStatement:
+		b.node(node.Value, s)
+	case *ast.TryExpression:
+		b.node(node.Block, s)
+		if node.Catch != nil {
+			b.node(node.Catch, s)
+		}
+		if node.Finally != nil {
+			b.node(node.Finally, s)
+		}
+	case *ast.MatchExpression:
+		b.node(node.Value, s)
+		for _, arm := range node.Arms {
+			if arm.Guard != nil {
+				b.node(arm.Guard, s)
+			}
+			b.node(arm.Body, s)
+		}
+	case *ast.ExpressionStatement:
+		b.node(node.Expression, s)
+	case *ast.PrefixExpression:
+		b.node(node.Right, s)
+	case *ast.InfixExpression:
+		b.node(node.Left, s)
+		b.node(node.Right, s)
+	case *ast.IfExpression:
+		b.node(node.Condition, s)
+		b.node(node.Consequence, s)
+		if node.Alternative != nil {
+			b.node(node.Alternative, s)
+		}
+
+	case *ast.CallExpression:
+		switch {
+		case isQuoteCall(node):
+			b.quoted++
+			defer func() { b.quoted-- }()
+		case isUnquoteCall(node) && b.quoted > 0:
+			quoted := b.quoted
+			b.quoted = 0
+			defer func() { b.quoted = quoted }()
+		default:
+			b.node(node.Function, s)
+		}
+		for _, arg := range node.Arguments {
+			b.node(arg, s)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			b.node(el, s)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			b.node(pair.Key, s)
+			b.node(pair.Value, s)
+		}
+	case *ast.IndexExpression:
+		b.node(node.Left, s)
+		b.node(node.Index, s)
+	case *ast.SelectorExpression:
+		b.node(node.Left, s)
+	}
+}
+
+// defaults visits the default values of pattern, which may be nil.
+func (b *binder) defaults(pattern ast.Pattern, s *scope) {
+	ast.Inspect(pattern, func(n ast.Node) bool {
+		if dp, ok := n.(*ast.DefaultPattern); ok {
+			b.node(dp.Default, s)
+		}
+		_, ok := n.(ast.Pattern)
+		return ok
+	})
+}
+
+func (b *binder) function(fn ast.Node, params []*ast.Identifier, patterns []ast.Pattern, body *ast.BlockStatement, parent *scope) {
+	if b.quoted > 0 {
+		for _, pattern := range patterns {
+			b.defaults(pattern, parent)
+		}
+		b.node(body, parent)
+		return
+	}
+
+	s := b.openScope(parent, fn)
+	for i, param := range params {
+		if patterns == nil || patterns[i] == nil {
+			b.bind(s, &Binding{Name: param, Function: fn})
+			continue
+		}
+		for _, ident := range ast.PatternBindings(patterns[i]) {
+			b.bind(s, &Binding{Name: ident, Function: fn})
+		}
+	}
+	for _, pattern := range patterns {
+		b.defaults(pattern, s)
+	}
+	b.declare(s, body)
+	b.node(body, s)
+}
+
+func isQuoteCall(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	return ok && ident.Value == "quote" && len(node.Arguments) == 1
+}
+
+func isUnquoteCall(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	return ok && ident.Value == "unquote" && len(node.Arguments) == 1
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
@@ -0,0 +1,444 @@
+package lsp
+
+import (
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+)
+
+// document is an open file and what the server knows about it.
+type document struct {
+	uri     string
+	lines   []string
+	program *ast.Program
+
+	diagnostics []Diagnostic
+	scopes      []*scope    // the program first, then functions and macros
+	refs        []reference // every identifier, in source order
+}
+
+// scope holds the names bound in the program or in a function or macro, by
+// parameters, let and import statements, catch blocks and match patterns.
+// Blocks of if expressions share the scope they are in, as in the evaluator.
+type scope struct {
+	parent     *scope
+	start, end token.Position // of the function, unset for the program
+	bindings   map[string][]*binding
+}
+
+// binding is a name bound by a let or import statement, a parameter, a
+// catch block or a match pattern.
+type binding struct {
+	name     *ast.Identifier
+	let      *ast.LetStatement    // nil unless bound by a let statement
+	imported *ast.ImportStatement // nil unless bound by an import statement
+	caught   bool                 // bound by a catch block
+	matched  ast.Pattern          // nil unless bound by a match pattern
+	param    ast.Pattern          // the pattern destructuring the parameter binding it, if any
+	scope    *scope
+}
+
+// reference is an identifier and the binding it refers to, which is nil for
+// builtins and undefined names. Declaring identifiers refer to themselves.
+type reference struct {
+	ident   *ast.Identifier
+	binding *binding
+}
+
+// analyze parses text and resolves its identifiers. Names that are neither
+// bound in text nor defined by interpreter are reported as errors, like the
+// evaluator does before it runs a program.
+func analyze(uri, text string, interpreter *evaluator.Interpreter) *document {
+	d := &document{uri: uri, lines: strings.Split(text, "\n")}
+
+	p := parser.New(lexer.New(text))
+	d.program = p.ParseProgram()
+	for i, msg := range p.Errors() {
+		d.addDiagnostic(p.ErrorPositions()[i], 1, msg)
+	}
+
+	a := &analyzer{document: d}
+	root := a.openScope(nil, token.Position{}, token.Position{})
+	a.declare(root, d.program)
+	a.statements(d.program.Statements, root)
+
+	for _, ref := range d.refs {
+		if ref.binding == nil && !interpreter.Defines(ref.ident.Value) {
+			d.addDiagnostic(ref.ident.Pos(), len(ref.ident.Value), "identifier not found: "+ref.ident.Value)
+		}
+	}
+	return d
+}
+
+func (d *document) addDiagnostic(pos token.Position, length int, msg string) {
+	start := d.position(pos)
+	end := start
+	end.Character += length
+	d.diagnostics = append(d.diagnostics, Diagnostic{
+		Range:    Range{Start: start, End: end},
+		Severity: severityError,
+		Source:   "monkey",
+		Message:  msg,
+	})
+}
+
+type analyzer struct {
+	*document
+	quoted int // as in the resolver, quoted code is not resolved
+}
+
+func (a *analyzer) openScope(parent *scope, start, end token.Position) *scope {
+	s := &scope{parent: parent, start: start, end: end, bindings: map[string][]*binding{}}
+	a.scopes = append(a.scopes, s)
+	return s
+}
+
+// declare binds the names of the let and import statements, catch blocks and
+// match patterns in the scope of node, in source order, but not those inside nested
+// functions.
+func (a *analyzer) declare(s *scope, node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		if isNil(n) {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			for _, ident := range n.Bound() {
+				if ident != nil {
+					s.bind(&binding{name: ident, let: n, scope: s})
+				}
+			}
+		case *ast.ImportStatement:
+			for _, ident := range n.Bound() {
+				s.bind(&binding{name: ident, imported: n, scope: s})
+			}
+		case *ast.TryExpression:
+			if n.Param != nil {
+				s.bind(&binding{name: n.Param, caught: true, scope: s})
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				for _, ident := range ast.PatternBindings(arm.Pattern) {
+					s.bind(&binding{name: ident, matched: arm.Pattern, scope: s})
+				}
+			}
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return n == node
+		}
+		return true
+	})
+}
+
+func (s *scope) bind(b *binding) {
+	s.bindings[b.name.Value] = append(s.bindings[b.name.Value], b)
+}
+
+// lookup returns the binding of name used at pos: the last one before pos in
+// the innermost scope binding name, or its first one if all come later.
+func (s *scope) lookup(name string, pos token.Position) *binding {
+	for ; s != nil; s = s.parent {
+		bindings := s.bindings[name]
+		if len(bindings) == 0 {
+			continue
+		}
+		found := bindings[0]
+		for _, b := range bindings {
+			if !before(pos, b.name.Pos()) {
+				found = b
+			}
+		}
+		return found
+	}
+	return nil
+}
+
+func (a *analyzer) statements(stmts []ast.Statement, s *scope) {
+	for _, stmt := range stmts {
+		a.node(stmt, s)
+	}
+}
+
+func (a *analyzer) node(node ast.Node, s *scope) {
+	if isNil(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if a.quoted == 0 {
+			a.refs = append(a.refs, reference{ident: node, binding: s.lookup(node.Value, node.Pos())})
+		}
+
+	case *ast.LetStatement:
+		a.node(node.Value, s)
+		if node.Pattern != nil {
+			a.defaults(node.Pattern, s)
+		}
+		for _, ident := range node.Bound() {
+			if ident != nil && a.quoted == 0 {
+				a.refs = append(a.refs, reference{ident: ident, binding: s.lookup(ident.Value, ident.Pos())})
+			}
+		}
+
+	case *ast.ImportStatement:
+		for _, ident := range node.Bound() {
+			a.refs = append(a.refs, reference{ident: ident, binding: s.lookup(ident.Value, ident.Pos())})
+		}
+
+	case *ast.FunctionLiteral:
+		a.function(node.Token.Pos, node.Params, node.Patterns, node.Body, s)
+	case *ast.MacroLiteral:
+		a.function(node.Token.Pos, node.Params, nil, node.Body, s)
+
+	case *ast.ReturnStatement:
+		a.node(node.ReturnValue, s)
+	case *ast.ThrowStatement:
+		a.node(node.Value, s)
+	case *ast.TryExpression:
+		a.node(node.Block, s)
+		if node.Catch != nil {
+			a.node(node.Param, s)
+			a.node(node.Catch, s)
+		}
+		a.node(node.Finally, s)
+	case *ast.MatchExpression:
+		a.node(node.Value, s)
+		for _, arm := range node.Arms {
+			for _, ident := range ast.PatternBindings(arm.Pattern) {
+				a.node(ident, s)
+			}
+			a.node(arm.Guard, s)
+			a.node(arm.Body, s)
+		}
+	case *ast.ExpressionStatement:
+		a.node(node.Expression, s)
+	case *ast.BlockStatement:
+		a.statements(node.Statements, s)
+	case *ast.PrefixExpression:
+		a.node(node.Right, s)
+	case *ast.InfixExpression:
+		a.node(node.Left, s)
+		a.node(node.Right, s)
+	case *ast.IfExpression:
+		a.node(node.Condition, s)
+		a.node(node.Consequence, s)
+		a.node(node.Alternative, s)
+
+	case *ast.CallExpression:
+		switch {
+		case isQuoteCall(node):
+			a.quoted++
+			defer func() { a.quoted-- }()
+		case isUnquoteCall(node) && a.quoted > 0:
+			quoted := a.quoted
+			a.quoted = 0
+			defer func() { a.quoted = quoted }()
+		default:
+			a.node(node.Function, s)
+		}
+		for _, arg := range node.Arguments {
+			a.node(arg, s)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			a.node(el, s)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			a.node(pair.Key, s)
+			a.node(pair.Value, s)
+		}
+	case *ast.IndexExpression:
+		a.node(node.Left, s)
+		a.node(node.Index, s)
+	case *ast.SelectorExpression:
+		a.node(node.Left, s)
+	}
+}
+
+// defaults resolves the identifiers in the default values of pattern.
+func (a *analyzer) defaults(pattern ast.Pattern, s *scope) {
+	ast.Inspect(pattern, func(n ast.Node) bool {
+		if dp, ok := n.(*ast.DefaultPattern); ok {
+			a.node(dp.Default, s)
+		}
+		_, ok := n.(ast.Pattern)
+		return ok
+	})
+}
+
+func (a *analyzer) function(start token.Position, params []*ast.Identifier, patterns []ast.Pattern, body *ast.BlockStatement, parent *scope) {
+	if a.quoted > 0 {
+		for _, pattern := range patterns {
+			a.defaults(pattern, parent)
+		}
+		a.node(body, parent)
+		return
+	}
+
+	var end token.Position
+	if body != nil {
+		end = body.Rbrace.Pos
+	}
+	s := a.openScope(parent, start, end)
+	for i, param := range params {
+		if patterns == nil || patterns[i] == nil {
+			b := &binding{name: param, scope: s}
+			s.bind(b)
+			a.refs = append(a.refs, reference{ident: param, binding: b})
+			continue
+		}
+		for _, ident := range ast.PatternBindings(patterns[i]) {
+			b := &binding{name: ident, param: patterns[i], scope: s}
+			s.bind(b)
+			a.refs = append(a.refs, reference{ident: ident, binding: b})
+		}
+	}
+	for _, pattern := range patterns {
+		a.defaults(pattern, s)
+	}
+	if body != nil {
+		a.declare(s, body)
+		a.node(body, s)
+	}
+}
+
+func isQuoteCall(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	return ok && ident.Value == "quote" && len(node.Arguments) == 1
+}
+
+func isUnquoteCall(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	return ok && ident.Value == "unquote" && len(node.Arguments) == 1
+}
+
+// isNil reports whether node is missing, as parts of a program that failed
+// to parse are.
+func isNil(node ast.Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// referenceAt returns the reference to the identifier at pos.
+func (d *document) referenceAt(pos Position) (reference, bool) {
+	at := d.sourcePosition(pos)
+	for _, ref := range d.refs {
+		start := ref.ident.Pos()
+		if start.Line == at.Line && start.Column <= at.Column && at.Column <= start.Column+len(ref.ident.Value) {
+			return ref, true
+		}
+	}
+	return reference{}, false
+}
+
+// scopeAt returns the innermost scope around pos.
+func (d *document) scopeAt(pos Position) *scope {
+	at := d.sourcePosition(pos)
+	found := d.scopes[0]
+	for _, s := range d.scopes[1:] {
+		if before(s.start, at) && (!s.end.IsValid() || before(at, s.end)) {
+			found = s
+		}
+	}
+	return found
+}
+
+// identRange returns the range of an identifier.
+func (d *document) identRange(ident *ast.Identifier) Range {
+	start := d.position(ident.Pos())
+	end := d.position(token.Position{Line: ident.Pos().Line, Column: ident.Pos().Column + len(ident.Value)})
+	return Range{Start: start, End: end}
+}
+
+// nodeRange returns the range from the start of node to the end of its last
+// token, as far as the AST records tokens.
+func (d *document) nodeRange(node ast.Node) Range {
+	start := node.Pos()
+	end := start
+	extend := func(pos token.Position, length int) {
+		pos.Column += length
+		if before(end, pos) {
+			end = pos
+		}
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if isNil(n) {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.Identifier:
+			extend(n.Pos(), len(n.Value))
+		case *ast.IntegerLiteral, *ast.Boolean:
+			extend(n.Pos(), len(n.TokenLiteral()))
+		case *ast.StringLiteral:
+			extend(n.Pos(), len(n.Token.Literal)+2)
+		case *ast.BlockStatement:
+			extend(n.Rbrace.Pos, 1)
+		case *ast.CallExpression:
+			extend(n.Rparen.Pos, 1)
+		case *ast.ArrayLiteral:
+			extend(n.Rbracket.Pos, 1)
+		case *ast.HashLiteral:
+			extend(n.Rbrace.Pos, 1)
+		}
+		return true
+	})
+	return Range{Start: d.position(start), End: d.position(end)}
+}
+
+// position converts a position in the source, with columns in bytes from 1,
+// to an LSP position.
+func (d *document) position(pos token.Position) Position {
+	if !pos.IsValid() {
+		return Position{}
+	}
+	line := pos.Line - 1
+	if line >= len(d.lines) {
+		return Position{Line: line, Character: pos.Column - 1}
+	}
+	text := d.lines[line]
+	column := min(pos.Column-1, len(text))
+	character := 0
+	for _, r := range text[:column] {
+		character += utf16Len(r)
+	}
+	return Position{Line: line, Character: character + pos.Column - 1 - column}
+}
+
+// sourcePosition converts an LSP position to a position in the source.
+func (d *document) sourcePosition(pos Position) token.Position {
+	if pos.Line >= len(d.lines) {
+		return token.Position{Line: pos.Line + 1, Column: pos.Character + 1}
+	}
+	text := d.lines[pos.Line]
+	column, character := 0, 0
+	for column < len(text) && character < pos.Character {
+		r, size := utf8.DecodeRuneInString(text[column:])
+		column += size
+		character += utf16Len(r)
+	}
+	return token.Position{Line: pos.Line + 1, Column: column + 1}
+}
+
+// utf16Len returns the number of UTF-16 code units encoding r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
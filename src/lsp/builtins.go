@@ -0,0 +1,61 @@
+package lsp
+
+// builtinDocs describes the builtins of the evaluator for hover and
+// completion. Builtins added by embedders are not described.
+var builtinDocs = map[string]struct{ signature, doc string }{
+	"len":  {"len(value)", "Returns the number of bytes of a string, elements of an array or pairs of a hash."},
+	"puts": {"puts(values...)", "Prints each value on a line of its own and returns null."},
+
+	"split":       {"split(s, sep)", "Splits s around each occurrence of sep."},
+	"join":        {"join(strings, sep)", "Concatenates an array of strings, putting sep between them."},
+	"trim":        {"trim(s, cutset?)", "Removes leading and trailing white space, or the characters in cutset, from s."},
+	"upper":       {"upper(s)", "Returns s in upper case."},
+	"lower":       {"lower(s)", "Returns s in lower case."},
+	"replace":     {"replace(s, old, new)", "Replaces every occurrence of old in s by new."},
+	"contains":    {"contains(s, substr)", "Reports whether substr is in s."},
+	"starts_with": {"starts_with(s, prefix)", "Reports whether s begins with prefix."},
+	"ends_with":   {"ends_with(s, suffix)", "Reports whether s ends with suffix."},
+	"index_of":    {"index_of(s, substr)", "Returns the index of the first occurrence of substr in s, or -1."},
+	"repeat":      {"repeat(s, count)", "Returns s repeated count times."},
+	"substr":      {"substr(s, start, length?)", "Returns the part of s from start, of at most length characters."},
+
+	"type":      {"type(value)", "Returns the type of value, such as \"INTEGER\"."},
+	"int":       {"int(value)", "Converts a string or boolean to an integer."},
+	"str":       {"str(value)", "Converts value to a string."},
+	"bool":      {"bool(value)", "Reports whether value is truthy."},
+	"is_int":    {"is_int(value)", "Reports whether value is an integer."},
+	"is_string": {"is_string(value)", "Reports whether value is a string."},
+	"is_bool":   {"is_bool(value)", "Reports whether value is a boolean."},
+	"is_array":  {"is_array(value)", "Reports whether value is an array."},
+	"is_null":   {"is_null(value)", "Reports whether value is null."},
+	"is_hash":   {"is_hash(value)", "Reports whether value is a hash."},
+	"is_fn":     {"is_fn(value)", "Reports whether value is a function or a builtin."},
+
+	"json_parse":     {"json_parse(s)", "Parses the JSON in s."},
+	"json_stringify": {"json_stringify(value, indent?)", "Encodes value as JSON, indented by indent spaces if given."},
+
+	"path_join": {"path_join(parts...)", "Joins path elements with slashes."},
+	"path_base": {"path_base(path)", "Returns the last element of path."},
+	"path_dir":  {"path_dir(path)", "Returns all but the last element of path."},
+	"path_ext":  {"path_ext(path)", "Returns the file name extension of path."},
+
+	"read_file":   {"read_file(path)", "Returns the contents of a file."},
+	"write_file":  {"write_file(path, contents)", "Writes contents to a file, replacing it."},
+	"append_file": {"append_file(path, contents)", "Appends contents to a file."},
+	"list_dir":    {"list_dir(path)", "Returns the names of the entries of a directory."},
+	"exists":      {"exists(path)", "Reports whether a file exists."},
+}
+
+func builtinSignature(name string) string {
+	if doc, ok := builtinDocs[name]; ok {
+		return doc.signature
+	}
+	return name + "(...)"
+}
+
+func builtinDoc(name string) string {
+	if doc, ok := builtinDocs[name]; ok {
+		return doc.doc
+	}
+	return "builtin function"
+}
@@ -0,0 +1,435 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"monkey/evaluator"
+)
+
+// session runs a server on the given messages, the requests among them
+// numbered from 1 in order, and returns the responses by ID and the
+// notifications the server sent.
+func session(t *testing.T, messages ...map[string]any) (map[int]*message, []*message, error) {
+	t.Helper()
+
+	var in bytes.Buffer
+	id := 0
+	for _, msg := range messages {
+		msg["jsonrpc"] = "2.0"
+		if _, ok := msg["notification"]; ok {
+			delete(msg, "notification")
+		} else if msg["method"] != "exit" {
+			id++
+			msg["id"] = id
+		}
+		body, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	var out bytes.Buffer
+	err := NewServer(&in, &out, evaluator.New()).Serve()
+
+	responses := map[int]*message{}
+	var notifications []*message
+	r := bufio.NewReader(&out)
+	for {
+		msg, readErr := readMessage(r)
+		if readErr != nil {
+			break
+		}
+		if msg.ID == nil {
+			notifications = append(notifications, msg)
+			continue
+		}
+		var n int
+		json.Unmarshal(msg.ID, &n)
+		responses[n] = msg
+	}
+	return responses, notifications, err
+}
+
+func request(method string, params any) map[string]any {
+	return map[string]any{"method": method, "params": params}
+}
+
+func notification(method string, params any) map[string]any {
+	return map[string]any{"method": method, "params": params, "notification": true}
+}
+
+const uri = "file:///test.mk"
+
+func open(text string) map[string]any {
+	return notification("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "monkey", "version": 1, "text": text},
+	})
+}
+
+func at(method string, line, character int) map[string]any {
+	return request(method, map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	})
+}
+
+func lifecycle(messages ...map[string]any) []map[string]any {
+	all := []map[string]any{request("initialize", map[string]any{}), notification("initialized", map[string]any{})}
+	all = append(all, messages...)
+	return append(all, request("shutdown", nil), map[string]any{"method": "exit"})
+}
+
+func result[T any](t *testing.T, responses map[int]*message, id int) T {
+	t.Helper()
+	var v T
+	msg, ok := responses[id]
+	if !ok {
+		t.Fatalf("no response to request %d", id)
+	}
+	if msg.Error != nil {
+		t.Fatalf("request %d failed: %s", id, msg.Error.Message)
+	}
+	if err := json.Unmarshal(msg.Result, &v); err != nil {
+		t.Fatalf("request %d: %s", id, err)
+	}
+	return v
+}
+
+const source = `let seconds = 60 * 60;
+let twice = fn(f, x) {
+    let y = f(x);
+    f(y)
+};
+twice(fn(n) { n * seconds }, len("é"));
+`
+
+func TestLifecycle(t *testing.T) {
+	responses, _, err := session(t, lifecycle()...)
+	if err != nil {
+		t.Fatalf("Serve() failed: %s", err)
+	}
+
+	init := result[initializeResult](t, responses, 1)
+	if !init.Capabilities.HoverProvider || init.Capabilities.TextDocumentSync != syncFull {
+		t.Errorf("wrong capabilities: %+v", init.Capabilities)
+	}
+	if string(responses[2].Result) != "null" {
+		t.Errorf("wrong shutdown result: %s", responses[2].Result)
+	}
+
+	_, _, err = session(t, request("hover", nil), map[string]any{"method": "exit"})
+	if err != errExitWithoutShutdown {
+		t.Errorf("wrong error for exit without shutdown. got=%v", err)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	responses, _, _ := session(t,
+		at("textDocument/hover", 0, 0),
+		request("initialize", map[string]any{}),
+		request("textDocument/unknown", nil),
+		request("textDocument/hover", "not an object"),
+		request("shutdown", nil),
+		map[string]any{"method": "exit"},
+	)
+
+	tests := []struct {
+		id   int
+		code int
+	}{
+		{1, codeServerNotInitialized},
+		{3, codeMethodNotFound},
+		{4, codeInvalidParams},
+	}
+	for _, tt := range tests {
+		msg := responses[tt.id]
+		if msg == nil || msg.Error == nil || msg.Error.Code != tt.code {
+			t.Errorf("request %d: expected error %d. got=%+v", tt.id, tt.code, msg)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	change := notification("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": "let x = 1;\nx + 1"}},
+	})
+	_, notifications, _ := session(t, lifecycle(open("let x = ;\nputs(y, \"é\" + z)"), change)...)
+
+	if len(notifications) != 2 {
+		t.Fatalf("expected 2 notifications. got=%d", len(notifications))
+	}
+
+	var params publishDiagnosticsParams
+	json.Unmarshal(notifications[0].Params, &params)
+	expected := []struct {
+		line, start, end int
+		message          string
+	}{
+		{0, 8, 9, "no prefix parse function for ;"},
+		{1, 5, 6, "identifier not found: y"},
+		{1, 14, 15, "identifier not found: z"},
+	}
+	if params.URI != uri || len(params.Diagnostics) != len(expected) {
+		t.Fatalf("wrong diagnostics: %+v", params)
+	}
+	for i, want := range expected {
+		got := params.Diagnostics[i]
+		if got.Message != want.message || got.Range.Start.Line != want.line ||
+			got.Range.Start.Character != want.start || got.Range.End.Character != want.end {
+			t.Errorf("diagnostics[%d] wrong. want=%+v, got=%+v", i, want, got)
+		}
+	}
+
+	params = publishDiagnosticsParams{}
+	json.Unmarshal(notifications[1].Params, &params)
+	if params.Diagnostics == nil || len(params.Diagnostics) != 0 {
+		t.Errorf("expected diagnostics to be cleared. got=%+v", params.Diagnostics)
+	}
+}
+
+func TestHover(t *testing.T) {
+	responses, _, _ := session(t, lifecycle(
+		open(source),
+		at("textDocument/hover", 5, 20), // seconds
+		at("textDocument/hover", 5, 1),  // twice
+		at("textDocument/hover", 3, 6),  // y
+		at("textDocument/hover", 2, 12), // f
+		at("textDocument/hover", 5, 30), // len
+		at("textDocument/hover", 0, 18), // 60
+	)...)
+
+	tests := []struct {
+		id       int
+		expected string
+	}{
+		{2, "```monkey\nlet seconds = (60 * 60)\n```"},
+		{3, "```monkey\nlet twice = fn(f, x)\n```"},
+		{4, "```monkey\nlet y = f(x)\n```"},
+		{5, "```monkey\nf\n```\nparameter"},
+		{6, "```monkey\nlen(value)\n```\nReturns the number of bytes of a string, elements of an array or pairs of a hash."},
+	}
+	for _, tt := range tests {
+		hover := result[*Hover](t, responses, tt.id)
+		if hover == nil || hover.Contents.Value != tt.expected {
+			t.Errorf("hover %d wrong. want=%q, got=%+v", tt.id, tt.expected, hover)
+		}
+	}
+
+	if hover := result[*Hover](t, responses, 7); hover != nil {
+		t.Errorf("expected no hover on a literal. got=%+v", hover)
+	}
+}
+
+func TestHoverImportsCatchAndMatch(t *testing.T) {
+	responses, notifications, _ := session(t, lifecycle(
+		open("import \"lib.mk\" as lib;\nimport { a, b as c } from \"x.mk\";\nlib.f(c)\ntry { a } catch (e) { e }\nmatch (a) { [x, ...r] if x => r }"),
+		at("textDocument/hover", 2, 1),  // lib
+		at("textDocument/hover", 2, 6),  // c
+		at("textDocument/hover", 2, 4),  // f
+		at("textDocument/hover", 3, 22), // e
+		at("textDocument/hover", 4, 31), // r
+	)...)
+
+	tests := []struct {
+		id       int
+		expected string
+	}{
+		{2, "```monkey\nimport \"lib.mk\" as lib;\n```"},
+		{3, "```monkey\nimport { a, b as c } from \"x.mk\";\n```"},
+		{5, "```monkey\ne\n```\ncaught error"},
+		{6, "```monkey\nr\n```\nmatched by [x, ...r]"},
+	}
+	for _, tt := range tests {
+		hover := result[*Hover](t, responses, tt.id)
+		if hover == nil || hover.Contents.Value != tt.expected {
+			t.Errorf("hover %d wrong. want=%q, got=%+v", tt.id, tt.expected, hover)
+		}
+	}
+	if hover := result[*Hover](t, responses, 4); hover != nil {
+		t.Errorf("expected no hover on a selector. got=%+v", hover)
+	}
+
+	var params publishDiagnosticsParams
+	json.Unmarshal(notifications[0].Params, &params)
+	if len(params.Diagnostics) != 0 {
+		t.Errorf("expected no diagnostics. got=%+v", params.Diagnostics)
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	responses, notifications, _ := session(t, lifecycle(
+		open("let [a, {\"k\": b = a}] = [1, {}];\nlet f = fn([x, y = a], z) { x + y + z + b };\nf([1], 2)"),
+		at("textDocument/hover", 0, 5),       // a
+		at("textDocument/hover", 1, 12),      // x
+		at("textDocument/definition", 1, 40), // b
+		at("textDocument/definition", 1, 19), // a in the default
+		request("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}),
+	)...)
+
+	hovers := []struct {
+		id       int
+		expected string
+	}{
+		{2, "```monkey\nlet [a, {k:b = a}] = [1, {}]\n```"},
+		{3, "```monkey\nx\n```\nparameter, destructured by [x, y = a]"},
+	}
+	for _, tt := range hovers {
+		hover := result[*Hover](t, responses, tt.id)
+		if hover == nil || hover.Contents.Value != tt.expected {
+			t.Errorf("hover %d wrong. want=%q, got=%+v", tt.id, tt.expected, hover)
+		}
+	}
+
+	definitions := []struct {
+		id              int
+		line, character int
+	}{
+		{4, 0, 14},
+		{5, 0, 5},
+	}
+	for _, tt := range definitions {
+		loc := result[*Location](t, responses, tt.id)
+		if loc == nil || loc.Range.Start != (Position{tt.line, tt.character}) {
+			t.Errorf("definition %d wrong. want=%d:%d, got=%+v", tt.id, tt.line, tt.character, loc)
+		}
+	}
+
+	var names []string
+	for _, symbol := range result[[]DocumentSymbol](t, responses, 6) {
+		names = append(names, symbol.Name)
+	}
+	if strings.Join(names, " ") != "a b f" {
+		t.Errorf("wrong symbols. want=[a b f], got=%q", names)
+	}
+
+	var params publishDiagnosticsParams
+	json.Unmarshal(notifications[0].Params, &params)
+	if len(params.Diagnostics) != 0 {
+		t.Errorf("expected no diagnostics. got=%+v", params.Diagnostics)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	responses, _, _ := session(t, lifecycle(
+		open(source),
+		at("textDocument/definition", 5, 21), // seconds
+		at("textDocument/definition", 3, 4),  // f in f(y)
+		at("textDocument/definition", 5, 14), // n
+		at("textDocument/definition", 5, 30), // len
+	)...)
+
+	tests := []struct {
+		id              int
+		line, character int
+	}{
+		{2, 0, 4},
+		{3, 1, 15},
+		{4, 5, 9},
+	}
+	for _, tt := range tests {
+		loc := result[*Location](t, responses, tt.id)
+		if loc == nil || loc.URI != uri || loc.Range.Start != (Position{tt.line, tt.character}) {
+			t.Errorf("definition %d wrong. want=%d:%d, got=%+v", tt.id, tt.line, tt.character, loc)
+		}
+	}
+	if loc := result[*Location](t, responses, 5); loc != nil {
+		t.Errorf("expected no definition for a builtin. got=%+v", loc)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	responses, _, _ := session(t, lifecycle(
+		open(source),
+		request("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}),
+	)...)
+
+	symbols := result[[]DocumentSymbol](t, responses, 2)
+	expected := []DocumentSymbol{
+		{
+			Name: "seconds", Detail: "(60 * 60)", Kind: symbolVariable,
+			Range:          Range{Position{0, 0}, Position{0, 21}},
+			SelectionRange: Range{Position{0, 4}, Position{0, 11}},
+		},
+		{
+			Name: "twice", Detail: "fn(f, x)", Kind: symbolFunction,
+			Range:          Range{Position{1, 0}, Position{4, 1}},
+			SelectionRange: Range{Position{1, 4}, Position{1, 9}},
+		},
+	}
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. got=%+v", symbols)
+	}
+	for i, want := range expected {
+		if symbols[i] != want {
+			t.Errorf("symbols[%d] wrong.\nwant=%+v\ngot= %+v", i, want, symbols[i])
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	responses, _, _ := session(t, lifecycle(
+		open(source),
+		at("textDocument/completion", 3, 4), // in twice
+		at("textDocument/completion", 6, 0), // at the end
+	)...)
+
+	labels := func(items []CompletionItem) string {
+		var names []string
+		for _, item := range items {
+			if item.Label == "y" || item.Label == "f" || item.Label == "x" || item.Label == "twice" || item.Label == "seconds" || item.Label == "len" || item.Label == "puts" {
+				names = append(names, fmt.Sprintf("%s:%d", item.Label, item.Kind))
+			}
+		}
+		return strings.Join(names, " ")
+	}
+
+	if got := labels(result[[]CompletionItem](t, responses, 2)); got != "f:6 len:3 puts:3 seconds:6 twice:3 x:6 y:6" {
+		t.Errorf("wrong completion in function. got=%q", got)
+	}
+	if got := labels(result[[]CompletionItem](t, responses, 3)); got != "len:3 puts:3 seconds:6 twice:3" {
+		t.Errorf("wrong completion at top level. got=%q", got)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	doc := analyze(uri, "let s = \"😀é\"; s", evaluator.New())
+	ref, ok := doc.referenceAt(Position{Line: 0, Character: 15})
+	if !ok || ref.ident.Value != "s" || ref.binding == nil {
+		t.Fatalf("wrong reference. got=%+v", ref)
+	}
+	if r := doc.identRange(ref.ident); r.Start.Character != 15 || r.End.Character != 16 {
+		t.Errorf("wrong range. got=%+v", r)
+	}
+}
+
+func TestIncompleteSource(t *testing.T) {
+	inputs := []string{
+		"let = 5; let x",
+		"fn(x { x }",
+		"if (x { 1 } else",
+		"let f = fn(a, b) { a +",
+		"[1, 2",
+		`{"a": }`,
+		"quote(unquote(",
+	}
+	for _, input := range inputs {
+		doc := analyze(uri, input, evaluator.New())
+		if len(doc.diagnostics) == 0 {
+			t.Errorf("expected diagnostics for %q", input)
+		}
+		symbols(doc)
+		for line := range doc.lines {
+			for character := 0; character < 20; character++ {
+				doc.referenceAt(Position{line, character})
+				doc.scopeAt(Position{line, character})
+			}
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package lsp implements a Language Server Protocol server for Monkey. It
+// publishes parse errors and undefined names as diagnostics and answers
+// requests for hover information, definitions, document symbols and
+// completion, analyzing the whole text of a document on every change.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"monkey/ast"
+	"monkey/evaluator"
+)
+
+// Server is a language server for the clients on one connection.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interpreter *evaluator.Interpreter
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a server reading messages from in and writing them to
+// out. Names are resolved against the builtins of interpreter.
+func NewServer(in io.Reader, out io.Writer, interpreter *evaluator.Interpreter) *Server {
+	return &Server{
+		in:          bufio.NewReader(in),
+		out:         out,
+		interpreter: interpreter,
+		docs:        map[string]*document{},
+	}
+}
+
+// errExitWithoutShutdown is returned by Serve if the client asks the server
+// to exit without shutting it down first.
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Serve handles messages until the client asks the server to exit or closes
+// the connection. It returns nil if the server was shut down first.
+func (s *Server) Serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		var rpcErr *responseError
+		if errors.As(err, &rpcErr) {
+			s.write(&message{ID: json.RawMessage("null"), Error: rpcErr})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+		if msg.ID == nil {
+			s.notification(msg)
+			continue
+		}
+
+		result, err := s.request(msg)
+		response := &message{ID: msg.ID}
+		if err != nil {
+			if !errors.As(err, &rpcErr) {
+				rpcErr = &responseError{Code: codeInternalError, Message: err.Error()}
+			}
+			response.Error = rpcErr
+		} else if response.Result, err = json.Marshal(result); err != nil {
+			return err
+		}
+		if err := s.write(response); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) write(msg *message) error {
+	return writeMessage(s.out, msg)
+}
+
+// request handles a request and returns its result. A panic while handling
+// it is returned as an error, so a bug does not take the server down.
+func (s *Server) request(msg *message) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", msg.Method, r)
+		}
+	}()
+
+	if msg.Method == "initialize" {
+		s.initialized = true
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:       syncFull,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: serverInfo{Name: "monkey"},
+		}, nil
+	}
+	if !s.initialized {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+
+	switch msg.Method {
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return nil, err
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			return s.hover(doc, params.Position), nil
+		}
+		return nil, nil
+
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return nil, err
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			return definition(doc, params.Position), nil
+		}
+		return nil, nil
+
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return nil, err
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			return symbols(doc), nil
+		}
+		return []DocumentSymbol{}, nil
+
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return nil, err
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			return s.completion(doc, params.Position), nil
+		}
+		return []CompletionItem{}, nil
+	}
+
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// notification handles a notification. Unknown ones are ignored, as the
+// protocol asks.
+func (s *Server) notification(msg *message) {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if unmarshalParams(msg, &params) == nil {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if unmarshalParams(msg, &params) == nil && len(params.ContentChanges) > 0 {
+			// with full sync, the last change holds the whole text
+			s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if unmarshalParams(msg, &params) == nil {
+			delete(s.docs, params.TextDocument.URI)
+			s.publishDiagnostics(params.TextDocument.URI, []Diagnostic{})
+		}
+	}
+}
+
+func unmarshalParams(msg *message, params any) error {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// update analyzes the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) {
+	doc := analyze(uri, text, s.interpreter)
+	s.docs[uri] = doc
+
+	diagnostics := doc.diagnostics
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	s.publishDiagnostics(uri, diagnostics)
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []Diagnostic) {
+	params, _ := json.Marshal(publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	s.write(&message{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// hover describes the identifier at pos: the let or import statement,
+// function, catch block or match pattern that binds it, or what the builtin
+// it names does.
+func (s *Server) hover(doc *document, pos Position) *Hover {
+	ref, ok := doc.referenceAt(pos)
+	if !ok {
+		return nil
+	}
+
+	var text string
+	switch b := ref.binding; {
+	case b != nil && b.let != nil && b.let.Pattern != nil:
+		text = "```monkey\nlet " + b.let.Pattern.String() + " = " + summary(b.let.Value) + "\n```"
+	case b != nil && b.let != nil:
+		text = "```monkey\nlet " + b.name.Value + " = " + summary(b.let.Value) + "\n```"
+	case b != nil && b.imported != nil:
+		text = "```monkey\n" + b.imported.String() + "\n```"
+	case b != nil && b.caught:
+		text = "```monkey\n" + b.name.Value + "\n```\ncaught error"
+	case b != nil && b.matched != nil:
+		text = "```monkey\n" + b.name.Value + "\n```\nmatched by " + b.matched.String()
+	case b != nil && b.param != nil:
+		text = "```monkey\n" + b.name.Value + "\n```\nparameter, destructured by " + b.param.String()
+	case b != nil:
+		text = "```monkey\n" + b.name.Value + "\n```\nparameter"
+	case s.interpreter.Defines(ref.ident.Value):
+		text = "```monkey\n" + builtinSignature(ref.ident.Value) + "\n```\n" + builtinDoc(ref.ident.Value)
+	default:
+		return nil
+	}
+	return &Hover{
+		Contents: markupContent{Kind: "markdown", Value: text},
+		Range:    doc.identRange(ref.ident),
+	}
+}
+
+// summary returns the source of a bound value, or only the parameters of a
+// function, since its body can be long.
+func summary(value ast.Expression) string {
+	if isNil(value) {
+		return "?"
+	}
+	if fn, ok := value.(*ast.FunctionLiteral); ok {
+		return "fn(" + joinIdentifiers(fn.Params) + ")"
+	}
+	if macro, ok := value.(*ast.MacroLiteral); ok {
+		return "macro(" + joinIdentifiers(macro.Params) + ")"
+	}
+	return value.String()
+}
+
+func joinIdentifiers(idents []*ast.Identifier) string {
+	names := make([]string, len(idents))
+	for i, ident := range idents {
+		names[i] = ident.Value
+	}
+	return strings.Join(names, ", ")
+}
+
+// definition returns where the identifier at pos is bound, if it is bound in
+// the document.
+func definition(doc *document, pos Position) *Location {
+	ref, ok := doc.referenceAt(pos)
+	if !ok || ref.binding == nil {
+		return nil
+	}
+	return &Location{URI: doc.uri, Range: doc.identRange(ref.binding.name)}
+}
+
+// symbols returns the names bound by the top-level let statements of a
+// document.
+func symbols(doc *document) []DocumentSymbol {
+	result := []DocumentSymbol{}
+	for _, stmt := range doc.program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || isNil(let) {
+			continue
+		}
+		for _, ident := range let.Bound() {
+			if ident == nil {
+				continue
+			}
+			symbol := DocumentSymbol{
+				Name:           ident.Value,
+				Kind:           symbolVariable,
+				Range:          doc.nodeRange(let),
+				SelectionRange: doc.identRange(ident),
+			}
+			if !isNil(let.Value) {
+				symbol.Detail = summary(let.Value)
+				if _, ok := let.Value.(*ast.FunctionLiteral); ok && let.Pattern == nil {
+					symbol.Kind = symbolFunction
+				}
+			}
+			result = append(result, symbol)
+		}
+	}
+	return result
+}
+
+// completion returns the names visible at pos: those bound in the scopes
+// around it and the builtins.
+func (s *Server) completion(doc *document, pos Position) []CompletionItem {
+	seen := map[string]bool{}
+	items := []CompletionItem{}
+	for sc := doc.scopeAt(pos); sc != nil; sc = sc.parent {
+		for name, bindings := range sc.bindings {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			b := bindings[len(bindings)-1]
+			item := CompletionItem{Label: name, Kind: completionVariable, Detail: "parameter"}
+			if b.let != nil {
+				item.Detail = summary(b.let.Value)
+				if _, ok := b.let.Value.(*ast.FunctionLiteral); ok {
+					item.Kind = completionFunction
+				}
+			}
+			items = append(items, item)
+		}
+	}
+	for _, name := range s.interpreter.Names() {
+		if !seen[name] {
+			items = append(items, CompletionItem{Label: name, Kind: completionFunction, Detail: builtinSignature(name)})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
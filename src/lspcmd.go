@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"monkey/evaluator"
+	"monkey/lsp"
+)
+
+// runLSP implements `monkey lsp`, which runs a language server speaking the
+// Language Server Protocol over standard input and output.
+func runLSP(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey lsp\n")
+	}
+	flags.Parse(args)
+
+	server := lsp.NewServer(os.Stdin, os.Stdout, evaluator.New())
+	if err := server.Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "monkey lsp: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -1,13 +1,112 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"monkey/repl"
+	"io"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
+
+	"monkey/coverage"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/profiler"
+	"monkey/repl"
 )
 
+// rootsFlag collects the directories passed with repeated -fs-root or
+// -module-path flags.
+type rootsFlag []string
+
+func (r *rootsFlag) String() string     { return strings.Join(*r, ",") }
+func (r *rootsFlag) Set(v string) error { *r = append(*r, v); return nil }
+
+// commands are the subcommands of monkey, called with the arguments after
+// their name. They return the process exit code.
+var commands = map[string]func(args []string) int{
+	"ast":   runAST,
+	"check": runCheck,
+	"fmt":   runFmt,
+	"debug": runDebug,
+	"lsp":   runLSP,
+	"test":  runTest,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	var roots rootsFlag
+	flag.Var(&roots, "fs-root", "directory scripts may access (repeatable, defaults to the current directory)")
+	readOnly := flag.Bool("fs-readonly", false, "only allow scripts to read files")
+	noFS := flag.Bool("no-fs", false, "disable file system access for scripts")
+	var modulePaths rootsFlag
+	flag.Var(&modulePaths, "module-path", "directory to search for imported modules (repeatable, defaults to the script's directory)")
+	profile := flag.String("profile", "", "write a pprof profile of the script's functions to `file`")
+	profileTable := flag.Bool("profile-table", false, "print a table of the time spent in the script's functions")
+	cover := flag.Bool("cover", false, "print the percentage of the script's statements and branches evaluated")
+	coverProfile := flag.String("coverprofile", "", "write the script's coverage in the LCOV format to `file`")
+	coverListing := flag.String("cover-listing", "", "write the script's source annotated with its coverage to `file`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: monkey [flags] [file]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       monkey fmt [-w | -check] [files]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       monkey ast [-json] file\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       monkey lsp\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       monkey debug [-dap] [file]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       monkey test [-v] [-run regexp] [-junit file] [paths]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       monkey check [-json] [-config file] [-severity rule=level] [files]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var opts []evaluator.Option
+	if !*noFS {
+		if len(roots) == 0 {
+			roots = rootsFlag{"."}
+		}
+		opts = append(opts, evaluator.WithFileAccess(evaluator.FileAccess{Roots: roots, ReadOnly: *readOnly}))
+	}
+	if len(modulePaths) == 0 {
+		modulePaths = rootsFlag{"."}
+		if flag.NArg() > 0 {
+			modulePaths = rootsFlag{filepath.Dir(flag.Arg(0))}
+		}
+	}
+	opts = append(opts, evaluator.WithModulePaths(modulePaths...))
+	var prof *profiler.Profiler
+	if *profile != "" || *profileTable {
+		prof = profiler.New()
+		opts = append(opts, evaluator.WithTracer(prof))
+	}
+	var cov *coverage.Coverage
+	if *cover || *coverProfile != "" || *coverListing != "" {
+		if prof != nil {
+			fmt.Fprintln(os.Stderr, "monkey: cannot profile a script and measure its coverage at once")
+			os.Exit(2)
+		}
+		cov = coverage.New()
+		opts = append(opts, evaluator.WithTracer(cov))
+	}
+	interpreter := evaluator.New(opts...)
+
+	if flag.NArg() > 0 {
+		code := runFile(interpreter, flag.Arg(0), cov)
+		if prof != nil && !writeProfile(prof, *profile, *profileTable, flag.Arg(0)) {
+			code = 1
+		}
+		if cov != nil && !writeCoverage(cov, *cover, *coverProfile, *coverListing) {
+			code = 1
+		}
+		os.Exit(code)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,5 +114,94 @@ func main() {
 
 	fmt.Printf("Hello %s! This is the Monkey programming language! \n", user.Username)
 	fmt.Println("Feel free to type in commands")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.StartWith(os.Stdin, os.Stdout, interpreter)
+}
+
+// writeProfile writes what prof recorded while evaluating the script at path,
+// as a pprof profile to file if it is not empty, and as a table to stderr if
+// table is true. It reports whether it succeeded.
+func writeProfile(prof *profiler.Profiler, file string, table bool, path string) bool {
+	if table {
+		prof.WriteTable(os.Stderr)
+	}
+	return writeFile(file, func(w io.Writer) error { return prof.WritePprof(w, path) })
+}
+
+// writeCoverage writes what cov recorded: a summary to stderr if summary is
+// true, and LCOV records and an annotated listing to the files lcov and
+// listing if they are not empty. It reports whether it succeeded.
+func writeCoverage(cov *coverage.Coverage, summary bool, lcov, listing string) bool {
+	if summary {
+		cov.WriteSummary(os.Stderr)
+	}
+	return writeFile(lcov, cov.WriteLCOV) && writeFile(listing, cov.WriteListing)
+}
+
+// writeFile creates the file name, unless name is empty, and writes it with
+// write. It reports whether it succeeded.
+func writeFile(name string, write func(io.Writer) error) bool {
+	if name == "" {
+		return true
+	}
+	out, err := os.Create(name)
+	if err == nil {
+		err = write(out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return true
+}
+
+// runFile evaluates the script at path and returns the process exit code.
+// If cov is not nil, the script's coverage is recorded in it.
+func runFile(interpreter *evaluator.Interpreter, path string, cov *coverage.Coverage) int {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return 1
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := interpreter.ExpandMacros(program, macroEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	env := object.NewEnvironment()
+	if errors := interpreter.Resolve(expanded, env); len(errors) != 0 {
+		for _, msg := range errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return 1
+	}
+
+	// coverage is recorded for the nodes of the program as written, which
+	// the optimizer would replace
+	if cov != nil {
+		cov.Add(path, string(source), expanded)
+	} else {
+		expanded = evaluator.Optimize(expanded)
+	}
+	evaluated := interpreter.Eval(expanded, env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, errObj.Message)
+		return 1
+	}
+	return 0
 }
@@ -13,20 +13,98 @@ func NewEnvironment() *Environment {
 	}
 }
 
+// NewSlotEnvironment creates an environment for a function call whose local
+// variables have been assigned slots by the resolver. names holds the name of
+// each slot and is shared by all calls of the function.
+func NewSlotEnvironment(outer *Environment, names []string) *Environment {
+	return &Environment{
+		slots: make([]Object, len(names)),
+		names: names,
+		outer: outer,
+	}
+}
+
+// Environment binds names to values. Bindings live either in slots, which
+// resolved identifiers access by index, or in store, which is keyed by name.
 type Environment struct {
 	store map[string]Object
+	slots []Object
+	names []string
 	outer *Environment
 }
 
+// Get looks name up in this environment and then in the enclosing ones.
+// Slots that have not been assigned yet are skipped.
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+	if obj, ok := e.store[name]; ok {
+		return obj, true
+	}
+	for i, n := range e.names {
+		if n == name && e.slots[i] != nil {
+			return e.slots[i], true
+		}
+	}
+	if e.outer != nil {
+		return e.outer.Get(name)
 	}
-	return obj, ok
+	return nil, false
+}
+
+// Names returns the names bound in this environment, but not in the
+// enclosing ones, in no particular order.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store)+len(e.names))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	for i, name := range e.names {
+		if e.slots[i] != nil {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+	for i, n := range e.names {
+		if n == name {
+			e.slots[i] = val
+			return val
+		}
+	}
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
+
+// Outer returns the enclosing environment, or nil for the outermost one.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Ancestor returns the environment depth levels above this one, or the
+// outermost one if there are fewer levels.
+func (e *Environment) Ancestor(depth int) *Environment {
+	env := e
+	for ; depth > 0 && env.outer != nil; depth-- {
+		env = env.outer
+	}
+	return env
+}
+
+// GetSlot returns the value in slot of the environment depth levels up, or
+// nil if it has not been assigned.
+func (e *Environment) GetSlot(depth, slot int) Object {
+	env := e.Ancestor(depth)
+	if slot >= len(env.slots) {
+		return nil
+	}
+	return env.slots[slot]
+}
+
+func (e *Environment) SetSlot(slot int, val Object) Object {
+	e.slots[slot] = val
+	return val
+}
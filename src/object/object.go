@@ -3,9 +3,12 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"strings"
 
 	"monkey/ast"
+	"monkey/token"
 )
 
 type ObjectType string
@@ -20,8 +23,22 @@ const (
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
+	MODULE_OBJ       = "MODULE"
 )
 
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by objects that can be used as hash keys.
+type Hashable interface {
+	HashKey() HashKey
+}
+
 type Object interface {
 	Type() ObjectType
 	Inspect() string
@@ -33,6 +50,9 @@ type Integer struct {
 
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
 
 type Boolean struct {
 	Value bool
@@ -40,6 +60,19 @@ type Boolean struct {
 
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
 
 type Null struct{}
 
@@ -53,8 +86,13 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// Error is a failure that stops evaluation, unless a try expression catches
+// it.
 type Error struct {
 	Message string
+	Value   Object   // thrown by a throw statement, nil for other errors
+	Stack   []string // the calls being made when it was raised, innermost first
+	Fatal   bool     // not caught by try expressions, as when a limit is exceeded
 }
 
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
@@ -62,8 +100,12 @@ func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
 type Function struct {
 	Parameters []*ast.Identifier
+	Patterns   []ast.Pattern // destructuring the parameters, as in ast.FunctionLiteral
+	File       string        // path of the module defining the function, empty for the program
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Locals     []string       // slot names of a resolved function, nil otherwise
+	Pos        token.Position // of the function literal
 }
 
 func (f *Function) Inspect() string {
@@ -91,6 +133,11 @@ type String struct {
 
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
 
 type (
 	BuiltinFunction func(args ...Object) Object
@@ -121,3 +168,84 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+// SortedPairs returns the pairs of the hash ordered by the Inspect output of
+// their keys, so callers can iterate over a hash deterministically.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+	})
+	return pairs
+}
+
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range h.SortedPairs() {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+// Quote is an unevaluated piece of code, as returned by quote.
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Inspect() string  { return "QUOTE(" + q.Node.String() + ")" }
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+
+// Module is a module loaded by an import statement, holding the values of
+// its exported let statements.
+type Module struct {
+	Name    string // the path it was first imported as
+	Exports map[string]Object
+}
+
+func (m *Module) Inspect() string  { return fmt.Sprintf("module %q", m.Name) }
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
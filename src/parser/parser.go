@@ -33,11 +33,23 @@ var precedences = map[token.TokenType]int{
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
+}
+
+// Precedence returns the precedence of the infix operator t, or LOWEST if t
+// is not one.
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+	return LOWEST
 }
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l        *lexer.Lexer
+	errors   []string
+	errorPos []token.Position
+	comments []token.Token
 
 	curToken  token.Token
 	peekToken token.Token
@@ -62,8 +74,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -75,6 +91,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseSelectorExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 
@@ -88,9 +105,30 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ErrorPositions returns where in the source each of the errors returned by
+// Errors was found, in the same order.
+func (p *Parser) ErrorPositions() []token.Position {
+	return p.errorPos
+}
+
+func (p *Parser) addError(pos token.Position, msg string) {
+	p.errors = append(p.errors, msg)
+	p.errorPos = append(p.errorPos, pos)
+}
+
+// Comments returns the comments read so far, in source order. They are not
+// part of the AST.
+func (p *Parser) Comments() []token.Token {
+	return p.comments
+}
+
 func (p *Parser) NextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, p.peekToken)
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -112,6 +150,19 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.EXPORT:
+		if !p.expectPeek(token.LET) {
+			return nil
+		}
+		stmt := p.parseLetStatement()
+		if stmt != nil {
+			stmt.Exported = true
+		}
+		return stmt
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -120,18 +171,115 @@ func (p *Parser) parseStatement() ast.Statement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.NextToken()
+		if stmt.Pattern = p.parsePattern(true); stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		stmt.Name = &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		}
+	}
+
+	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{
-		Token: p.curToken,
-		Value: p.curToken.Literal,
+	p.NextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
 	}
 
-	if !p.expectPeek(token.ASSIGN) {
+	return stmt
+}
+
+// parseImportStatement parses the forms
+//
+//	import "path";
+//	import "path" as name;
+//	import { name, name as alias } from "path";
+//
+// in which as and from are identifiers, not keywords.
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.NextToken()
+		stmt.Names = []*ast.ImportName{}
+		for !p.peekTokenIs(token.RBRACE) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			name := &ast.ImportName{Name: p.identifier()}
+			if p.peekWordIs("as") {
+				p.NextToken()
+				if !p.expectPeek(token.IDENT) {
+					return nil
+				}
+				name.Alias = p.identifier()
+			}
+			stmt.Names = append(stmt.Names, name)
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+		}
+		p.NextToken()
+		if !p.expectWord("from") {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.STRING) {
 		return nil
 	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	if stmt.Names == nil && p.peekWordIs("as") {
+		p.NextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Alias = p.identifier()
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) identifier() *ast.Identifier {
+	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+}
+
+// peekWordIs reports whether the next token is the identifier word.
+func (p *Parser) peekWordIs(word string) bool {
+	return p.peekTokenIs(token.IDENT) && p.peekToken.Literal == word
+}
+
+// expectWord is expectPeek for the identifier word.
+func (p *Parser) expectWord(word string) bool {
+	if p.peekWordIs(word) {
+		p.NextToken()
+		return true
+	}
+	msg := fmt.Sprintf("expected next token to be %q. got=%s", word, p.peekToken.Type)
+	p.addError(p.peekToken.Pos, msg)
+	return false
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
 
 	p.NextToken()
 
@@ -185,7 +333,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken.Pos, msg)
 		return nil
 	}
 	il.Value = value
@@ -324,6 +472,260 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
+// parseTryExpression parses try { } catch (name) { } finally { }, in which
+// either the catch or the finally block may be left out.
+func (p *Parser) parseTryExpression() ast.Expression {
+	exp := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	exp.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.NextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		exp.Param = p.identifier()
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.NextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Finally = p.parseBlockStatement()
+	}
+
+	if exp.Catch == nil && exp.Finally == nil {
+		p.addError(p.peekToken.Pos, "expected catch or finally after try block")
+		return nil
+	}
+	return exp
+}
+
+// ****************************************//
+
+// ****** Parsing Match Expressions ******//
+// e.g. match (x) { [a, ...rest] if a > 0 => rest, _ => [] }
+func (p *Parser) parseMatchExpression() ast.Expression {
+	exp := &ast.MatchExpression{Token: p.curToken, Arms: []*ast.MatchArm{}}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.NextToken()
+	exp.Value = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.NextToken()
+		arm := &ast.MatchArm{Pattern: p.parsePattern(false)}
+		if arm.Pattern == nil {
+			return nil
+		}
+
+		if p.peekTokenIs(token.IF) {
+			p.NextToken()
+			p.NextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		p.NextToken()
+		arm.Body = p.parseExpression(LOWEST)
+		exp.Arms = append(exp.Arms, arm)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	exp.Rbrace = p.curToken
+
+	return exp
+}
+
+// parsePattern parses the pattern starting at the current token, followed
+// by "is TYPE" if it is a type pattern. The elements of array and hash
+// patterns in it can have default values if defaults is true.
+func (p *Parser) parsePattern(defaults bool) ast.Pattern {
+	var pattern ast.Pattern
+
+	switch p.curToken.Type {
+	case token.IDENT:
+		pattern = p.identifier()
+	case token.INT:
+		if lit, ok := p.parseIntegerLiteral().(*ast.IntegerLiteral); ok {
+			pattern = lit
+		}
+	case token.MINUS:
+		minus := p.curToken
+		if !p.expectPeek(token.INT) {
+			return nil
+		}
+		// the literal is parsed with its sign so that the smallest integer
+		// does not overflow
+		p.curToken = token.Token{Type: token.INT, Literal: "-" + p.curToken.Literal, Pos: minus.Pos}
+		if lit, ok := p.parseIntegerLiteral().(*ast.IntegerLiteral); ok {
+			pattern = lit
+		}
+	case token.STRING:
+		pattern = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	case token.TRUE, token.FALSE:
+		pattern = &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+	case token.LBRACKET:
+		pattern = p.parseArrayPattern(defaults)
+	case token.LBRACE:
+		pattern = p.parseHashPattern(defaults)
+	default:
+		msg := fmt.Sprintf("expected a pattern. got=%s", p.curToken.Type)
+		p.addError(p.curToken.Pos, msg)
+	}
+	if pattern == nil {
+		return nil
+	}
+
+	if p.peekWordIs("is") {
+		p.NextToken()
+		tp := &ast.TypePattern{Token: p.curToken, Pattern: pattern}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		tp.Type = p.identifier()
+		pattern = tp
+	}
+	return pattern
+}
+
+// e.g. [first, _, second = 2, ...rest]
+func (p *Parser) parseArrayPattern(defaults bool) ast.Pattern {
+	array := &ast.ArrayPattern{Token: p.curToken, Elements: []ast.Pattern{}}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.NextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if array.Rest = p.parseRest(token.RBRACKET); array.Rest == nil {
+				return nil
+			}
+			break
+		}
+
+		elem := p.parseDefault(p.parsePattern(defaults), defaults)
+		if elem == nil {
+			return nil
+		}
+		array.Elements = append(array.Elements, elem)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	array.Rbracket = p.curToken
+
+	return array
+}
+
+// e.g. {"name": name, age, "tags": tags = [], ...rest}, where age is short
+// for "age": age
+func (p *Parser) parseHashPattern(defaults bool) ast.Pattern {
+	hash := &ast.HashPattern{Token: p.curToken, Pairs: []ast.HashPatternPair{}}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.NextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if hash.Rest = p.parseRest(token.RBRACE); hash.Rest == nil {
+				return nil
+			}
+			break
+		}
+
+		var pair ast.HashPatternPair
+		switch p.curToken.Type {
+		case token.IDENT:
+			pair.Key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+			pair.Value = p.identifier()
+		case token.STRING, token.INT, token.TRUE, token.FALSE:
+			pair.Key = p.prefixParseFns[p.curToken.Type]()
+			if pair.Key == nil || !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.NextToken()
+			pair.Value = p.parsePattern(defaults)
+		default:
+			msg := fmt.Sprintf("expected a hash pattern key. got=%s", p.curToken.Type)
+			p.addError(p.curToken.Pos, msg)
+			return nil
+		}
+		if pair.Value = p.parseDefault(pair.Value, defaults); pair.Value == nil {
+			return nil
+		}
+		hash.Pairs = append(hash.Pairs, pair)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hash.Rbrace = p.curToken
+
+	return hash
+}
+
+// parseDefault parses the default value of the element pattern of an array
+// or hash pattern, if it has one.
+func (p *Parser) parseDefault(pattern ast.Pattern, defaults bool) ast.Pattern {
+	if pattern == nil || !p.peekTokenIs(token.ASSIGN) {
+		return pattern
+	}
+	p.NextToken()
+	if !defaults {
+		p.addError(p.curToken.Pos, "default values are only allowed in let statements and parameters")
+		return nil
+	}
+
+	dp := &ast.DefaultPattern{Token: p.curToken, Pattern: pattern}
+	p.NextToken()
+	if dp.Default = p.parseExpression(LOWEST); dp.Default == nil {
+		return nil
+	}
+	return dp
+}
+
+// parseRest parses the identifier after "...", which must be the last
+// element of a pattern closed by end.
+func (p *Parser) parseRest(end token.TokenType) *ast.Identifier {
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	rest := p.identifier()
+	if !p.peekTokenIs(end) {
+		msg := fmt.Sprintf("the rest element must come last. got=%s", p.peekToken.Type)
+		p.addError(p.peekToken.Pos, msg)
+		return nil
+	}
+	return rest
+}
+
 // ****************************************//
 
 // ****** Parsing Block statements ******//
@@ -338,6 +740,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		block.Statements = append(block.Statements, stmt)
 		p.NextToken()
 	}
+	block.Rbrace = p.curToken
 
 	return block
 }
@@ -354,7 +757,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	f.Params = p.parseFunctionParameters()
+	f.Params, f.Patterns = p.parseFunctionParameters()
 
 	// check '{' before body block
 	if !p.expectPeek(token.LBRACE) {
@@ -365,31 +768,73 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return f
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	m := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	params, patterns := p.parseFunctionParameters()
+	if patterns != nil {
+		p.addError(m.Token.Pos, "macro parameters cannot be destructured")
+		return nil
+	}
+	m.Params = params
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	m.Body = p.parseBlockStatement()
+	return m
+}
+
+// parseFunctionParameters parses the parameters of a function, and the
+// patterns destructuring them, as FunctionLiteral holds them.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Pattern) {
 	params := []*ast.Identifier{}
+	var patterns []ast.Pattern
 
 	// check if there are no params
 	if p.peekTokenIs(token.RPAREN) {
 		p.NextToken()
-		return params
+		return params, nil
 	}
 
 	// skip '('
 	p.NextToken()
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	params = append(params, ident)
-	for p.peekTokenIs(token.COMMA) {
+	for {
+		if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+			pattern := p.parsePattern(true)
+			if pattern == nil {
+				return nil, nil
+			}
+			if patterns == nil {
+				patterns = make([]ast.Pattern, len(params))
+			}
+			patterns = append(patterns, pattern)
+			params = append(params, ast.ParameterOf(pattern))
+		} else {
+			ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			params = append(params, ident)
+			if patterns != nil {
+				patterns = append(patterns, nil)
+			}
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.NextToken() // go to comma
 		p.NextToken() // skip comma
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		params = append(params, ident)
 	}
 
 	// make sure it ends with ')'
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
 	}
-	return params
+	return params, patterns
 }
 
 // ****************************************//
@@ -398,6 +843,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -407,6 +853,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
 	return array
 }
 
@@ -428,6 +875,54 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 
 // ****************************************//
 
+// ****** Parsing Selector Expressions ******//
+func (p *Parser) parseSelectorExpression(left ast.Expression) ast.Expression {
+	exp := &ast.SelectorExpression{Token: p.curToken, Left: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Selector = p.identifier()
+
+	return exp
+}
+
+// ****************************************//
+
+// ****** Parsing Hash Literals ******//
+// e.g. {"one": 1, two: 2}
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken}
+	hash.Pairs = []ast.HashPair{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.NextToken()
+		key := p.parseExpression(LOWEST)
+
+		// check ':' between key and value
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.NextToken()
+		value := p.parseExpression(LOWEST)
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hash.Rbrace = p.curToken
+
+	return hash
+}
+
+// ****************************************//
+
 // ****** Utility Methods ******//
 func (p *Parser) curTokenIs(t token.TokenType) bool {
 	return p.curToken.Type == t
@@ -449,7 +944,7 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s. got=%s", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken.Pos, msg)
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -468,7 +963,7 @@ func (p *Parser) curPrecedence() int {
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken.Pos, msg)
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
@@ -2,6 +2,8 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"monkey/ast"
@@ -493,6 +495,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"-lib.add(1) * a.b[0].c",
+			"((-lib.add(1)) * (a.b[0]).c)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -676,6 +682,46 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, sumExp.Expression, "x", "+", "y")
 }
 
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected program.Statements[0] to be an *ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("expression is not a ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(macro.Params) != 2 {
+		t.Fatalf("expected 2 params. got=%d", len(macro.Params))
+	}
+
+	testLiteralExpression(t, macro.Params[0], "x")
+	testLiteralExpression(t, macro.Params[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("expected 1 statement in macro body. got=%d", len(macro.Body.Statements))
+	}
+
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected body stmt to be an *ast.ExpressionStatement. got=%T", macro.Body.Statements[0])
+	}
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -868,3 +914,370 @@ func TestParsingIndexExpressions(t *testing.T) {
 		return
 	}
 }
+
+func TestParsingSelectorExpressions(t *testing.T) {
+	p := New(lexer.New("lib.add"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	expr, ok := stmt.Expression.(*ast.SelectorExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.SelectorExpression, got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, expr.Left, "lib") || !testIdentifier(t, expr.Selector, "add") {
+		return
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		param    string
+		finally  bool
+	}{
+		{`try { f(x) } catch (err) { err["message"] }`, `tryf(x)catch(err)(err[message])`, "err", false},
+		{`try { throw "oops"; } finally { puts(1) }`, `trythrow oops;finallyputs(1)`, "", true},
+		{`try { 1 } catch (e) { 2 } finally { 3 }`, `try1catch(e)2finally3`, "e", true},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+		}
+		if got := exp.String(); got != tt.expected {
+			t.Errorf("wrong String. expected=%q, got=%q", tt.expected, got)
+		}
+		if tt.param != "" && !testIdentifier(t, exp.Param, tt.param) {
+			return
+		}
+		if (tt.param == "") != (exp.Catch == nil) || tt.finally != (exp.Finally != nil) {
+			t.Errorf("wrong blocks of %q. catch=%v, finally=%v", tt.input, exp.Catch != nil, exp.Finally != nil)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		bound    [][]string
+	}{
+		{`match (x) { 1 => "one", -2 => "minus two", "s" => s, true => t, _ => 0 }`, `match(x){1 => one, -2 => minus two, s => s, true => t, _ => 0}`, [][]string{nil, nil, nil, nil, nil}},
+		{`match (f(x)) { n if n > 0 => n, n => -n, }`, `match(f(x)){n if (n > 0) => n, n => (-n)}`, [][]string{{"n"}, {"n"}}},
+		{`match (xs) { [] => 0, [x] => x, [x, _, ...rest] => rest }`, `match(xs){[] => 0, [x] => x, [x, _, ...rest] => rest}`, [][]string{nil, {"x"}, {"x", "rest"}}},
+		{`match (h) { {"a": [a], b, 1: true, ...rest} => a }`, `match(h){{a:[a], b, 1:true, ...rest} => a}`, [][]string{{"a", "b", "rest"}}},
+		{`match (v) { n is INTEGER => n, [_ is STRING] is ARRAY => 1 }`, `match(v){n is INTEGER => n, [_ is STRING] is ARRAY => 1}`, [][]string{{"n"}, nil}},
+		{`match (v) {}`, `match(v){}`, nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.MatchExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T", stmt.Expression)
+		}
+		if got := exp.String(); got != tt.expected {
+			t.Errorf("wrong String. expected=%q, got=%q", tt.expected, got)
+		}
+		if len(exp.Arms) != len(tt.bound) {
+			t.Fatalf("wrong number of arms. expected=%d, got=%d", len(tt.bound), len(exp.Arms))
+		}
+		for i, arm := range exp.Arms {
+			var bound []string
+			for _, ident := range ast.PatternBindings(arm.Pattern) {
+				bound = append(bound, ident.Value)
+			}
+			if !reflect.DeepEqual(bound, tt.bound[i]) {
+				t.Errorf("arms[%d] of %q binds wrong names. expected=%q, got=%q", i, tt.input, tt.bound[i], bound)
+			}
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		bound    []string
+	}{
+		{`let [a, b] = pair;`, `let [a, b] = pair;`, []string{"a", "b"}},
+		{`let [_, [b, c = 1], ...rest] = xs;`, `let [_, [b, c = 1], ...rest] = xs;`, []string{"b", "c", "rest"}},
+		{`let {"name": n, age = 18 + 1, ...others} = person;`, `let {name:n, age = (18 + 1), ...others} = person;`, []string{"n", "age", "others"}},
+		{`let {"p": [x, y] = [0, 0]} = h;`, `let {p:[x, y] = [0, 0]} = h;`, []string{"x", "y"}},
+		{`fn(a, [b, c], {d = 1}) { b }`, `fn(a, [b, c], {d = 1})b`, []string{"b", "c", "d"}},
+		{`fn(a, b) { a }`, `fn(a, b)a`, nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("wrong String. expected=%q, got=%q", tt.expected, got)
+		}
+
+		var bound []string
+		switch stmt := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			if stmt.Name != nil {
+				t.Errorf("stmt.Name is not nil for %q. got=%s", tt.input, stmt.Name)
+			}
+			for _, ident := range stmt.Bound() {
+				bound = append(bound, ident.Value)
+			}
+		case *ast.ExpressionStatement:
+			fn := stmt.Expression.(*ast.FunctionLiteral)
+			if tt.bound == nil && fn.Patterns != nil {
+				t.Errorf("fn.Patterns is not nil for %q. got=%v", tt.input, fn.Patterns)
+			}
+			for i, pattern := range fn.Patterns {
+				if pattern == nil {
+					continue
+				}
+				if fn.Params[i].Value != pattern.String() {
+					t.Errorf("fn.Params[%d] is not named by its pattern. got=%q", i, fn.Params[i].Value)
+				}
+				for _, ident := range ast.PatternBindings(pattern) {
+					bound = append(bound, ident.Value)
+				}
+			}
+		}
+		if !reflect.DeepEqual(bound, tt.bound) {
+			t.Errorf("%q binds wrong names. expected=%q, got=%q", tt.input, tt.bound, bound)
+		}
+	}
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { [a = 1] => a }`, "default values are only allowed in let statements and parameters"},
+		{`macro([a]) { a }`, "macro parameters cannot be destructured"},
+		{`let [a, ...b, c] = x;`, "the rest element must come last. got=,"},
+		{`let [a] x;`, "expected next token to be =. got=IDENT"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong errors for %q. expected=%q first, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestImportAndExportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		bound    []string
+	}{
+		{`import "lib.mk";`, `import "lib.mk";`, nil},
+		{`import "lib/math.mk" as math`, `import "lib/math.mk" as math;`, []string{"math"}},
+		{`import { add, sub as minus } from "math.mk";`, `import { add, sub as minus } from "math.mk";`, []string{"add", "minus"}},
+		{`import {} from "math.mk";`, `import {  } from "math.mk";`, []string{}},
+		{`export let x = 1;`, `export let x = 1;`, nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+		if stmt, ok := program.Statements[0].(*ast.ImportStatement); ok {
+			var bound []string
+			for _, ident := range stmt.Bound() {
+				bound = append(bound, ident.Value)
+			}
+			if len(bound) != len(tt.bound) || strings.Join(bound, " ") != strings.Join(tt.bound, " ") {
+				t.Errorf("wrong bound names of %q. got=%q, want=%q", tt.input, bound, tt.bound)
+			}
+		}
+	}
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	input := `{"one": 1, "two": 2 * 2, three: 3}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	if key, ok := hash.Pairs[0].Key.(*ast.StringLiteral); !ok || key.Value != "one" {
+		t.Errorf("first key is not StringLiteral \"one\". got=%s", hash.Pairs[0].Key)
+	}
+	testIntegerLiteral(t, hash.Pairs[0].Value, 1)
+	testInfixExpression(t, hash.Pairs[1].Value, 2, "*", 2)
+	testIdentifier(t, hash.Pairs[2].Key, "three")
+	testIntegerLiteral(t, hash.Pairs[2].Value, 3)
+}
+
+func TestParsingEmptyHashLiteral(t *testing.T) {
+	l := lexer.New("{}")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 0 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+}
+
+func TestCommentsAndPositions(t *testing.T) {
+	input := `// leading
+let f = fn(x) { // opening
+    x + 1
+};
+f(
+  [1, 2] // list
+)[0]`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	comments := []string{}
+	for _, c := range p.Comments() {
+		comments = append(comments, fmt.Sprintf("%s %s", c.Pos, c.Literal))
+	}
+	expected := []string{"1:1 // leading", "2:17 // opening", "6:10 // list"}
+	if strings.Join(comments, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong comments. want=%v, got=%v", expected, comments)
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	function := let.Value.(*ast.FunctionLiteral)
+	if let.Pos().String() != "2:1" || function.Body.Rbrace.Pos.String() != "4:1" {
+		t.Errorf("wrong let positions. got=%s, rbrace=%s", let.Pos(), function.Body.Rbrace.Pos)
+	}
+	sum := function.Body.Statements[0].(*ast.ExpressionStatement).Expression
+	if sum.Pos().String() != "3:5" {
+		t.Errorf("infix expression should start at its left operand. got=%s", sum.Pos())
+	}
+
+	index := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	call := index.Left.(*ast.CallExpression)
+	array := call.Arguments[0].(*ast.ArrayLiteral)
+	if index.Pos().String() != "5:1" || call.Rparen.Pos.String() != "7:1" || array.Rbracket.Pos.String() != "6:8" {
+		t.Errorf("wrong call positions. index=%s, rparen=%s, rbracket=%s", index.Pos(), call.Rparen.Pos, array.Rbracket.Pos)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	input := `let add = fn(a, b) { return a + b; };
+let m = macro(x) { quote(unquote(x) * 2) };
+if (add(1, 2) > -3) { [true, "s\n"][0] } else { {"k": !false}["k"] };
+add(1, 2) * 3;
+import { a, b as c } from "lib.mk";
+import "x.mk" as x;
+export let y = x.z;
+try { throw "a"; } catch (e) { e } finally { 1 };
+try { 1 } finally { 2 };
+match (add(1, 2)) { 3 => "three", [a, -1, ...r] if a => r, {"k": v is STRING, n, ...r} => v, _ => false };
+let [p, [q = 1], ...s] = [1, [], 2];
+let {"k": k = "none", l, ...o} = {};
+let f = fn(a, {b = a}, [c]) { a };
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	data, err := ast.EncodeJSON(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ast.DecodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, program) {
+		t.Errorf("decoded program differs.\nwant=%s\ngot= %s", program.String(), decoded.String())
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := `let x 5;
+let = 10;
+let y = ;
+99999999999999999999;
+import { a } "x";
+export x;
+try { 1 };
+match (x) { [a, ...b`
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	expected := []struct {
+		msg string
+		pos string
+	}{
+		{"expected next token to be =. got=INT", "1:7"},
+		{"expected next token to be IDENT. got==", "2:5"},
+		{"no prefix parse function for =", "2:5"},
+		{"no prefix parse function for ;", "3:9"},
+		{`could not parse "99999999999999999999" as integer`, "4:1"},
+		{`expected next token to be "from". got=STRING`, "5:14"},
+		{"expected next token to be LET. got=IDENT", "6:8"},
+		{"expected catch or finally after try block", "7:10"},
+		{"the rest element must come last. got=EOF", "8:21"},
+	}
+	if len(p.Errors()) != len(expected) || len(p.ErrorPositions()) != len(expected) {
+		t.Fatalf("wrong number of errors. got=%q at %v", p.Errors(), p.ErrorPositions())
+	}
+	for i, want := range expected {
+		if p.Errors()[i] != want.msg || p.ErrorPositions()[i].String() != want.pos {
+			t.Errorf("errors[%d] wrong. want=%q at %s, got=%q at %s", i, want.msg, want.pos, p.Errors()[i], p.ErrorPositions()[i])
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"time"
+)
+
+// WritePprof writes the calls as a gzipped profile in the protocol buffer
+// format of pprof, with the source file named file. Its samples are the call
+// stacks with the number of calls, their exclusive time and their exclusive
+// allocations, so pprof derives the inclusive figures from them.
+//
+// See https://github.com/google/pprof/blob/main/proto/profile.proto for the
+// format.
+func (p *Profiler) WritePprof(w io.Writer, file string) error {
+	var b protobuf
+	index := map[string]int64{} // of strings in the string table
+	str := func(s string) int64 {
+		i, ok := index[s]
+		if !ok {
+			i = int64(len(index))
+			index[s] = i
+		}
+		return i
+	}
+	str("") // the string table starts with the empty string
+
+	valueType := func(field int, typ, unit string) {
+		var v protobuf
+		v.int(1, str(typ))
+		v.int(2, str(unit))
+		b.message(field, &v)
+	}
+	valueType(1, "calls", "count")
+	valueType(1, "time", "nanoseconds")
+	valueType(1, "allocations", "count")
+
+	// Functions and their locations have the same IDs, from 1 and in the
+	// order of Functions.
+	ids := map[*Function]uint64{}
+	for _, f := range p.Functions() {
+		ids[p.functions[literal{f.File, f.Pos}]] = uint64(len(ids) + 1)
+	}
+
+	samples := make([]*sample, 0, len(p.samples))
+	for _, s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].time > samples[j].time })
+	for _, s := range samples {
+		var m protobuf
+		locations := make([]uint64, len(s.stack))
+		for i, f := range s.stack {
+			locations[i] = ids[f]
+		}
+		m.packed(1, locations)
+		m.packed(2, []uint64{uint64(s.calls), uint64(s.time), s.allocs})
+		b.message(2, &m)
+	}
+
+	for _, f := range p.Functions() {
+		id := ids[p.functions[literal{f.File, f.Pos}]]
+		var line, location protobuf
+		line.uint(1, id)
+		line.int(2, int64(f.Pos.Line))
+		location.uint(1, id)
+		location.message(4, &line)
+		b.message(4, &location)
+	}
+	for _, f := range p.Functions() {
+		var m protobuf
+		m.uint(1, ids[p.functions[literal{f.File, f.Pos}]])
+		// with the position, since names are often the same, like "fn"
+		name := f.Name + " (" + f.Location() + ")"
+		m.int(2, str(name))
+		m.int(3, str(name))
+		if f.File != "" {
+			m.int(4, str(f.File))
+		} else {
+			m.int(4, str(file))
+		}
+		m.int(5, int64(f.Pos.Line))
+		b.message(5, &m)
+	}
+
+	b.int(9, p.start.UnixNano())
+	b.int(10, int64(time.Since(p.start)))
+	var period protobuf
+	period.int(1, str("time"))
+	period.int(2, str("nanoseconds"))
+	b.message(11, &period)
+	b.int(12, 1)
+	b.int(14, str("time")) // the default sample type
+
+	// the string table comes last, when it holds all the strings
+	table := make([]string, len(index))
+	for s, i := range index {
+		table[i] = s
+	}
+	for _, s := range table {
+		b.bytes(6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// protobuf encodes a protocol buffer message, field by field.
+type protobuf struct {
+	buf []byte
+}
+
+// Wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protobuf) varint(v uint64) {
+	for v >= 0x80 {
+		b.buf = append(b.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	b.buf = append(b.buf, byte(v))
+}
+
+func (b *protobuf) key(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint encodes a uint64 field, which is left out when it is zero.
+func (b *protobuf) uint(field int, v uint64) {
+	if v != 0 {
+		b.key(field, wireVarint)
+		b.varint(v)
+	}
+}
+
+// int encodes an int64 field, which is left out when it is zero.
+func (b *protobuf) int(field int, v int64) {
+	b.uint(field, uint64(v))
+}
+
+func (b *protobuf) bytes(field int, v []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protobuf) message(field int, m *protobuf) {
+	b.bytes(field, m.buf)
+}
+
+// packed encodes a repeated integer field.
+func (b *protobuf) packed(field int, vs []uint64) {
+	var m protobuf
+	for _, v := range vs {
+		m.varint(v)
+	}
+	b.bytes(field, m.buf)
+}
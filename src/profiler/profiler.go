@@ -0,0 +1,243 @@
+// Package profiler measures where Monkey programs spend their time. A
+// Profiler traces the calls an interpreter makes and records, for every
+// function literal, how often it was called, the time spent in it and the
+// allocations made while evaluating it, and writes them as a table or as a
+// profile that `go tool pprof` reads.
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"runtime/metrics"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Function holds what was recorded for the functions created from the
+// function literal at Pos in File.
+type Function struct {
+	Name string // of the variable it was first called through, or "fn"
+	File string // the path of the module it is in, empty for the program
+	Pos  token.Position
+
+	Calls int
+
+	// Time and allocations are inclusive of the functions called, or
+	// exclusive of them. Recursive calls are counted once in the inclusive
+	// figures, and tail calls in those of the calls they replace.
+	Time, ExclusiveTime     time.Duration
+	Allocs, ExclusiveAllocs uint64
+}
+
+// Location returns where the function literal is: its position, after its
+// file if it is in a module.
+func (f *Function) Location() string {
+	if f.File == "" {
+		return f.Pos.String()
+	}
+	return f.File + ":" + f.Pos.String()
+}
+
+// literal identifies a function literal, as positions repeat across files.
+type literal struct {
+	file string
+	pos  token.Position
+}
+
+// allocInterval is how often the allocation counter is read while calls are
+// made. Reading it takes much longer than a call, so allocations made in
+// between are put down to the calls that start or end at the next reading.
+const allocInterval = time.Millisecond
+
+// frame is a call being evaluated.
+type frame struct {
+	fn     *Function
+	start  time.Time
+	allocs uint64 // allocated before the call
+
+	childTime   time.Duration
+	childAllocs uint64
+
+	// replaced holds the frames of the calls that made tail calls ending in
+	// this one, the first of each function only. Their inclusive figures
+	// are recorded when this call returns.
+	replaced []*frame
+}
+
+// sample holds what was recorded with one call stack.
+type sample struct {
+	stack  []*Function // innermost call first
+	calls  int
+	time   time.Duration
+	allocs uint64
+}
+
+// Profiler records the calls made by the programs of an interpreter created
+// with evaluator.WithTracer. Allocations are counted for the whole process,
+// so they include those of other goroutines running meanwhile.
+type Profiler struct {
+	start     time.Time
+	functions map[literal]*Function
+	active    map[*Function]int // calls on the stack
+	stack     []*frame
+	replaced  []*frame           // by the tail call about to be made
+	samples   map[string]*sample // keyed by the locations of their stack
+
+	allocs     []metrics.Sample
+	allocsRead time.Time
+}
+
+// New returns a profiler that has recorded nothing yet.
+func New() *Profiler {
+	return &Profiler{
+		start:     time.Now(),
+		functions: map[literal]*Function{},
+		active:    map[*Function]int{},
+		samples:   map[string]*sample{},
+		allocs:    []metrics.Sample{{Name: "/gc/heap/allocs:objects"}},
+	}
+}
+
+// Statement implements evaluator.Tracer.
+func (p *Profiler) Statement(stmt ast.Statement, env *object.Environment) error {
+	return nil
+}
+
+// Call implements evaluator.Tracer and starts timing a call of fn.
+func (p *Profiler) Call(fn *object.Function, call *ast.CallExpression, env *object.Environment) {
+	key := literal{fn.File, fn.Pos}
+	f, ok := p.functions[key]
+	if !ok {
+		f = &Function{Name: "fn", File: fn.File, Pos: fn.Pos}
+		if call != nil {
+			if ident, ok := call.Function.(*ast.Identifier); ok {
+				f.Name = ident.Value
+			}
+		}
+		p.functions[key] = f
+	}
+	f.Calls++
+	p.active[f]++
+	now := time.Now()
+	fr := &frame{fn: f, start: now, allocs: p.allocated(now, len(p.stack) == 0 && p.replaced == nil), replaced: p.replaced}
+	p.replaced = nil
+	p.stack = append(p.stack, fr)
+}
+
+// Return implements evaluator.Tracer and stops timing the call of fn. A nil
+// result means that a tail call replaces it, which is then timed as part of
+// it.
+func (p *Profiler) Return(fn *object.Function, result object.Object) {
+	if len(p.stack) == 0 {
+		return
+	}
+	now := time.Now()
+	allocated := p.allocated(now, len(p.stack) == 1 && result != nil)
+	fr := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	elapsed, allocs := now.Sub(fr.start), allocated-fr.allocs
+	f := fr.fn
+	if result == nil {
+		p.replaced = fr.replaced
+		if slices.ContainsFunc(p.replaced, func(r *frame) bool { return r.fn == f }) {
+			p.active[f]--
+		} else {
+			p.replaced = append(p.replaced, fr)
+		}
+	} else {
+		p.active[f]--
+		if p.active[f] == 0 {
+			f.Time += elapsed
+			f.Allocs += allocs
+		}
+		for _, r := range fr.replaced {
+			p.active[r.fn]--
+			if p.active[r.fn] == 0 {
+				r.fn.Time += now.Sub(r.start)
+				r.fn.Allocs += allocated - r.allocs
+			}
+		}
+	}
+	exclusiveTime, exclusiveAllocs := elapsed-fr.childTime, allocs-fr.childAllocs
+	f.ExclusiveTime += exclusiveTime
+	f.ExclusiveAllocs += exclusiveAllocs
+	if len(p.stack) > 0 {
+		parent := p.stack[len(p.stack)-1]
+		parent.childTime += elapsed
+		parent.childAllocs += allocs
+	}
+
+	s := p.sample(fr)
+	s.calls++
+	s.time += exclusiveTime
+	s.allocs += exclusiveAllocs
+}
+
+// sample returns the sample for the stack of fr, which was just popped.
+func (p *Profiler) sample(fr *frame) *sample {
+	var key strings.Builder
+	stack := []*Function{fr.fn}
+	key.WriteString(fr.fn.Location())
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		stack = append(stack, p.stack[i].fn)
+		key.WriteString("," + p.stack[i].fn.Location())
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &sample{stack: stack}
+		p.samples[key.String()] = s
+	}
+	return s
+}
+
+// allocated returns the number of objects allocated by the process, as read
+// at now if force is set or the last reading is older than allocInterval.
+func (p *Profiler) allocated(now time.Time, force bool) uint64 {
+	if force || now.Sub(p.allocsRead) >= allocInterval {
+		metrics.Read(p.allocs)
+		p.allocsRead = now
+	}
+	return p.allocs[0].Value.Uint64()
+}
+
+// Functions returns what was recorded for each function literal, in
+// decreasing order of exclusive time.
+func (p *Profiler) Functions() []Function {
+	functions := make([]Function, 0, len(p.functions))
+	for _, f := range p.functions {
+		functions = append(functions, *f)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		a, b := functions[i], functions[j]
+		if a.ExclusiveTime != b.ExclusiveTime {
+			return a.ExclusiveTime > b.ExclusiveTime
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line < b.Pos.Line
+		}
+		return a.Pos.Column < b.Pos.Column
+	})
+	return functions
+}
+
+// WriteTable writes the functions as a table with a row for each.
+func (p *Profiler) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "calls\ttime\tself time\tallocs\tself allocs\t  function")
+	for _, f := range p.Functions() {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t  %s (%s)\n",
+			f.Calls, f.Time, f.ExclusiveTime, f.Allocs, f.ExclusiveAllocs, f.Name, f.Location())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,311 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+const source = `let fib = fn(n) {
+  if (n < 2) { return n; }
+  fib(n - 1) + fib(n - 2)
+};
+let double = fn(x) { x * 2 };
+let twice = fn(n) { double(double(n)) + 0 };
+let f = fn(x) { twice(x) }; f(1); f(2);
+fib(10);
+`
+
+func profile(t *testing.T, input string) *Profiler {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	prof := New()
+	in := evaluator.New(evaluator.WithTracer(prof))
+	if result := in.Eval(program, object.NewEnvironment()); result.Type() == object.ERROR_OBJ {
+		t.Fatalf("evaluation failed: %s", result.Inspect())
+	}
+	return prof
+}
+
+func TestFunctions(t *testing.T) {
+	start := time.Now()
+	prof := profile(t, source)
+	elapsed := time.Since(start)
+
+	tests := map[string]struct {
+		calls int
+		pos   string
+	}{
+		"fib":    {177, "1:11"},
+		"double": {4, "5:14"},
+		"twice":  {2, "6:13"},
+		"f":      {2, "7:9"},
+	}
+	functions := prof.Functions()
+	if len(functions) != len(tests) {
+		t.Fatalf("wrong number of functions. got=%d", len(functions))
+	}
+	for _, f := range functions {
+		tt, ok := tests[f.Name]
+		if !ok {
+			t.Errorf("unexpected function %s", f.Name)
+			continue
+		}
+		if f.Calls != tt.calls || f.Pos.String() != tt.pos {
+			t.Errorf("wrong %s. got calls=%d pos=%s, want calls=%d pos=%s", f.Name, f.Calls, f.Pos, tt.calls, tt.pos)
+		}
+		if f.ExclusiveTime > f.Time || f.ExclusiveAllocs > f.Allocs {
+			t.Errorf("%s has more exclusive than inclusive figures: %+v", f.Name, f)
+		}
+		// recursive calls are counted once
+		if f.Time > elapsed {
+			t.Errorf("%s took %s, longer than the program", f.Name, f.Time)
+		}
+	}
+
+	// twice only calls double, not in tail position, so its inclusive figures
+	// cover double's
+	var twice, double Function
+	for _, f := range functions {
+		switch f.Name {
+		case "twice":
+			twice = f
+		case "double":
+			double = f
+		}
+	}
+	if twice.Time < twice.ExclusiveTime+double.Time || twice.Allocs < twice.ExclusiveAllocs+double.Allocs {
+		t.Errorf("twice does not include double. twice=%+v double=%+v", twice, double)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	prof := profile(t, `let count = fn(n) { if (n == 0) { return 0; } count(n - 1) };
+let run = fn() { count(1000) };
+run();
+`)
+
+	functions := map[string]Function{}
+	for _, f := range prof.Functions() {
+		functions[f.Name] = f
+	}
+	run, count := functions["run"], functions["count"]
+	if run.Calls != 1 || count.Calls != 1001 {
+		t.Fatalf("wrong calls. run=%d count=%d", run.Calls, count.Calls)
+	}
+	// count replaces run on the stack, and each call of count the one
+	// before, so their inclusive figures last until the last call returns
+	if run.Time < run.ExclusiveTime+count.Time || run.Allocs < run.ExclusiveAllocs+count.Allocs {
+		t.Errorf("run does not include count. run=%+v count=%+v", run, count)
+	}
+	if count.Time < count.ExclusiveTime || count.Allocs < count.ExclusiveAllocs {
+		t.Errorf("count has more exclusive than inclusive figures: %+v", count)
+	}
+	if len(prof.stack) != 0 || len(prof.replaced) != 0 {
+		t.Errorf("calls left open. stack=%d replaced=%d", len(prof.stack), len(prof.replaced))
+	}
+	for f, n := range prof.active {
+		if n != 0 {
+			t.Errorf("%s still active %d times", f.Name, n)
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	profile(t, source).WriteTable(&out)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("wrong number of lines. got=%q", out.String())
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "calls time self time allocs self allocs function" {
+		t.Errorf("wrong header. got=%q", lines[0])
+	}
+	// fib takes longest, so comes first
+	if fields := strings.Fields(lines[1]); fields[0] != "177" || strings.Join(fields[5:], " ") != "fib (1:11)" {
+		t.Errorf("wrong row for fib. got=%q", lines[1])
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "m.mk"), []byte("export let g = fn(x) { x * 2 };"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// f is at the same position in the program as g in the module
+	program := parser.New(lexer.New("let f = fn(x) { x + 1 };\nimport \"m.mk\" as m;\nf(1) + m.g(2) + m.g(3);")).ParseProgram()
+
+	prof := New()
+	in := evaluator.New(evaluator.WithTracer(prof), evaluator.WithModulePaths(dir))
+	if result := in.Eval(program, object.NewEnvironment()); result.Type() == object.ERROR_OBJ {
+		t.Fatalf("evaluation failed: %s", result.Inspect())
+	}
+
+	calls := map[string]int{}
+	for _, f := range prof.Functions() {
+		calls[f.Name+" "+f.Location()] = f.Calls
+	}
+	module, _ := filepath.EvalSymlinks(filepath.Join(dir, "m.mk"))
+	want := map[string]int{"f 1:9": 1, "fn " + module + ":1:16": 2} // called through a selector
+	if len(calls) != len(want) {
+		t.Fatalf("wrong functions. got=%v, want=%v", calls, want)
+	}
+	for name, n := range want {
+		if calls[name] != n {
+			t.Errorf("wrong calls of %s. got=%d, want=%d", name, calls[name], n)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := prof.WritePprof(&out, "main.mk"); err != nil {
+		t.Fatalf("WritePprof failed: %s", err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %s", err)
+	}
+	data, _ := io.ReadAll(gz)
+	var strs []string
+	for _, f := range decode(t, data) {
+		if f.number == 6 {
+			strs = append(strs, string(f.bytes))
+		}
+	}
+	for _, s := range []string{"main.mk", module, "f (1:9)", "fn (" + module + ":1:16)"} {
+		if !strings.Contains(strings.Join(strs, "\n"), s) {
+			t.Errorf("string table lacks %q. got=%q", s, strs)
+		}
+	}
+}
+
+// field is a field of a protocol buffer message.
+type field struct {
+	number int
+	value  uint64 // of varints
+	bytes  []byte // of other fields
+}
+
+// decode decodes the varint and length-delimited fields of a message.
+func decode(t *testing.T, data []byte) []field {
+	t.Helper()
+	var fields []field
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		f := field{number: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(data)
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			f.bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func varints(data []byte) []uint64 {
+	var vs []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		vs, data = append(vs, v), data[n:]
+	}
+	return vs
+}
+
+func TestWritePprof(t *testing.T) {
+	var out bytes.Buffer
+	if err := profile(t, source).WritePprof(&out, "test.mk"); err != nil {
+		t.Fatalf("WritePprof failed: %s", err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %s", err)
+	}
+	data, _ := io.ReadAll(gz)
+
+	var strs []string
+	var sampleTypes, samples, locations, functions [][]byte
+	defaultType := -1
+	for _, f := range decode(t, data) {
+		switch f.number {
+		case 1:
+			sampleTypes = append(sampleTypes, f.bytes)
+		case 2:
+			samples = append(samples, f.bytes)
+		case 4:
+			locations = append(locations, f.bytes)
+		case 5:
+			functions = append(functions, f.bytes)
+		case 6:
+			strs = append(strs, string(f.bytes))
+		case 14:
+			defaultType = int(f.value)
+		}
+	}
+
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table does not start with \"\". got=%q", strs)
+	}
+	var types []string
+	for _, st := range sampleTypes {
+		fields := decode(t, st)
+		types = append(types, strs[fields[0].value]+"/"+strs[fields[1].value])
+	}
+	if got := strings.Join(types, " "); got != "calls/count time/nanoseconds allocations/count" {
+		t.Errorf("wrong sample types. got=%q", got)
+	}
+	if defaultType < 0 || strs[defaultType] != "time" {
+		t.Errorf("wrong default sample type %d", defaultType)
+	}
+	if len(locations) != 4 || len(functions) != 4 {
+		t.Errorf("wrong number of locations and functions. got=%d, %d", len(locations), len(functions))
+	}
+
+	names := map[uint64]string{}
+	for _, fn := range functions {
+		fields := decode(t, fn)
+		names[fields[0].value] = strs[fields[1].value]
+		if file := strs[fields[3].value]; file != "test.mk" {
+			t.Errorf("wrong file name %q", file)
+		}
+	}
+
+	// the calls in each stack add up to the calls of each function
+	calls := map[string]uint64{}
+	for _, s := range samples {
+		fields := decode(t, s)
+		stack, values := varints(fields[0].bytes), varints(fields[1].bytes)
+		if len(values) != 3 {
+			t.Fatalf("wrong number of values. got=%d", len(values))
+		}
+		calls[names[stack[0]]] += values[0]
+	}
+	want := map[string]uint64{"fib (1:11)": 177, "double (5:14)": 4, "twice (6:13)": 2, "f (7:9)": 2}
+	for name, n := range want {
+		if calls[name] != n {
+			t.Errorf("wrong calls of %s. got=%d, want=%d", name, calls[name], n)
+		}
+	}
+}
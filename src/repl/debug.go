@@ -0,0 +1,225 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"monkey/ast"
+	"monkey/debugger"
+	"monkey/object"
+)
+
+const DEBUG_PROMPT = "(debug) "
+
+const debugHelp = `commands:
+  run, r                  start the program, or restart it
+  break, b LINE [if EXPR] set a breakpoint, with a condition
+  clear LINE              remove the breakpoint on LINE
+  breakpoints             list the breakpoints
+  continue, c             resume until the next breakpoint
+  step, s                 step to the next statement, into calls
+  next, n                 step to the next statement, over calls
+  out, o                  step out of the current function
+  stack, bt               print the call stack
+  frame, f N              select frame N of the stack
+  locals, l               print the environments of the selected frame
+  print, p EXPR           evaluate EXPR in the selected frame
+  list                    print the source around the current line
+  quit, q                 stop the program and quit
+`
+
+// Debug runs a debugging session for program, whose source is source, with
+// commands read from in.
+func Debug(in io.Reader, out io.Writer, d *debugger.Debugger, program *ast.Program, source string) {
+	s := &debugSession{
+		out:      out,
+		debugger: d,
+		program:  program,
+		lines:    strings.Split(source, "\n"),
+	}
+	defer d.Stop()
+
+	fmt.Fprintln(out, `Type "help" for a list of commands.`)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, DEBUG_PROMPT)
+		if !scanner.Scan() {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if command == "quit" || command == "q" {
+			return
+		}
+		s.command(command, strings.TrimSpace(arg))
+	}
+}
+
+type debugSession struct {
+	out      io.Writer
+	debugger *debugger.Debugger
+	program  *ast.Program
+	lines    []string
+	frame    int
+}
+
+func (s *debugSession) command(command, arg string) {
+	d := s.debugger
+	switch command {
+	case "":
+	case "help", "h":
+		io.WriteString(s.out, debugHelp)
+
+	case "run", "r":
+		d.Stop()
+		s.stopped(d.Start(s.program, object.NewEnvironment(), false))
+
+	case "break", "b":
+		lineArg, condition, _ := strings.Cut(arg, " if ")
+		line, err := strconv.Atoi(strings.TrimSpace(lineArg))
+		if err != nil || line < 1 {
+			fmt.Fprintf(s.out, "invalid line %q\n", lineArg)
+			return
+		}
+		if err := d.SetBreakpoint("", line, condition); err != nil {
+			fmt.Fprintf(s.out, "invalid condition: %s\n", err)
+			return
+		}
+		fmt.Fprintf(s.out, "breakpoint on line %d\n", line)
+
+	case "clear":
+		line, err := strconv.Atoi(arg)
+		if err != nil || !d.ClearBreakpoint("", line) {
+			fmt.Fprintf(s.out, "no breakpoint on line %q\n", arg)
+		}
+
+	case "breakpoints":
+		for _, bp := range d.Breakpoints() {
+			fmt.Fprintf(s.out, "line %d", bp.Line)
+			if bp.Condition != "" {
+				fmt.Fprintf(s.out, " if %s", bp.Condition)
+			}
+			fmt.Fprintf(s.out, " (hit %d times)\n", bp.Hits)
+		}
+
+	case "continue", "c", "step", "s", "next", "n", "out", "o":
+		if !d.Paused() {
+			io.WriteString(s.out, "the program is not running\n")
+			return
+		}
+		switch command {
+		case "continue", "c":
+			s.stopped(d.Continue())
+		case "step", "s":
+			s.stopped(d.StepIn())
+		case "next", "n":
+			s.stopped(d.StepOver())
+		default:
+			s.stopped(d.StepOut())
+		}
+
+	case "stack", "bt":
+		for i, frame := range d.Stack() {
+			marker := " "
+			if i == s.frame {
+				marker = "*"
+			}
+			fmt.Fprintf(s.out, "%s #%d %s at %s\n", marker, i, frame.Name, where(frame.File, frame.Pos.Line))
+		}
+
+	case "frame", "f":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n >= len(d.Stack()) {
+			fmt.Fprintf(s.out, "no frame %q\n", arg)
+			return
+		}
+		s.frame = n
+		s.list(d.Stack()[n].File, d.Stack()[n].Pos.Line, 0)
+
+	case "locals", "l":
+		stack := d.Stack()
+		if stack == nil {
+			io.WriteString(s.out, "the program is not running\n")
+			return
+		}
+		// the environments from the frame's outwards, as closures see them
+		for env, depth := stack[s.frame].Env, 0; env != nil; env, depth = env.Outer(), depth+1 {
+			if depth > 0 {
+				fmt.Fprintf(s.out, "-- enclosing environment %d\n", depth)
+			}
+			for _, b := range debugger.Bindings(env) {
+				fmt.Fprintf(s.out, "%s = %s\n", b.Name, inspect(b.Value))
+			}
+		}
+
+	case "print", "p":
+		result, err := d.Evaluate(s.frame, arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "ERROR: %s\n", err)
+			return
+		}
+		fmt.Fprintln(s.out, result.Inspect())
+
+	case "list":
+		if stack := d.Stack(); stack != nil {
+			s.list(stack[s.frame].File, stack[s.frame].Pos.Line, 5)
+		}
+
+	default:
+		fmt.Fprintf(s.out, "unknown command %q, type \"help\" for a list\n", command)
+	}
+}
+
+// stopped reports why the program stopped.
+func (s *debugSession) stopped(event debugger.Event) {
+	s.frame = 0
+	if event.Reason == debugger.ReasonExited {
+		if event.Result != nil {
+			fmt.Fprintf(s.out, "program exited: %s\n", event.Result.Inspect())
+		} else {
+			fmt.Fprintln(s.out, "program exited")
+		}
+		return
+	}
+	fmt.Fprintf(s.out, "stopped at %s (%s)\n", where(event.File, event.Pos.Line), event.Reason)
+	s.list(event.File, event.Pos.Line, 0)
+}
+
+// where describes line of file, a module or the program if it is empty.
+func where(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// list prints the source lines from context lines before line to context
+// lines after it, marking line. Only the source of the program is at hand,
+// so nothing is printed for a line of a module file.
+func (s *debugSession) list(file string, line, context int) {
+	if file != "" {
+		return
+	}
+	for i := max(line-context, 1); i <= min(line+context, len(s.lines)); i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(s.out, "%s %4d  %s\n", marker, i, s.lines[i-1])
+	}
+}
+
+// inspect returns the Inspect output of a value, shortened to its first line
+// for functions.
+func inspect(obj object.Object) string {
+	if fn, ok := obj.(*object.Function); ok {
+		params := make([]string, len(fn.Parameters))
+		for i, p := range fn.Parameters {
+			params[i] = p.Value
+		}
+		return "fn(" + strings.Join(params, ", ") + ")"
+	}
+	return obj.Inspect()
+}
@@ -12,9 +12,17 @@ import (
 
 const PROMPT = ">> "
 
+// Start runs a REPL with an interpreter with the default options, whose
+// `puts` writes to out.
 func Start(in io.Reader, out io.Writer) {
+	StartWith(in, out, evaluator.New(evaluator.WithOutput(out)))
+}
+
+// StartWith runs a REPL that evaluates its input with interpreter.
+func StartWith(in io.Reader, out io.Writer, interpreter *evaluator.Interpreter) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
 
 	for {
 		fmt.Fprint(out, PROMPT)
@@ -35,7 +43,14 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := interpreter.ExpandMacros(program, macroEnv)
+		if err != nil {
+			io.WriteString(out, "ERROR: "+err.Error()+"\n")
+			continue
+		}
+
+		evaluated := interpreter.Eval(expanded, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"monkey/evaluator"
+	"monkey/testrunner"
+)
+
+// runTest implements `monkey test`, which runs the tests in the *_test.mk
+// files at the paths given, or under the current directory.
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	run := flags.String("run", "", "only run the tests whose names match `regexp`")
+	verbose := flags.Bool("v", false, "report every test and its output")
+	junit := flags.String("junit", "", "write the results in the JUnit XML format to `file`")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey test [-v] [-run regexp] [-junit file] [paths]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	runner := &testrunner.Runner{
+		Out:     os.Stdout,
+		Verbose: *verbose,
+		Options: []evaluator.Option{evaluator.WithFileAccess(evaluator.FileAccess{Roots: []string{"."}})},
+	}
+	if *run != "" {
+		filter, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey test: invalid -run: %s\n", err)
+			return 2
+		}
+		runner.Filter = filter
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := testrunner.Files(paths...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey test: %s\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "monkey test: no test files")
+		return 0
+	}
+
+	report := runner.Run(files)
+	if *junit != "" && !writeFile(*junit, report.WriteJUnit) {
+		return 1
+	}
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package testrunner
+
+import (
+	"fmt"
+	"strings"
+
+	"monkey/object"
+)
+
+// builtins returns the assertions of tests.
+func (t *tester) builtins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		// assert(condition[, message]) fails unless condition is truthy.
+		"assert": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) < 1 || len(args) > 2 {
+					return wrongArguments("assert", args, "1 or 2")
+				}
+				if truthy(args[0]) {
+					return object.NULL
+				}
+				return t.fail("assert", args[1:], fmt.Sprintf("got %s", describe(args[0])))
+			},
+		},
+
+		// assert_eq(got, want[, message]) fails unless got and want have the
+		// same type and Inspect output.
+		"assert_eq": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) < 2 || len(args) > 3 {
+					return wrongArguments("assert_eq", args, "2 or 3")
+				}
+				got, want := args[0], args[1]
+				if got.Type() == want.Type() && got.Inspect() == want.Inspect() {
+					return object.NULL
+				}
+				return t.fail("assert_eq", args[2:], difference(got, want))
+			},
+		},
+
+		// assert_error(fn[, substring]) calls fn and fails unless it returns
+		// an error, whose message contains substring if it is given.
+		"assert_error": {
+			Fn: func(args ...object.Object) object.Object {
+				if len(args) < 1 || len(args) > 2 {
+					return wrongArguments("assert_error", args, "1 or 2")
+				}
+				if _, ok := args[0].(*object.Function); !ok {
+					if _, ok := args[0].(*object.Builtin); !ok {
+						return &object.Error{Message: fmt.Sprintf("argument to `assert_error` must be FUNCTION, got %s", args[0].Type())}
+					}
+				}
+				var substring string
+				if len(args) == 2 {
+					s, ok := args[1].(*object.String)
+					if !ok {
+						return &object.Error{Message: fmt.Sprintf("second argument to `assert_error` must be STRING, got %s", args[1].Type())}
+					}
+					substring = s.Value
+				}
+
+				result := t.interpreter.Call(args[0])
+				errObj, ok := result.(*object.Error)
+				switch {
+				case !ok:
+					return t.fail("assert_error", nil, fmt.Sprintf("no error, got %s", describe(result)))
+				case !strings.Contains(errObj.Message, substring):
+					return t.fail("assert_error", nil, fmt.Sprintf("error %q does not contain %q", errObj.Message, substring))
+				}
+				return object.NULL
+			},
+		},
+	}
+}
+
+// fail returns the error of a failed assertion, with the message that the
+// test passed as its last argument, if any, and details.
+func (t *tester) fail(name string, message []object.Object, details string) *object.Error {
+	text := name + " failed"
+	if len(message) > 0 {
+		text += ": " + message[0].Inspect()
+	}
+	err := &object.Error{Message: text + "\n" + details, Fatal: true}
+	t.failures[err] = true
+	return err
+}
+
+func wrongArguments(name string, args []object.Object, want string) *object.Error {
+	return &object.Error{Message: fmt.Sprintf("wrong number of arguments to `%s`. got=%d, want=%s", name, len(args), want)}
+}
+
+func truthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return false
+	case *object.Boolean:
+		return obj.Value
+	}
+	return true
+}
+
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "nothing"
+	}
+	return fmt.Sprintf("%s %s", obj.Type(), obj.Inspect())
+}
+
+// difference describes how got differs from want, with a line diff of their
+// Inspect output, in which lines only of want start with "-" and those only
+// of got with "+".
+func difference(got, want object.Object) string {
+	var out strings.Builder
+	if got.Type() != want.Type() {
+		fmt.Fprintf(&out, "got %s, want %s\n", got.Type(), want.Type())
+	}
+	out.WriteString("--- want\n+++ got\n")
+	out.WriteString(diff(strings.Split(want.Inspect(), "\n"), strings.Split(got.Inspect(), "\n")))
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// diff returns the lines of a and b, prefixed with "-" if they are only in
+// a, "+" if they are only in b and " " if they are in both, in an order
+// that keeps the longest common subsequence of lines in both.
+func diff(a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&out, "  %s\n", a[i])
+			i, j = i+1, j+1
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&out, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,105 @@
+package testrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The JUnit XML format, as read by continuous integration servers.
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the report in the JUnit XML format, with a test suite
+// for each file. Files that could not be tested have a single test that
+// failed with an error.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	var suites junitSuites
+	var total time.Duration
+	for _, s := range r.Suites {
+		suite := junitSuite{Name: s.File, Time: seconds(s.Time)}
+		if s.Err != nil {
+			suite.Cases = []junitCase{{
+				Name:      "setup",
+				ClassName: s.File,
+				Time:      seconds(0),
+				Error:     problem(s.Err.Error()),
+			}}
+			suite.Errors++
+		}
+		for _, c := range s.Cases {
+			jc := junitCase{Name: c.Name, ClassName: s.File, Time: seconds(c.Time), SystemOut: c.Output}
+			switch c.Status {
+			case Failed:
+				jc.Failure = problem(c.Message)
+				suite.Failures++
+			case Errored:
+				jc.Error = problem(c.Message)
+				suite.Errors++
+			}
+			suite.Cases = append(suite.Cases, jc)
+		}
+		suite.Tests = len(suite.Cases)
+
+		suites.Suites = append(suites.Suites, suite)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		total += s.Time
+	}
+	suites.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// problem returns a failure or error whose message is the first line of
+// text.
+func problem(text string) *junitProblem {
+	message, _, _ := strings.Cut(text, "\n")
+	return &junitProblem{Message: message, Text: text}
+}
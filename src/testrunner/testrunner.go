@@ -0,0 +1,304 @@
+// Package testrunner runs tests written in Monkey. Tests are the top-level
+// functions whose names start with "test_" in files whose names end in
+// "_test.mk". Each test is run in an environment of its own, in which the
+// file is evaluated before the test is called, so tests do not see each
+// other's changes. Tests check their results with the builtins assert,
+// assert_eq and assert_error, and fail if they return an error.
+package testrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+)
+
+// Status is the outcome of a test.
+type Status int
+
+const (
+	Passed  Status = iota
+	Failed         // an assertion failed
+	Errored        // evaluation failed otherwise
+)
+
+func (s Status) String() string {
+	switch s {
+	case Passed:
+		return "PASS"
+	case Failed:
+		return "FAIL"
+	}
+	return "ERROR"
+}
+
+// Case is the result of a test.
+type Case struct {
+	Name    string
+	Pos     token.Position // of the test function
+	Status  Status
+	Message string // why it did not pass
+	Output  string // of puts
+	Time    time.Duration
+}
+
+// Suite is the result of the tests in a file. Err is set if the file could
+// not be read or parsed, in which case it has no tests.
+type Suite struct {
+	File  string
+	Cases []*Case
+	Err   error
+	Time  time.Duration
+}
+
+// Failed reports whether a test in the suite did not pass, or the file could
+// not be tested.
+func (s *Suite) Failed() bool {
+	if s.Err != nil {
+		return true
+	}
+	for _, c := range s.Cases {
+		if c.Status != Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// Report is the result of the tests in files.
+type Report struct {
+	Suites []*Suite
+}
+
+// Failed reports whether a suite of the report failed.
+func (r *Report) Failed() bool {
+	for _, s := range r.Suites {
+		if s.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Runner runs tests.
+type Runner struct {
+	// Filter selects the tests to run by name. A nil Filter selects all.
+	Filter *regexp.Regexp
+
+	// Out receives the progress of the tests, in the style of `go test`. In
+	// verbose mode, every test is reported with its output, otherwise only
+	// those that did not pass.
+	Out     io.Writer
+	Verbose bool
+
+	// Options configure the interpreters running the tests. Each interpreter
+	// also imports modules from the directory of its test file.
+	Options []evaluator.Option
+}
+
+// Files returns the test files at paths, which are either test files or
+// directories searched for them recursively, skipping directories whose
+// names start with ".".
+func Files(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && strings.HasSuffix(d.Name(), "_test.mk") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Run runs the tests in files and reports their results.
+func (r *Runner) Run(files []string) *Report {
+	report := &Report{}
+	for _, file := range files {
+		suite := r.runFile(file)
+		report.Suites = append(report.Suites, suite)
+		r.report(suite)
+	}
+	if r.Out != nil {
+		if report.Failed() {
+			fmt.Fprintln(r.Out, "FAIL")
+		} else if r.Verbose {
+			fmt.Fprintln(r.Out, "PASS")
+		}
+	}
+	return report
+}
+
+func (r *Runner) runFile(file string) *Suite {
+	start := time.Now()
+	suite := &Suite{File: file}
+	defer func() { suite.Time = time.Since(start) }()
+
+	source, err := os.ReadFile(file)
+	if err != nil {
+		suite.Err = err
+		return suite
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		suite.Err = fmt.Errorf("%s: %s", file, strings.Join(p.Errors(), "\n"))
+		return suite
+	}
+
+	t := &tester{failures: map[*object.Error]bool{}}
+	opts := append(slices.Clip(r.Options), evaluator.WithBuiltins(t.builtins()), evaluator.WithOutput(&t.out),
+		evaluator.WithModulePaths(filepath.Dir(file)))
+	t.interpreter = evaluator.New(opts...)
+
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+	expanded, err := t.interpreter.ExpandMacros(program, macros)
+	if err != nil {
+		suite.Err = fmt.Errorf("%s: %s", file, err)
+		return suite
+	}
+	if errors := t.interpreter.Resolve(expanded, object.NewEnvironment()); len(errors) != 0 {
+		suite.Err = fmt.Errorf("%s: %s", file, strings.Join(errors, "\n"))
+		return suite
+	}
+
+	for _, test := range tests(expanded) {
+		if r.Filter != nil && !r.Filter.MatchString(test.Name.Value) {
+			continue
+		}
+		suite.Cases = append(suite.Cases, t.run(expanded, test))
+	}
+	return suite
+}
+
+// tests returns the top-level let statements binding functions whose names
+// start with "test_".
+func tests(program *ast.Program) []*ast.LetStatement {
+	var lets []*ast.LetStatement
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let.Name == nil || !strings.HasPrefix(let.Name.Value, "test_") {
+			continue
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			lets = append(lets, let)
+		}
+	}
+	return lets
+}
+
+// tester runs the tests of a file.
+type tester struct {
+	interpreter *evaluator.Interpreter
+	out         bytes.Buffer
+
+	// failures are the errors returned by failed assertions, which tell
+	// them apart from other errors.
+	failures map[*object.Error]bool
+}
+
+// run evaluates program in a new environment and calls the test bound by
+// test in it.
+func (t *tester) run(program *ast.Program, test *ast.LetStatement) *Case {
+	c := &Case{Name: test.Name.Value, Pos: test.Value.Pos()}
+	start := time.Now()
+	t.out.Reset()
+
+	env := object.NewEnvironment()
+	result := t.interpreter.Eval(program, env)
+	if !isError(result) {
+		fn, _ := env.Get(test.Name.Value)
+		if f, ok := fn.(*object.Function); ok && len(f.Parameters) != 0 {
+			result = &object.Error{Message: "test functions take no arguments"}
+		} else {
+			result = t.interpreter.Call(fn)
+		}
+	}
+
+	c.Time = time.Since(start)
+	c.Output = t.out.String()
+	if errObj, ok := result.(*object.Error); ok {
+		c.Status, c.Message = Errored, errObj.Message
+		if t.failures[errObj] {
+			c.Status = Failed
+		}
+	}
+	return c
+}
+
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
+
+// report writes the results of suite to r.Out.
+func (r *Runner) report(suite *Suite) {
+	if r.Out == nil {
+		return
+	}
+	if suite.Err != nil {
+		fmt.Fprintf(r.Out, "%s\nFAIL\t%s [setup failed]\n", suite.Err, suite.File)
+		return
+	}
+
+	for _, c := range suite.Cases {
+		if c.Status == Passed && !r.Verbose {
+			continue
+		}
+		fmt.Fprintf(r.Out, "--- %s: %s (%s:%s) (%.3fs)\n", c.Status, c.Name, suite.File, c.Pos, c.Time.Seconds())
+		if c.Output != "" && (r.Verbose || c.Status != Passed) {
+			writeIndented(r.Out, c.Output)
+		}
+		if c.Message != "" {
+			writeIndented(r.Out, c.Message)
+		}
+	}
+
+	switch {
+	case suite.Failed():
+		fmt.Fprintf(r.Out, "FAIL\t%s\t%.3fs\n", suite.File, suite.Time.Seconds())
+	case len(suite.Cases) == 0:
+		fmt.Fprintf(r.Out, "ok  \t%s\t%.3fs [no tests to run]\n", suite.File, suite.Time.Seconds())
+	default:
+		fmt.Fprintf(r.Out, "ok  \t%s\t%.3fs\n", suite.File, suite.Time.Seconds())
+	}
+}
+
+func writeIndented(w io.Writer, text string) {
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
@@ -0,0 +1,249 @@
+package testrunner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testsMk = `let counter = [];
+let add = fn(a, b) { a + b };
+let helper = fn() { 1 };
+
+let test_add = fn() {
+  assert_eq(add(1, 2), 3);
+};
+
+let test_isolated = fn() {
+  let counter = len(counter) + 1;
+  assert_eq(counter, 1, "counter is fresh");
+};
+
+let test_eq = fn() {
+  puts("checking");
+  assert_eq([1, 2], [1, 3], "lists");
+};
+
+let test_types = fn() { assert_eq("1", 1) };
+let test_error = fn() { assert_error(fn() { 1 / 0 }, "division") };
+let test_no_error = fn() { assert_error(fn() { 1 }) };
+let test_wrong_error = fn() { assert_error(fn() { 1 / 0 }, "overflow") };
+let test_crash = fn() { 1 + "a" };
+let test_assert = fn() { assert(1 > 2) };
+let test_args = fn(x) { x };
+let test_not_a_function = 1;
+`
+
+// write writes files, by their paths relative to dir, to dir.
+func write(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"a_test.mk":          "",
+		"a.mk":               "",
+		"sub/b_test.mk":      "",
+		"sub/deep/c_test.mk": "",
+		".hidden/d_test.mk":  "",
+	})
+
+	files, err := Files(dir, filepath.Join(dir, "a.mk"))
+	if err != nil {
+		t.Fatalf("Files failed: %s", err)
+	}
+	var got []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{"a.mk", "a_test.mk", "sub/b_test.mk", "sub/deep/c_test.mk"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong files. got=%q, want=%q", got, want)
+	}
+
+	if _, err := Files(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("no error for a missing path")
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{"math_test.mk": testsMk})
+
+	var out bytes.Buffer
+	runner := &Runner{Out: &out}
+	report := runner.Run([]string{filepath.Join(dir, "math_test.mk")})
+	if !report.Failed() {
+		t.Errorf("report did not fail")
+	}
+
+	tests := []struct {
+		name    string
+		status  Status
+		message string
+	}{
+		{"test_add", Passed, ""},
+		{"test_isolated", Passed, ""},
+		{"test_eq", Failed, "assert_eq failed: lists\n--- want\n+++ got\n- [1, 3]\n+ [1, 2]"},
+		{"test_types", Failed, "assert_eq failed\ngot STRING, want INTEGER\n--- want\n+++ got\n  1"},
+		{"test_error", Passed, ""},
+		{"test_no_error", Failed, "assert_error failed\nno error, got INTEGER 1"},
+		{"test_wrong_error", Failed, "assert_error failed\nerror \"division by zero\" does not contain \"overflow\""},
+		{"test_crash", Errored, "type mismatch: INTEGER + STRING"},
+		{"test_assert", Failed, "assert failed\ngot BOOLEAN false"},
+		{"test_args", Errored, "test functions take no arguments"},
+	}
+	cases := report.Suites[0].Cases
+	if len(cases) != len(tests) {
+		t.Fatalf("wrong number of tests. got=%d, want=%d", len(cases), len(tests))
+	}
+	for i, tt := range tests {
+		c := cases[i]
+		if c.Name != tt.name || c.Status != tt.status || c.Message != tt.message {
+			t.Errorf("tests[%d] wrong. got=%s %s %q, want=%s %s %q", i, c.Name, c.Status, c.Message, tt.name, tt.status, tt.message)
+		}
+	}
+	if cases[2].Output != "checking\n" {
+		t.Errorf("wrong output of test_eq. got=%q", cases[2].Output)
+	}
+
+	for _, want := range []string{
+		"--- FAIL: test_eq (" + filepath.Join(dir, "math_test.mk") + ":14:15) (",
+		"    checking\n    assert_eq failed: lists\n",
+		"--- ERROR: test_crash",
+		"FAIL\t" + filepath.Join(dir, "math_test.mk"),
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q. got:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "test_add") {
+		t.Errorf("output reports a passing test without -v:\n%s", out.String())
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"math_test.mk":   testsMk,
+		"broken_test.mk": "let test_x = fn() { nope };",
+		"syntax_test.mk": "let = ;",
+	})
+
+	var out bytes.Buffer
+	runner := &Runner{Out: &out, Verbose: true, Filter: regexp.MustCompile("^test_(add|error)$")}
+	report := runner.Run([]string{filepath.Join(dir, "math_test.mk")})
+	if report.Failed() {
+		t.Errorf("report failed:\n%s", out.String())
+	}
+	var names []string
+	for _, c := range report.Suites[0].Cases {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, " "); got != "test_add test_error" {
+		t.Errorf("wrong tests run. got=%q", got)
+	}
+	if !strings.Contains(out.String(), "--- PASS: test_add") || !strings.HasSuffix(out.String(), "PASS\n") {
+		t.Errorf("verbose output does not report passing tests:\n%s", out.String())
+	}
+
+	// files whose names are not defined or that do not parse fail to set up
+	for _, name := range []string{"broken_test.mk", "syntax_test.mk"} {
+		report := (&Runner{}).Run([]string{filepath.Join(dir, name)})
+		if s := report.Suites[0]; s.Err == nil || len(s.Cases) != 0 || !report.Failed() {
+			t.Errorf("%s did not fail to set up. got=%+v", name, s)
+		}
+	}
+}
+
+func TestRunImports(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"lib.mk":         "export let double = fn(x) { x * 2 };",
+		"double_test.mk": `import { double } from "lib.mk"; let test_double = fn() { assert_eq(double(2), 4) };`,
+	})
+
+	var out bytes.Buffer
+	report := (&Runner{Out: &out}).Run([]string{filepath.Join(dir, "double_test.mk")})
+	if report.Failed() {
+		t.Errorf("report failed:\n%s", out.String())
+	}
+}
+
+func TestFailuresAreNotCaught(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"try_test.mk": `let test_try = fn() { try { assert_eq(1, 2) } catch (e) { 0 } };`,
+	})
+
+	report := (&Runner{Out: io.Discard}).Run([]string{filepath.Join(dir, "try_test.mk")})
+	if c := report.Suites[0].Cases[0]; c.Status != Failed {
+		t.Errorf("a failed assertion was caught. got=%s %q", c.Status, c.Message)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"x", "x", "  x\n"},
+		{"x", "y", "- x\n+ y\n"},
+		{"a\nb\nc", "a\nc\nd", "  a\n- b\n  c\n+ d\n"},
+	}
+	for _, tt := range tests {
+		if got := diff(strings.Split(tt.a, "\n"), strings.Split(tt.b, "\n")); got != tt.want {
+			t.Errorf("wrong diff of %q and %q. got=%q, want=%q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"math_test.mk":   testsMk,
+		"broken_test.mk": "let test_x = fn() { nope };",
+	})
+
+	report := (&Runner{}).Run([]string{filepath.Join(dir, "broken_test.mk"), filepath.Join(dir, "math_test.mk")})
+	var out bytes.Buffer
+	if err := report.WriteJUnit(&out); err != nil {
+		t.Fatalf("WriteJUnit failed: %s", err)
+	}
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Errorf("no XML header:\n%s", out.String())
+	}
+
+	var suites junitSuites
+	if err := xml.Unmarshal(out.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %s\n%s", err, out.String())
+	}
+	if suites.Tests != 11 || suites.Failures != 5 || suites.Errors != 3 || len(suites.Suites) != 2 {
+		t.Errorf("wrong totals. got tests=%d failures=%d errors=%d suites=%d",
+			suites.Tests, suites.Failures, suites.Errors, len(suites.Suites))
+	}
+	eq := suites.Suites[1].Cases[2]
+	if eq.Name != "test_eq" || eq.Failure == nil || eq.Failure.Message != "assert_eq failed: lists" || eq.SystemOut != "checking\n" {
+		t.Errorf("wrong test_eq. got=%+v", eq)
+	}
+	if setup := suites.Suites[0].Cases[0]; setup.Error == nil || !strings.Contains(setup.Error.Message, "identifier not found: nope") {
+		t.Errorf("wrong setup error. got=%+v", setup)
+	}
+}
@@ -1,15 +1,35 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // where the token starts
+}
+
+// Position is a location in the source. Lines and columns count from 1, so
+// the zero Position means the location is unknown.
+type Position struct {
+	Line   int
+	Column int // in bytes
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // from // to the end of the line
 
 	// Identifiers + Literals
 	IDENT = "IDENT" // add, foobar, x, y ...
@@ -34,6 +54,10 @@ const (
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	DOT       = "."
+	ELLIPSIS  = "..."
+	ARROW     = "=>"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -48,18 +72,34 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	MACRO    = "MACRO"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+	MATCH    = "MATCH"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"true":   TRUE,
-	"false":  FALSE,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"true":    TRUE,
+	"false":   FALSE,
+	"macro":   MACRO,
+	"import":  IMPORT,
+	"export":  EXPORT,
+	"match":   MATCH,
 }
 
 func LookupIdentType(ident string) TokenType {